@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"created", "", "a\nb\n", "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"changed", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeUnifiedDiff(&buf, "a", "b", []byte(tt.a), []byte(tt.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("unexpected diff:\n%s\nexpected:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func writeManifest(t *testing.T, dir, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, "provision.yaml")
+	err := os.WriteFile(p, []byte(strings.ReplaceAll(content, "DIR", dir)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{"no steps", "steps: []\n", "no steps"},
+		{"unknown field", "steps:\n  - operation: getSubscriberMetadata\n    output: []\n", "field output not found"},
+		{"unknown operation", "steps:\n  - operation: unknown\n    outputs: [{path: DIR/a}]\n", "unknown operation name in step 1: unknown"},
+		{"no outputs", "steps:\n  - operation: getSubscriberMetadata\n", "no outputs in step getSubscriberMetadata"},
+		{"field and format", "steps:\n  - operation: getSubscriberMetadata\n    outputs: [{path: DIR/a, field: imsi, format: json}]\n", "field cannot be used with format"},
+		{"invalid format", "steps:\n  - operation: getSubscriberMetadata\n    outputs: [{path: DIR/a, format: xml}]\n", "unknown output format: xml"},
+		{"invalid mode", "steps:\n  - operation: getSubscriberMetadata\n    outputs: [{path: DIR/a, mode: rw}]\n", "invalid file mode: rw"},
+		{"duplicated path", "steps:\n  - operation: getSubscriberMetadata\n    outputs: [{path: DIR/a}]\n  - operation: getUserData\n    outputs: [{path: DIR/a}]\n", "is written by more than one output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadManifest(writeManifest(t, t.TempDir(), tt.manifest))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	dir := t.TempDir()
+	m, err := loadManifest(writeManifest(t, dir, `
+steps:
+  - name: metadata
+    operation: getSubscriberMetadata
+    params:
+      token: secret
+    renewBefore: 1h
+    outputs:
+      - path: DIR/imsi
+        field: imsi
+    postHooks:
+      - echo changed
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = writePlan(&buf, m, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `step metadata: getSubscriberMetadata {"token":"********"}
+  action: perform
+  output DIR/imsi: missing
+  post hook: echo changed
+`
+	if got := strings.ReplaceAll(buf.String(), dir, "DIR"); got != want {
+		t.Errorf("unexpected plan:\n%s", got)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "imsi"), []byte("001010000000001"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = writePlan(&buf, m, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "  action: skip: all outputs are valid\n") {
+		t.Errorf("unexpected plan:\n%s", buf.String())
+	}
+}
+
+// countingAuthenticator counts SIM authentications of the simulator. Like *endorse.Client, it does not implement
+// krypton.IMSIReader, so the key cache is not used.
+type countingAuthenticator struct {
+	krypton.Authenticator
+	count int
+}
+
+func (a *countingAuthenticator) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	a.count++
+	return a.Authenticator.DoAuthentication()
+}
+
+func TestApplyManifest(t *testing.T) {
+	userdata := `{"interval":60}`
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RequestParameters map[string]interface{} `json:"requestParameters"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/subscriber_metadata"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"imsi": "001010000000001", "params": body.RequestParameters})
+		case strings.HasSuffix(r.URL.Path, "/userdata"):
+			io.WriteString(w, userdata)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	a := &countingAuthenticator{Authenticator: kCfg.Authenticator}
+	kCfg.Authenticator = a
+
+	dir := t.TempDir()
+	m, err := loadManifest(writeManifest(t, dir, `
+steps:
+  - name: metadata
+    operation: getSubscriberMetadata
+    params:
+      name: device-1
+    outputs:
+      - path: DIR/imsi
+        field: imsi
+      - path: DIR/metadata.json
+        format: pretty
+        mode: "0644"
+  - operation: getUserData
+    outputs:
+      - path: DIR/userdata.json
+    postHooks:
+      - echo $KRYPTON_RUN_OPERATION >> DIR/hook.log
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "userdata.json"), []byte(`{"interval":30}`+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apply := func(diffOnly bool) string {
+		t.Helper()
+		var buf bytes.Buffer
+		err := applyManifest(&buf, m, kCfg, &appConfig{Apply: applyConfig{DiffOnly: diffOnly}, Hooks: hookConfig{Timeout: defaultHookTimeout}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.ReplaceAll(buf.String(), dir, "DIR")
+	}
+
+	want := `step metadata:
+  DIR/imsi: created
+--- /dev/null
++++ DIR/imsi
+@@ -0,0 +1 @@
++001010000000001
+  DIR/metadata.json: created
+--- /dev/null
++++ DIR/metadata.json
+@@ -0,0 +1,6 @@
++{
++  "imsi": "001010000000001",
++  "params": {
++    "name": "device-1"
++  }
++}
+step getUserData:
+  DIR/userdata.json: changed
+--- DIR/userdata.json
++++ DIR/userdata.json
+@@ -1 +1 @@
+-{"interval":30}
++{"interval":60}
+`
+	if got := apply(true); got != want {
+		t.Errorf("unexpected changes:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "imsi")); !os.IsNotExist(err) {
+		t.Error("file is written with -diff")
+	}
+	if got := apply(false); got != want {
+		t.Errorf("unexpected changes:\n%s", got)
+	}
+	if a.count != 2 {
+		t.Errorf("expected a single authentication for each apply, got %d in total", a.count)
+	}
+
+	b, _ := os.ReadFile(filepath.Join(dir, "imsi"))
+	if string(b) != "001010000000001" {
+		t.Errorf("unexpected content: %s", b)
+	}
+	st, _ := os.Stat(filepath.Join(dir, "metadata.json"))
+	if st.Mode().Perm() != 0644 {
+		t.Errorf("unexpected mode: %v", st.Mode())
+	}
+
+	// nothing changes, so the post hook is not run again
+	got := apply(false)
+	if !strings.Contains(got, "  DIR/userdata.json: unchanged\n") || strings.Contains(got, "---") {
+		t.Errorf("unexpected changes:\n%s", got)
+	}
+	b, _ = os.ReadFile(filepath.Join(dir, "hook.log"))
+	if string(b) != "getUserData\n" {
+		t.Errorf("post hook is not run once: %q", b)
+	}
+}
+
+func TestApplyManifestHidesSecrets(t *testing.T) {
+	cert, key := newTestCertificate(t, time.Now().Add(time.Hour))
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"certificate": cert, "privateKey": key, "token": "secret"})
+	}))
+
+	dir := t.TempDir()
+	m, err := loadManifest(writeManifest(t, dir, `
+steps:
+  - operation: bootstrapAwsIotThing
+    outputs:
+      - path: DIR/key.pem
+        field: privateKey
+      - path: DIR/result.json
+        format: pretty
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = applyManifest(&buf, m, kCfg, &appConfig{Apply: applyConfig{DiffOnly: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "PRIVATE KEY") || strings.Contains(out, `"secret"`) {
+		t.Errorf("secrets are shown:\n%s", out)
+	}
+	if !strings.Contains(out, "(the content is a secret; use -show-secrets to show the difference)") || !strings.Contains(out, `+  "token": "********"`) {
+		t.Errorf("unexpected changes:\n%s", out)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// command is a subcommand of krypton-cli, e.g. `krypton-cli doctor`. Subcommands accept the same flags as operations.
+type command struct {
+	Name        string
+	Description string
+	// Offline commands do not use the SIM, and the UICC interface is not opened for them.
+	Offline bool
+	Run     func(cc *commandContext) error
+}
+
+// commandContext is passed to command.Run.
+type commandContext struct {
+	appCfg     *appConfig
+	endorseCfg *endorse.Config
+	kryptonCfg *krypton.Config
+	// endorseClient is nil when endorseErr is set
+	endorseClient *endorse.Client
+	endorseErr    error
+	kc            *krypton.Client
+	// args are the arguments after the flags
+	args []string
+}
+
+var commands = map[string]*command{}
+
+func registerCommand(c *command) {
+	commands[c.Name] = c
+}
+
+// splitCommand returns the subcommand if args start with its name, and the rest of args.
+func splitCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			return c, args[1:]
+		}
+	}
+	return nil, args
+}
+
+// parseCommandArgs parses args allowing flags after the arguments of a command (e.g. `key-cache list -output json`),
+// and returns the arguments. Everything after "--" is returned as arguments as is.
+func parseCommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for i, a := range args {
+		if a == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	var result []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		result = append(result, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return append(result, rest...), nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-12s %s\n", name, commands[name].Description)
+	}
+	fmt.Fprintf(out, "\nWithout a command, the operation specified by -operation is performed.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
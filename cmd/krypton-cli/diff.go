@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines around the changes in a unified diff.
+const diffContextLines = 3
+
+// maxDiffCells limits the size of the LCS table. Larger contents are shown as replaced entirely.
+const maxDiffCells = 4 << 20
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// writeUnifiedDiff writes the difference of a and b in the unified format. Nothing is written when they are equal.
+func writeUnifiedDiff(w io.Writer, aName, bName string, a, b []byte) error {
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+	hunks := diffHunks(ops)
+	if len(hunks) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", aName, bName)
+	if err != nil {
+		return err
+	}
+	for _, h := range hunks {
+		_, err = fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+		if err != nil {
+			return err
+		}
+		for _, op := range ops[h.from:h.to] {
+			_, err = fmt.Fprintf(w, "%c%s\n", op.kind, op.line)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the edit script from a to b based on the longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+type diffHunk struct {
+	// from and to are the range in the edit script
+	from, to     int
+	aStart, aLen int
+	bStart, bLen int
+}
+
+// diffHunks groups the changes in ops with diffContextLines of context.
+func diffHunks(ops []diffOp) []diffHunk {
+	var hunks []diffHunk
+	for k := 0; k < len(ops); k++ {
+		if ops[k].kind == ' ' {
+			continue
+		}
+		from := k - diffContextLines
+		if from < 0 {
+			from = 0
+		}
+		// extend while the next change is within twice the context
+		to := k
+		for to < len(ops) {
+			if ops[to].kind != ' ' {
+				to++
+				continue
+			}
+			next := to
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-to > 2*diffContextLines {
+				to += min(next-to, diffContextLines)
+				break
+			}
+			to = next
+		}
+		if len(hunks) > 0 && from < hunks[len(hunks)-1].to {
+			from = hunks[len(hunks)-1].to
+		}
+		hunks = append(hunks, newDiffHunk(ops, from, to))
+		k = to - 1
+	}
+	return hunks
+}
+
+func newDiffHunk(ops []diffOp, from, to int) diffHunk {
+	h := diffHunk{from: from, to: to, aStart: 1, bStart: 1}
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			h.aStart++
+		}
+		if op.kind != '-' {
+			h.bStart++
+		}
+	}
+	for _, op := range ops[from:to] {
+		if op.kind != '+' {
+			h.aLen++
+		}
+		if op.kind != '-' {
+			h.bLen++
+		}
+	}
+	return h
+}
+
+// hunkRange formats the range of a hunk, where an empty range starts at the line before it as diff(1) does.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		start--
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const (
+	defaultProvisioningAPIEndpointURL = "https://g.api.soracom.io/"
+	// endorse-client-go uses the global SORACOM API unless -keys-api-endpoint-url is specified
+	defaultKeysAPIEndpointURL = "https://g.api.soracom.io/"
+
+	doctorTimeout        = 10 * time.Second
+	clockSkewWarning     = time.Minute
+	clockSkewFailure     = 5 * time.Minute
+	certExpiryWarningDay = 14
+)
+
+func init() {
+	registerCommand(&command{
+		Name:        "doctor",
+		Description: "check the UICC interface, the SIM, the modem and reachability of the API endpoints, and print a report",
+		Run:         runDoctor,
+	})
+}
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "pass"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+	checkSkip checkStatus = "skip"
+)
+
+type checkResult struct {
+	Name    string      `json:"name"`
+	Status  checkStatus `json:"status"`
+	Message string      `json:"message"`
+	// Category is the category of the failure, which decides the exit code.
+	Category krypton.ErrorCategory `json:"category,omitempty"`
+}
+
+// deviceProber is implemented by *endorse.Client.
+type deviceProber interface {
+	ListCOMPorts() ([]string, error)
+	GetDeviceInfo() (string, error)
+}
+
+type doctorEndpoint struct {
+	Name string
+	URL  *url.URL
+}
+
+// doctor runs the checks. Its dependencies are fields so that they can be replaced in tests.
+type doctor struct {
+	interfaceType endorse.UICCInterfaceType
+	portName      string
+	device        deviceProber
+	deviceErr     error
+	authenticate  func() (string, error)
+	endpoints     []doctorEndpoint
+
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	runCommand func(name string, args ...string) ([]byte, error)
+	now        func() time.Time
+}
+
+var interfaceTypeNames = map[endorse.UICCInterfaceType]string{
+	endorse.UICCInterfaceTypeNone:       "none",
+	endorse.UICCInterfaceTypeAutoDetect: "autoDetect",
+	endorse.UICCInterfaceTypeIso7816:    "iso7816",
+	endorse.UICCInterfaceTypeComm:       "comm",
+	endorse.UICCInterfaceTypeMmcli:      "mmcli",
+}
+
+func runDoctor(cc *commandContext) error {
+	d := &doctor{
+		interfaceType: cc.endorseCfg.UICCInterfaceType,
+		portName:      cc.endorseCfg.Serial.PortName,
+		deviceErr:     cc.endorseErr,
+		authenticate:  cc.kc.Authenticate,
+		endpoints:     doctorEndpoints(cc.kryptonCfg.ProvisioningAPIEndpointURL, cc.endorseCfg.KeysAPIEndpointURL),
+		lookupHost:    net.DefaultResolver.LookupHost,
+		httpClient:    &http.Client{Timeout: doctorTimeout},
+		runCommand: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+		now: time.Now,
+	}
+	if cc.endorseClient != nil {
+		d.device = cc.endorseClient
+	}
+
+	results := d.run()
+	err := writeDoctorReport(os.Stdout, cc.appCfg.Output.Format, results)
+	if err != nil {
+		return outputError(err)
+	}
+	return doctorError(results)
+}
+
+func doctorEndpoints(provisioning, keys *url.URL) []doctorEndpoint {
+	if provisioning == nil {
+		provisioning, _ = url.Parse(defaultProvisioningAPIEndpointURL)
+	}
+	if keys == nil {
+		keys, _ = url.Parse(defaultKeysAPIEndpointURL)
+	}
+	return []doctorEndpoint{
+		{Name: "provisioning", URL: provisioning},
+		{Name: "keys", URL: keys},
+	}
+}
+
+func (d *doctor) run() []checkResult {
+	results := []checkResult{d.checkInterface()}
+	results = append(results, d.checkPorts())
+	results = append(results, d.checkModem())
+	results = append(results, d.checkAuthentication())
+
+	checked := map[string]bool{}
+	for _, ep := range d.endpoints {
+		host := endpointHostPort(ep.URL)
+		if checked[host] {
+			continue
+		}
+		checked[host] = true
+		dns := d.checkDNS(ep)
+		results = append(results, dns)
+		if dns.Status == checkFail {
+			results = append(results, checkResult{Name: "tls:" + ep.Name, Status: checkSkip, Message: "DNS lookup failed"})
+			continue
+		}
+		results = append(results, d.checkTLS(ep))
+	}
+	return append(results, d.checkClock())
+}
+
+func (d *doctor) checkInterface() checkResult {
+	name, ok := interfaceTypeNames[d.interfaceType]
+	if !ok {
+		name = fmt.Sprintf("unknown (%d)", d.interfaceType)
+	}
+	if d.interfaceType == endorse.UICCInterfaceTypeComm {
+		if d.portName == "" {
+			return checkResult{Name: "interface", Status: checkFail, Message: "comm interface requires -port-name", Category: errorCategoryUsage}
+		}
+		name += " (" + d.portName + ")"
+	}
+	if d.deviceErr != nil {
+		return checkResult{Name: "interface", Status: checkFail, Message: fmt.Sprintf("%s: %v", name, d.deviceErr), Category: krypton.ErrorCategoryDevice}
+	}
+	return checkResult{Name: "interface", Status: checkPass, Message: name}
+}
+
+func (d *doctor) checkPorts() checkResult {
+	if d.device == nil {
+		return checkResult{Name: "ports", Status: checkSkip, Message: "UICC interface is not available"}
+	}
+	ports, err := d.device.ListCOMPorts()
+	if err != nil {
+		return checkResult{Name: "ports", Status: checkFail, Message: err.Error(), Category: krypton.ErrorCategoryDevice}
+	}
+	if d.interfaceType == endorse.UICCInterfaceTypeComm && !containsString(ports, d.portName) {
+		return checkResult{Name: "ports", Status: checkFail, Message: fmt.Sprintf("%s is not found in communication ports: %s", d.portName, strings.Join(ports, ", ")), Category: krypton.ErrorCategoryDevice}
+	}
+	if len(ports) == 0 {
+		return checkResult{Name: "ports", Status: checkWarn, Message: "no communication ports found"}
+	}
+	return checkResult{Name: "ports", Status: checkPass, Message: strings.Join(ports, ", ")}
+}
+
+func (d *doctor) checkModem() checkResult {
+	switch d.interfaceType {
+	case endorse.UICCInterfaceTypeComm:
+		if d.device == nil {
+			return checkResult{Name: "modem", Status: checkSkip, Message: "UICC interface is not available"}
+		}
+		info, err := d.device.GetDeviceInfo()
+		if err != nil {
+			return checkResult{Name: "modem", Status: checkFail, Message: err.Error(), Category: krypton.ErrorCategoryDevice}
+		}
+		return checkResult{Name: "modem", Status: checkPass, Message: oneLine(info)}
+	case endorse.UICCInterfaceTypeMmcli, endorse.UICCInterfaceTypeAutoDetect:
+		out, err := d.runCommand("mmcli", "-L")
+		if err != nil {
+			status := checkFail
+			if d.interfaceType == endorse.UICCInterfaceTypeAutoDetect {
+				status = checkWarn
+			}
+			return checkResult{Name: "modem", Status: status, Message: fmt.Sprintf("mmcli -L failed: %v %s", err, oneLine(string(out))), Category: categoryIf(status, krypton.ErrorCategoryDevice)}
+		}
+		return checkResult{Name: "modem", Status: checkPass, Message: oneLine(string(out))}
+	}
+	return checkResult{Name: "modem", Status: checkSkip, Message: "modem is not used with this interface"}
+}
+
+func (d *doctor) checkAuthentication() checkResult {
+	if d.deviceErr != nil {
+		return checkResult{Name: "authentication", Status: checkSkip, Message: "UICC interface is not available"}
+	}
+	keyID, err := d.authenticate()
+	if err != nil {
+		return checkResult{Name: "authentication", Status: checkFail, Message: err.Error(), Category: krypton.Category(err)}
+	}
+	return checkResult{Name: "authentication", Status: checkPass, Message: "keyId: " + keyID}
+}
+
+func (d *doctor) checkDNS(ep doctorEndpoint) checkResult {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	addrs, err := d.lookupHost(ctx, ep.URL.Hostname())
+	if err != nil {
+		return checkResult{Name: "dns:" + ep.Name, Status: checkFail, Message: err.Error(), Category: krypton.ErrorCategoryNetwork}
+	}
+	return checkResult{Name: "dns:" + ep.Name, Status: checkPass, Message: fmt.Sprintf("%s: %s", ep.URL.Hostname(), strings.Join(addrs, ", "))}
+}
+
+func (d *doctor) checkTLS(ep doctorEndpoint) checkResult {
+	name := "tls:" + ep.Name
+	if ep.URL.Scheme != "https" {
+		return checkResult{Name: name, Status: checkWarn, Message: ep.URL.String() + " is not HTTPS"}
+	}
+
+	cfg := &tls.Config{}
+	if d.tlsConfig != nil {
+		cfg = d.tlsConfig.Clone()
+	}
+	cfg.ServerName = ep.URL.Hostname()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: doctorTimeout}, "tcp", endpointHostPort(ep.URL), cfg)
+	if err != nil {
+		return checkResult{Name: name, Status: checkFail, Message: err.Error(), Category: krypton.ErrorCategoryNetwork}
+	}
+	defer conn.Close()
+
+	cert := conn.ConnectionState().PeerCertificates[0]
+	days := int(cert.NotAfter.Sub(d.now()).Hours() / 24)
+	msg := fmt.Sprintf("%s: certificate for %s expires in %d days", endpointHostPort(ep.URL), cert.Subject.CommonName, days)
+	if days < certExpiryWarningDay {
+		return checkResult{Name: name, Status: checkWarn, Message: msg}
+	}
+	return checkResult{Name: name, Status: checkPass, Message: msg}
+}
+
+func (d *doctor) checkClock() checkResult {
+	u := d.endpoints[0].URL
+	start := d.now()
+	resp, err := d.httpClient.Head(u.String())
+	if err != nil {
+		return checkResult{Name: "clock", Status: checkSkip, Message: "unable to get the time from " + u.Host}
+	}
+	resp.Body.Close()
+	rtt := d.now().Sub(start)
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return checkResult{Name: "clock", Status: checkSkip, Message: "no Date header in the response from " + u.Host}
+	}
+	// the Date header has a resolution of a second, so small skews are ignored
+	skew := start.Add(rtt / 2).Sub(serverTime)
+	msg := fmt.Sprintf("local clock differs from %s by %s", u.Host, skew.Round(time.Second))
+	switch {
+	case absDuration(skew) >= clockSkewFailure:
+		return checkResult{Name: "clock", Status: checkFail, Message: msg, Category: krypton.ErrorCategoryDevice}
+	case absDuration(skew) >= clockSkewWarning:
+		return checkResult{Name: "clock", Status: checkWarn, Message: msg}
+	}
+	return checkResult{Name: "clock", Status: checkPass, Message: msg}
+}
+
+func endpointHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func categoryIf(status checkStatus, category krypton.ErrorCategory) krypton.ErrorCategory {
+	if status == checkFail {
+		return category
+	}
+	return ""
+}
+
+// writeDoctorReport writes the results as a table, or as JSON with -output json / pretty.
+func writeDoctorReport(w io.Writer, format string, results []checkResult) error {
+	switch format {
+	case outputFormatJSON, outputFormatPretty:
+		report := struct {
+			OK     bool          `json:"ok"`
+			Checks []checkResult `json:"checks"`
+		}{
+			OK:     doctorError(results) == nil,
+			Checks: results,
+		}
+		var buf bytes.Buffer
+		e := json.NewEncoder(&buf)
+		if format == outputFormatPretty {
+			e.SetIndent("", "  ")
+		}
+		err := e.Encode(report)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	case outputFormatRaw, outputFormatTable:
+	default:
+		return errors.Errorf("output format %s is not supported by doctor", format)
+	}
+
+	counts := map[checkStatus]int{}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(string(r.Status)), r.Name, r.Message)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n", counts[checkPass], counts[checkWarn], counts[checkFail], counts[checkSkip])
+	return err
+}
+
+// doctorError returns an error with the category of the first failed check, or nil if no checks failed.
+func doctorError(results []checkResult) error {
+	failed := []string{}
+	category := krypton.ErrorCategoryUnknown
+	for _, r := range results {
+		if r.Status != checkFail {
+			continue
+		}
+		if len(failed) == 0 && r.Category != "" {
+			category = r.Category
+		}
+		failed = append(failed, r.Name)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &krypton.Error{Category: category, Err: errors.Errorf("%d checks failed: %s", len(failed), strings.Join(failed, ", "))}
+}
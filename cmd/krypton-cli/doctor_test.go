@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+type fakeDevice struct {
+	ports []string
+	info  string
+	err   error
+}
+
+func (d *fakeDevice) ListCOMPorts() ([]string, error) {
+	return d.ports, d.err
+}
+
+func (d *fakeDevice) GetDeviceInfo() (string, error) {
+	return d.info, d.err
+}
+
+func newTestDoctor(t *testing.T) *doctor {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+
+	return &doctor{
+		interfaceType: endorse.UICCInterfaceTypeComm,
+		portName:      "/dev/ttyUSB0",
+		device:        &fakeDevice{ports: []string{"/dev/ttyUSB0", "/dev/ttyUSB1"}, info: "Manufacturer: ACME\nModel: M1"},
+		authenticate: func() (string, error) {
+			return "test-key-id", nil
+		},
+		endpoints: []doctorEndpoint{{Name: "provisioning", URL: u}, {Name: "keys", URL: u}},
+		lookupHost: func(ctx context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		},
+		tlsConfig:  srv.Client().Transport.(*http.Transport).TLSClientConfig,
+		httpClient: srv.Client(),
+		runCommand: func(name string, args ...string) ([]byte, error) {
+			return nil, errors.New("must not be called")
+		},
+		now: time.Now,
+	}
+}
+
+func statuses(results []checkResult) string {
+	ss := []string{}
+	for _, r := range results {
+		ss = append(ss, r.Name+"="+string(r.Status))
+	}
+	return strings.Join(ss, " ")
+}
+
+func TestDoctor(t *testing.T) {
+	d := newTestDoctor(t)
+	results := d.run()
+	expected := "interface=pass ports=pass modem=pass authentication=pass dns:provisioning=pass tls:provisioning=pass clock=pass"
+	if statuses(results) != expected {
+		t.Errorf("unexpected results: %s\n%+v", statuses(results), results)
+	}
+	if doctorError(results) != nil {
+		t.Errorf("unexpected error: %v", doctorError(results))
+	}
+
+	var buf bytes.Buffer
+	err := writeDoctorReport(&buf, outputFormatRaw, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "PASS  modem             Manufacturer: ACME Model: M1") || !strings.HasSuffix(buf.String(), "\n7 passed, 0 warnings, 0 failed, 0 skipped\n") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestDoctorFailures(t *testing.T) {
+	d := newTestDoctor(t)
+	d.device = &fakeDevice{ports: []string{"/dev/ttyACM0"}}
+	d.authenticate = func() (string, error) {
+		return "", &krypton.Error{Category: krypton.ErrorCategoryAuthentication, Err: errors.New("authentication failed")}
+	}
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	d.tlsConfig = &tls.Config{}
+
+	results := d.run()
+	expected := "interface=pass ports=fail modem=pass authentication=fail dns:provisioning=fail tls:provisioning=skip clock=pass"
+	if statuses(results) != expected {
+		t.Errorf("unexpected results: %s\n%+v", statuses(results), results)
+	}
+
+	err := doctorError(results)
+	if krypton.Category(err) != krypton.ErrorCategoryDevice || exitCodeOf(err) != exitCodeDevice {
+		t.Errorf("unexpected error: %v (%s)", err, krypton.Category(err))
+	}
+
+	var buf bytes.Buffer
+	err = writeDoctorReport(&buf, outputFormatJSON, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		OK     bool          `json:"ok"`
+		Checks []checkResult `json:"checks"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || len(report.Checks) != len(results) || report.Checks[3].Category != krypton.ErrorCategoryAuthentication {
+		t.Errorf("unexpected report: %s", buf.String())
+	}
+}
+
+func TestDoctorDeviceUnavailable(t *testing.T) {
+	d := newTestDoctor(t)
+	d.interfaceType = endorse.UICCInterfaceTypeMmcli
+	d.device = nil
+	d.deviceErr = errors.New("mmcli not found")
+	d.runCommand = func(name string, args ...string) ([]byte, error) {
+		return []byte("error: couldn't find the ModemManager process"), errors.New("exit status 1")
+	}
+
+	results := d.run()
+	expected := "interface=fail ports=skip modem=fail authentication=skip dns:provisioning=pass tls:provisioning=pass clock=pass"
+	if statuses(results) != expected {
+		t.Errorf("unexpected results: %s\n%+v", statuses(results), results)
+	}
+}
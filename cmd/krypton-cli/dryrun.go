@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// dryRunOutput is a destination the result would be written to.
+type dryRunOutput struct {
+	Destination string `json:"destination"`
+	Format      string `json:"format,omitempty"`
+	Template    string `json:"template,omitempty"`
+	Query       string `json:"query,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Backup      bool   `json:"backup,omitempty"`
+}
+
+type dryRunReport struct {
+	*krypton.DryRunResult
+	LwM2MConfig string         `json:"lwm2mConfig,omitempty"`
+	Outputs     []dryRunOutput `json:"outputs"`
+}
+
+// dryRun prints the request the operation would send and where the result would be written.
+func dryRun(w io.Writer, appCfg *appConfig, kc *krypton.Client) error {
+	r, err := kc.DryRun(appCfg.Operation, appCfg.DryRunAuthenticate)
+	if err != nil {
+		return err
+	}
+
+	report := &dryRunReport{
+		DryRunResult: r,
+		LwM2MConfig:  string(appCfg.LwM2M.Format),
+		Outputs:      dryRunOutputs(appCfg),
+	}
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	err = e.Encode(report)
+	if err != nil {
+		return err
+	}
+	s := buf.String()
+	if !appCfg.ShowSecrets {
+		s = krypton.RedactSecrets(s)
+	}
+	_, err = io.WriteString(w, s)
+	return outputError(err)
+}
+
+func dryRunOutputs(appCfg *appConfig) []dryRunOutput {
+	oc := &appCfg.Output
+	fc := &appCfg.FileOutput
+	if !fc.enabled() {
+		return []dryRunOutput{{Destination: "stdout", Format: oc.Format, Template: oc.Template, Query: oc.Query}}
+	}
+
+	outputs := []dryRunOutput{}
+	file := func(path, format, template, query string) dryRunOutput {
+		return dryRunOutput{
+			Destination: path,
+			Format:      format,
+			Template:    template,
+			Query:       query,
+			Mode:        fmt.Sprintf("%#o", fc.Mode),
+			Owner:       fc.Owner,
+			Backup:      fc.Backup,
+		}
+	}
+	if fc.Path != "" {
+		outputs = append(outputs, file(fc.Path, oc.Format, oc.Template, oc.Query))
+	}
+	for _, fo := range fc.Fields {
+		outputs = append(outputs, file(fo.Path, "", "", fo.Query))
+	}
+	return outputs
+}
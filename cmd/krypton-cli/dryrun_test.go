@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func TestDryRun(t *testing.T) {
+	u, _ := url.Parse("https://api.example.com/")
+	kc, err := krypton.NewClient(&krypton.Config{
+		ProvisioningAPIEndpointURL: u,
+		RequestParameters:          `{"skipCertificates":false,"token":"secret"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		appCfg appConfig
+	}{
+		{"stdout", appConfig{Operation: "bootstrapAwsIotThing", Output: outputConfig{Format: outputFormatJSON}}},
+		{"files", appConfig{
+			Operation: "bootstrapAwsIotThing",
+			FileOutput: fileOutputConfig{
+				Path:   "/etc/aws/result.json",
+				Fields: fieldOutputs{{Query: "privateKey", Path: "/etc/aws/key.pem"}},
+				Mode:   0640,
+				Owner:  "root:aws",
+				Backup: true,
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := dryRun(&buf, &tt.appCfg, kc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("dryrun", tt.name+".golden"), buf.Bytes())
+		})
+	}
+}
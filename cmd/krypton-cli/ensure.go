@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const defaultRenewBefore = 24 * time.Hour
+
+type ensureStatus string
+
+const (
+	ensureUnchanged ensureStatus = "unchanged"
+	ensureCreated   ensureStatus = "created"
+	ensureRenewed   ensureStatus = "renewed"
+)
+
+type ensureConfig struct {
+	RenewBefore time.Duration
+}
+
+func init() {
+	registerCommand(&command{
+		Name:        "ensure",
+		Description: "perform the operation only when the files of -out and -out-field are missing, invalid or expiring within -renew-before, and print unchanged, created or renewed",
+		Run:         runEnsure,
+	})
+}
+
+// outputState is the state of an output file.
+type outputState struct {
+	Path string
+	// Missing is set when the file does not exist
+	Missing bool
+	// Problem is why the file must be replaced, or "" if it is valid
+	Problem   string
+	ExpiresAt time.Time
+}
+
+func runEnsure(cc *commandContext) error {
+	return ensure(os.Stdout, cc.appCfg, cc.kc, cc.endorseErr, time.Now())
+}
+
+// ensure performs the operation if needed, and prints the status. endorseErr is returned only when the SIM is needed.
+func ensure(w io.Writer, appCfg *appConfig, kc *krypton.Client, endorseErr error, now time.Time) error {
+	if appCfg.Operation == "" {
+		return usageError(errors.New("ensure requires -operation"))
+	}
+	if !appCfg.FileOutput.enabled() {
+		return usageError(errors.New("ensure requires -out or -out-field to check"))
+	}
+
+	states := checkOutputFiles(appCfg.FileOutput.paths(), appCfg.Ensure.RenewBefore, now)
+	status, reason := ensureDecision(states)
+	if status != ensureUnchanged {
+		if endorseErr != nil {
+			return endorseErr
+		}
+		log.Debugf("performing %s: %s", appCfg.Operation, reason)
+		err := performSpecifiedOperation(appCfg, kc)
+		if err != nil {
+			return err
+		}
+	}
+	return outputError(writeEnsureReport(w, status, reason))
+}
+
+func checkOutputFiles(paths []string, renewBefore time.Duration, now time.Time) []outputState {
+	states := make([]outputState, 0, len(paths))
+	for _, p := range paths {
+		s := outputState{Path: p}
+		b, err := os.ReadFile(p)
+		switch {
+		case os.IsNotExist(err):
+			s.Missing = true
+			s.Problem = "does not exist"
+		case err != nil:
+			s.Problem = err.Error()
+		default:
+			s.ExpiresAt, err = contentExpiry(b)
+			if err != nil {
+				s.Problem = err.Error()
+			} else if !s.ExpiresAt.IsZero() && !now.Add(renewBefore).Before(s.ExpiresAt) {
+				s.Problem = fmt.Sprintf("expires at %s, within %s", s.ExpiresAt.UTC().Format(time.RFC3339), renewBefore)
+			}
+		}
+		states = append(states, s)
+	}
+	return states
+}
+
+// ensureDecision returns whether the operation should be performed and why.
+func ensureDecision(states []outputState) (ensureStatus, string) {
+	for _, s := range states {
+		if s.Missing {
+			return ensureCreated, s.Path + " " + s.Problem
+		}
+	}
+	for _, s := range states {
+		if s.Problem != "" {
+			return ensureRenewed, s.Path + " " + s.Problem
+		}
+	}
+
+	var earliest time.Time
+	for _, s := range states {
+		if !s.ExpiresAt.IsZero() && (earliest.IsZero() || s.ExpiresAt.Before(earliest)) {
+			earliest = s.ExpiresAt
+		}
+	}
+	if earliest.IsZero() {
+		return ensureUnchanged, "all outputs are valid"
+	}
+	return ensureUnchanged, "all outputs are valid until " + earliest.UTC().Format(time.RFC3339)
+}
+
+func writeEnsureReport(w io.Writer, status ensureStatus, reason string) error {
+	_, err := fmt.Fprintf(w, "%s: %s\n", status, reason)
+	return err
+}
+
+// contentExpiry checks that the content of an output file can be parsed, and returns the earliest expiry found in
+// it, or the zero time if it does not expire. PEM certificates, JWTs and JSON fields such as expiration are examined.
+func contentExpiry(b []byte) (time.Time, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return time.Time{}, errors.New("is empty")
+	}
+	if bytes.HasPrefix(b, []byte("-----BEGIN ")) {
+		return pemExpiry(b)
+	}
+	var v interface{}
+	if json.Valid(b) {
+		err := json.Unmarshal(b, &v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return jsonExpiry(v), nil
+	}
+	if exp, ok := jwtExpiry(string(b)); ok {
+		return exp, nil
+	}
+	// other formats such as env or a template cannot be validated further
+	return time.Time{}, nil
+}
+
+func pemExpiry(b []byte) (time.Time, error) {
+	var expiresAt time.Time
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				expiresAt = earlier(expiresAt, cert.NotAfter)
+			}
+		case "PRIVATE KEY":
+			_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			_, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			_, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "has an invalid %s", block.Type)
+		}
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		return time.Time{}, errors.New("has an invalid PEM block")
+	}
+	return expiresAt, nil
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
+
+var expiryFields = map[string]bool{
+	"expiration": true,
+	"expiresat":  true,
+	"expiry":     true,
+	"expires":    true,
+}
+
+// jsonExpiry returns the earliest time in the expiry fields and the JWTs in v.
+func jsonExpiry(v interface{}) time.Time {
+	var expiresAt time.Time
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if expiryFields[strings.ToLower(k)] {
+				if t, ok := parseTimeValue(child); ok {
+					expiresAt = earlier(expiresAt, t)
+					continue
+				}
+			}
+			if t := jsonExpiry(child); !t.IsZero() {
+				expiresAt = earlier(expiresAt, t)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if t := jsonExpiry(child); !t.IsZero() {
+				expiresAt = earlier(expiresAt, t)
+			}
+		}
+	case string:
+		if t, ok := jwtExpiry(v); ok {
+			expiresAt = t
+		}
+	}
+	return expiresAt
+}
+
+// parseTimeValue parses RFC 3339 strings and UNIX time in seconds or milliseconds.
+func parseTimeValue(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	case float64:
+		if v > 1e11 {
+			return time.UnixMilli(int64(v)), true
+		}
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// jwtExpiry returns the exp claim if s is a JWT.
+func jwtExpiry(s string) (time.Time, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	var claims struct {
+		Exp *float64 `json:"exp"`
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &header) != nil || header.Alg == "" {
+		return time.Time{}, false
+	}
+	c, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(c, &claims) != nil || claims.Exp == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(*claims.Exp), 0), true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// newTestCertificate returns a self-signed certificate and its private key in PEM.
+func newTestCertificate(t *testing.T, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+func testJWT(exp int64) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	claims, _ := json.Marshal(map[string]int64{"exp": exp})
+	return enc([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc(claims) + ".c2lnbmF0dXJl"
+}
+
+func TestContentExpiry(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	cert, key := newTestCertificate(t, notAfter)
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		content string
+		want    time.Time
+		wantErr bool
+	}{
+		{"certificate", cert, notAfter, false},
+		{"certificate and key", cert + key, notAfter, false},
+		{"private key", key, time.Time{}, false},
+		{"invalid certificate", "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", time.Time{}, true},
+		{"truncated PEM", cert[:len(cert)/2], time.Time{}, true},
+		{"JWT", testJWT(exp.Unix()) + "\n", exp, false},
+		{"JSON", `{"credentials":{"accessKeyId":"AKIA","expiration":"2030-01-01T00:00:00Z"}}`, exp, false},
+		{"JSON epoch millis", `{"expiresAt":` + "1893456000000" + `}`, exp, false},
+		{"JSON with JWT", `{"token":"` + testJWT(exp.Unix()) + `"}`, exp, false},
+		{"JSON without expiry", `{"imsi":"001010000000001"}`, time.Time{}, false},
+		{"env", "AWS_ACCESS_KEY_ID=AKIA\n", time.Time{}, false},
+		{"empty", "\n", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := contentExpiry([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnsureDecision(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		err := os.WriteFile(p, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	valid, _ := newTestCertificate(t, now.Add(30*24*time.Hour))
+	expiring, _ := newTestCertificate(t, now.Add(time.Hour))
+	validPath := write("valid.pem", valid)
+	expiringPath := write("expiring.pem", expiring)
+	emptyPath := write("empty.pem", "")
+	missingPath := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name   string
+		paths  []string
+		status ensureStatus
+		reason string
+	}{
+		{"valid", []string{validPath}, ensureUnchanged, "all outputs are valid until 2030-01-31T00:00:00Z"},
+		{"expiring", []string{validPath, expiringPath}, ensureRenewed, expiringPath + " expires at 2030-01-01T01:00:00Z, within 24h0m0s"},
+		{"invalid", []string{emptyPath}, ensureRenewed, emptyPath + " is empty"},
+		{"missing", []string{expiringPath, missingPath}, ensureCreated, missingPath + " does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason := ensureDecision(checkOutputFiles(tt.paths, defaultRenewBefore, now))
+			if status != tt.status || reason != tt.reason {
+				t.Errorf("unexpected decision: %s: %s", status, reason)
+			}
+		})
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	cert, key := newTestCertificate(t, time.Now().Add(30*24*time.Hour))
+	requests := 0
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"certificate": cert, "privateKey": key})
+	}))
+	kc, err := krypton.NewClient(kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kc.Close()
+
+	dir := t.TempDir()
+	appCfg := &appConfig{
+		Operation: "bootstrapAwsIotThing",
+		FileOutput: fileOutputConfig{
+			Fields: fieldOutputs{
+				{Query: "certificate", Path: filepath.Join(dir, "cert.pem")},
+				{Query: "privateKey", Path: filepath.Join(dir, "key.pem")},
+			},
+			Mode: 0600,
+		},
+		Ensure: ensureConfig{RenewBefore: defaultRenewBefore},
+	}
+
+	run := func() string {
+		t.Helper()
+		var buf bytes.Buffer
+		err := ensure(&buf, appCfg, kc, nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	if out := run(); !strings.HasPrefix(out, "created: ") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if out := run(); !strings.HasPrefix(out, "unchanged: all outputs are valid until ") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+
+	// the SIM is not needed while the outputs are valid
+	var buf bytes.Buffer
+	if err = ensure(&buf, appCfg, nil, os.ErrNotExist, time.Now()); err != nil {
+		t.Errorf("SIM error is returned for valid outputs: %v", err)
+	}
+
+	expiring, _ := newTestCertificate(t, time.Now().Add(time.Hour))
+	err = os.WriteFile(filepath.Join(dir, "cert.pem"), []byte(expiring), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := run(); !strings.HasPrefix(out, "renewed: ") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
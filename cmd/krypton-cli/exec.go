@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	refreshNone    = "none"
+	refreshRestart = "restart"
+	refreshServe   = "serve"
+
+	defaultExecOperation = "generateAmazonCognitoSessionCredentials"
+	defaultRefreshBefore = 5 * time.Minute
+	// restartGracePeriod is how long the command is given to exit after SIGTERM before it is killed
+	restartGracePeriod = 30 * time.Second
+	// refreshRetryInterval is the interval of retries when the credentials cannot be refreshed
+	refreshRetryInterval = time.Minute
+)
+
+// minRefreshInterval prevents restarting the command too often when the credentials are short-lived.
+var minRefreshInterval = time.Minute
+
+type execConfig struct {
+	// Refresh is how the credentials are refreshed before they expire: none, restart or serve
+	Refresh       string
+	RefreshBefore time.Duration
+}
+
+func validateExecConfig(ec *execConfig) error {
+	switch ec.Refresh {
+	case refreshNone, refreshRestart, refreshServe:
+	default:
+		return errors.Errorf("unknown refresh mode: %s", ec.Refresh)
+	}
+	if ec.RefreshBefore < 0 {
+		return errors.New("-refresh-before must not be negative")
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		Name:        "exec",
+		Description: "run the command after -- with the credentials of -operation (default: " + defaultExecOperation + ") as AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and KRYPTON_RUN_ and KRYPTON_RESULT_ environment variables, and exit with its exit code",
+		Run:         runExec,
+	})
+}
+
+// awsEnvVars are removed from the environment of the command so that they do not mix with the injected credentials.
+var awsEnvVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+}
+
+// execCredentials is the result of the operation for the command.
+type execCredentials struct {
+	result []byte
+	// aws is nil when the result does not have AWS credentials
+	aws *awsCredentials
+	// expiresAt is the earliest expiry in the result, or zero if it does not expire
+	expiresAt time.Time
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Expiration      time.Time
+}
+
+// parseExecCredentials reads AWS credentials in the form of the result of generateAmazonCognitoSessionCredentials, i.e.
+// {"region": ..., "credentials": {"accessKeyId": ..., "secretAccessKey": ..., "sessionToken": ..., "expiration": ...}}
+func parseExecCredentials(result []byte) (*execCredentials, error) {
+	c := &execCredentials{result: result}
+	var err error
+	c.expiresAt, err = contentExpiry(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid result")
+	}
+
+	var r struct {
+		Region      string `json:"region"`
+		Credentials *struct {
+			AccessKeyID     string      `json:"accessKeyId"`
+			SecretAccessKey string      `json:"secretAccessKey"`
+			SessionToken    string      `json:"sessionToken"`
+			Expiration      interface{} `json:"expiration"`
+		} `json:"credentials"`
+	}
+	if json.Unmarshal(result, &r) != nil || r.Credentials == nil || r.Credentials.AccessKeyID == "" {
+		return c, nil
+	}
+	if r.Credentials.SecretAccessKey == "" {
+		return nil, errors.New("secretAccessKey is not found in the credentials")
+	}
+	c.aws = &awsCredentials{
+		AccessKeyID:     r.Credentials.AccessKeyID,
+		SecretAccessKey: r.Credentials.SecretAccessKey,
+		SessionToken:    r.Credentials.SessionToken,
+		Region:          r.Region,
+	}
+	if t, ok := parseTimeValue(r.Credentials.Expiration); ok {
+		c.aws.Expiration = t
+	}
+	return c, nil
+}
+
+// execEnvironment returns the environment of the command. With the credentials server, AWS SDKs get the credentials
+// from serverURL instead of AWS_ACCESS_KEY_ID and so on.
+func execEnvironment(hc *hookConfig, operation string, c *execCredentials, serverURL, serverToken string) ([]string, error) {
+	renv, err := resultEnvironment(hc.Env, c.result)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{}
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		if !containsString(awsEnvVars, name) {
+			env = append(env, e)
+		}
+	}
+	env = append(env, envRunPrefix+"OPERATION="+operation)
+	env = append(env, renv...)
+
+	if c.aws == nil {
+		return env, nil
+	}
+	if c.aws.Region != "" {
+		env = append(env, "AWS_REGION="+c.aws.Region, "AWS_DEFAULT_REGION="+c.aws.Region)
+	}
+	if serverURL != "" {
+		return append(env,
+			"AWS_CONTAINER_CREDENTIALS_FULL_URI="+serverURL,
+			"AWS_CONTAINER_AUTHORIZATION_TOKEN="+serverToken,
+		), nil
+	}
+	env = append(env,
+		"AWS_ACCESS_KEY_ID="+c.aws.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+c.aws.SecretAccessKey,
+	)
+	if c.aws.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+c.aws.SessionToken)
+	}
+	if !c.aws.Expiration.IsZero() {
+		env = append(env, "AWS_CREDENTIAL_EXPIRATION="+c.aws.Expiration.UTC().Format(time.RFC3339))
+	}
+	return env, nil
+}
+
+func runExec(cc *commandContext) error {
+	if len(cc.args) == 0 {
+		return usageError(errors.New("exec requires a command, e.g. krypton-cli exec -- ./app"))
+	}
+	if cc.endorseErr != nil {
+		return cc.endorseErr
+	}
+	operation := cc.appCfg.Operation
+	if operation == "" {
+		operation = defaultExecOperation
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	r := &execRunner{
+		cfg:       &cc.appCfg.Exec,
+		hooks:     &cc.appCfg.Hooks,
+		operation: operation,
+		args:      cc.args,
+		fetch: func() (*execCredentials, error) {
+			result, err := cc.kc.PerformOperationWithResult(operation)
+			if err != nil {
+				return nil, err
+			}
+			return parseExecCredentials(result)
+		},
+		signals: sigs,
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+	}
+	return r.run()
+}
+
+// execRunner runs the command with the credentials, and refreshes them as configured.
+type execRunner struct {
+	cfg       *execConfig
+	hooks     *hookConfig
+	operation string
+	args      []string
+	fetch     func() (*execCredentials, error)
+	// signals received by krypton-cli are forwarded to the command
+	signals <-chan os.Signal
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// childExitError makes krypton-cli exit with the exit code of the command.
+type childExitError struct {
+	Code int
+}
+
+func (e *childExitError) Error() string {
+	return fmt.Sprintf("command exited with %d", e.Code)
+}
+
+func (r *execRunner) run() error {
+	c, err := r.fetch()
+	if err != nil {
+		return err
+	}
+
+	var serverURL, serverToken string
+	if r.cfg.Refresh == refreshServe {
+		if c.aws == nil {
+			return usageError(errors.Errorf("-refresh serve requires AWS credentials, which %s does not return", r.operation))
+		}
+		s := &credentialsServer{fetch: r.fetch, refreshBefore: r.cfg.RefreshBefore, current: c}
+		serverURL, serverToken, err = s.start()
+		if err != nil {
+			return err
+		}
+		defer s.close()
+	}
+	if r.cfg.Refresh == refreshRestart && c.expiresAt.IsZero() {
+		return usageError(errors.Errorf("-refresh restart requires the result of %s to have an expiry", r.operation))
+	}
+
+	for {
+		env, err := execEnvironment(r.hooks, r.operation, c, serverURL, serverToken)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(r.args[0], r.args[1:]...)
+		cmd.Env = env
+		cmd.Stdin = r.stdin
+		cmd.Stdout = r.stdout
+		cmd.Stderr = r.stderr
+		err = cmd.Start()
+		if err != nil {
+			return errors.Wrapf(err, "unable to run %s", r.args[0])
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		var refresh <-chan time.Time
+		if r.cfg.Refresh == refreshRestart {
+			refresh = time.After(r.refreshDelay(c.expiresAt))
+		}
+		next, err := r.wait(cmd, done, refresh)
+		if err != nil || next == nil {
+			return err
+		}
+		c = next
+	}
+}
+
+// wait forwards signals until the command exits. When refresh fires, it fetches new credentials in the background
+// and stops the command, and returns them to restart the command.
+func (r *execRunner) wait(cmd *exec.Cmd, done <-chan error, refresh <-chan time.Time) (*execCredentials, error) {
+	type fetchResult struct {
+		c   *execCredentials
+		err error
+	}
+	var next *execCredentials
+	var fetched chan fetchResult
+	var kill <-chan time.Time
+	for {
+		select {
+		case err := <-done:
+			if next != nil {
+				log.Debugf("restarting %s with the new credentials", r.args[0])
+				return next, nil
+			}
+			return nil, commandExitError(cmd, err)
+		case sig := <-r.signals:
+			log.Debugf("forwarding %s to %s", sig, r.args[0])
+			cmd.Process.Signal(sig)
+		case <-refresh:
+			// signals are forwarded while fetching, and the result is dropped if the command exits meanwhile
+			fetched = make(chan fetchResult, 1)
+			go func(fetched chan<- fetchResult) {
+				c, err := r.fetch()
+				fetched <- fetchResult{c, err}
+			}(fetched)
+		case f := <-fetched:
+			fetched = nil
+			if f.err != nil {
+				log.Errorf("unable to refresh the credentials, retrying in %s: %v", refreshRetryInterval, f.err)
+				refresh = time.After(refreshRetryInterval)
+				continue
+			}
+			next = f.c
+			log.Debugf("stopping %s to restart it with the new credentials", r.args[0])
+			err := terminateProcess(cmd.Process)
+			if err != nil {
+				cmd.Process.Kill()
+			}
+			kill = time.After(restartGracePeriod)
+		case <-kill:
+			cmd.Process.Kill()
+		}
+	}
+}
+
+// refreshDelay returns when the credentials expiring at expiresAt should be refreshed.
+func (r *execRunner) refreshDelay(expiresAt time.Time) time.Duration {
+	d := time.Until(expiresAt.Add(-r.cfg.RefreshBefore))
+	if d < minRefreshInterval {
+		log.Warningf("the credentials expire at %s, which is too soon to be refreshed %s before", expiresAt.Format(time.RFC3339), r.cfg.RefreshBefore)
+		d = minRefreshInterval
+	}
+	return d
+}
+
+func commandExitError(cmd *exec.Cmd, err error) error {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return &childExitError{Code: exitStatus(ee.ProcessState)}
+	}
+	if err != nil {
+		return err
+	}
+	if code := cmd.ProcessState.ExitCode(); code != 0 {
+		return &childExitError{Code: code}
+	}
+	return nil
+}
+
+// credentialsServer serves the credentials to the AWS SDKs in the command as the container credentials provider,
+// and refreshes them when they expire within refreshBefore.
+type credentialsServer struct {
+	fetch         func() (*execCredentials, error)
+	refreshBefore time.Duration
+	token         string
+	server        *http.Server
+
+	mu      sync.Mutex
+	current *execCredentials
+}
+
+// start listens on the loopback address, and returns the URL and the authorization token.
+func (s *credentialsServer) start() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return "", "", err
+	}
+	s.token = hex.EncodeToString(b)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to start the credentials server")
+	}
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.server.Serve(l)
+	return "http://" + l.Addr().String() + "/", s.token, nil
+}
+
+func (s *credentialsServer) close() {
+	s.server.Close()
+}
+
+func (s *credentialsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.token)) != 1 {
+		http.Error(w, "invalid authorization token", http.StatusForbidden)
+		return
+	}
+	c, err := s.credentials()
+	if err != nil {
+		log.Errorf("unable to refresh the credentials: %v", err)
+		http.Error(w, "unable to refresh the credentials", http.StatusInternalServerError)
+		return
+	}
+	resp := struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token,omitempty"`
+		Expiration      string `json:"Expiration,omitempty"`
+	}{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		Token:           c.SessionToken,
+	}
+	if !c.Expiration.IsZero() {
+		resp.Expiration = c.Expiration.UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// credentials returns the current credentials, or new ones if they expire within refreshBefore.
+func (s *credentialsServer) credentials() (*awsCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp := s.current.aws.Expiration
+	if exp.IsZero() || time.Now().Add(s.refreshBefore).Before(exp) {
+		return s.current.aws, nil
+	}
+	log.Debugf("refreshing the credentials which expire at %s", exp.Format(time.RFC3339))
+	c, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if c.aws == nil {
+		return nil, errors.New("the result does not have AWS credentials")
+	}
+	s.current = c
+	return c.aws, nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// forwardedSignals are forwarded to the command of exec.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// terminateProcess kills the process since signals cannot be sent on this platform.
+func terminateProcess(p *os.Process) error {
+	return p.Kill()
+}
+
+func exitStatus(ps *os.ProcessState) int {
+	return ps.ExitCode()
+}
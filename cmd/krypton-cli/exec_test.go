@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func testCredentials(accessKeyID string, expiration time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"region":"ap-northeast-1","credentials":{"accessKeyId":%q,"secretAccessKey":"secret","sessionToken":"session","expiration":%d}}`, accessKeyID, expiration.Unix()))
+}
+
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func TestExecEnvironment(t *testing.T) {
+	t.Setenv("AWS_PROFILE", "default")
+	t.Setenv("AWS_SESSION_TOKEN", "stale")
+
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, err := parseExecCredentials(testCredentials("AKIA", exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.expiresAt.Equal(exp) || c.aws == nil || !c.aws.Expiration.Equal(exp) {
+		t.Fatalf("unexpected credentials: %+v", c)
+	}
+
+	env, err := execEnvironment(&hookConfig{}, "generateAmazonCognitoSessionCredentials", c, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := envMap(env)
+	want := map[string]string{
+		"AWS_ACCESS_KEY_ID":                        "AKIA",
+		"AWS_SECRET_ACCESS_KEY":                    "secret",
+		"AWS_SESSION_TOKEN":                        "session",
+		"AWS_REGION":                               "ap-northeast-1",
+		"AWS_DEFAULT_REGION":                       "ap-northeast-1",
+		"AWS_CREDENTIAL_EXPIRATION":                "2030-01-01T00:00:00Z",
+		"KRYPTON_RUN_OPERATION":                    "generateAmazonCognitoSessionCredentials",
+		"KRYPTON_RESULT_CREDENTIALS_ACCESS_KEY_ID": "AKIA",
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, m[k])
+		}
+	}
+	for _, k := range []string{"AWS_PROFILE", "KRYPTON_RESULT_CREDENTIALS_SECRET_ACCESS_KEY", "AWS_CONTAINER_CREDENTIALS_FULL_URI"} {
+		if _, ok := m[k]; ok {
+			t.Errorf("%s is set", k)
+		}
+	}
+
+	env, err = execEnvironment(&hookConfig{}, "generateAmazonCognitoSessionCredentials", c, "http://127.0.0.1:1234/", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = envMap(env)
+	if m["AWS_CONTAINER_CREDENTIALS_FULL_URI"] != "http://127.0.0.1:1234/" || m["AWS_CONTAINER_AUTHORIZATION_TOKEN"] != "token" {
+		t.Errorf("credentials server is not set: %v", m)
+	}
+	if _, ok := m["AWS_ACCESS_KEY_ID"]; ok {
+		t.Error("AWS_ACCESS_KEY_ID is set with the credentials server")
+	}
+
+	c, err = parseExecCredentials([]byte(`{"imsi":"001010000000001"}`))
+	if err != nil || c.aws != nil {
+		t.Errorf("unexpected credentials: %+v, %v", c, err)
+	}
+}
+
+func TestExecEnvironmentIsNotReadAsFlags(t *testing.T) {
+	// fields and -hook-env named after the flags -operation and -params
+	c, err := parseExecCredentials([]byte(`{"operation":"getUserData","params":{"name":"device-1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := execEnvironment(&hookConfig{Env: hookEnvs{{Name: "PARAMS", Query: "params"}}}, "getSubscriberMetadata", c, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars []string
+	for _, e := range env {
+		if strings.HasPrefix(e, envPrefix) {
+			vars = append(vars, e)
+		}
+	}
+
+	// krypton-cli run by the command
+	pf := parseFlagsInChild(t, vars)
+	if pf.Error != "operation must be specified" {
+		t.Errorf("the operation is taken from the environment of the command: %+v", pf)
+	}
+	pf = parseFlagsInChild(t, vars, "-operation", "getSubscriberMetadata")
+	if pf.Error != "" || pf.RequestParameters != "" {
+		t.Errorf("the parameters are taken from the environment of the command: %+v", pf)
+	}
+}
+
+func TestExec(t *testing.T) {
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(testCredentials("AKIA", time.Now().Add(time.Hour)))
+	}))
+	kc, err := krypton.NewClient(kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kc.Close()
+
+	var stdout bytes.Buffer
+	r := &execRunner{
+		cfg:       &execConfig{Refresh: refreshNone},
+		hooks:     &hookConfig{},
+		operation: defaultExecOperation,
+		args:      []string{"sh", "-c", "echo $AWS_ACCESS_KEY_ID $AWS_REGION; exit 3"},
+		fetch: func() (*execCredentials, error) {
+			result, err := kc.PerformOperationWithResult(defaultExecOperation)
+			if err != nil {
+				return nil, err
+			}
+			return parseExecCredentials(result)
+		},
+		stdout: &stdout,
+		stderr: io.Discard,
+	}
+	err = r.run()
+	if exitCodeOf(err) != 3 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if stdout.String() != "AKIA ap-northeast-1\n" {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	r.args = []string{"krypton-test-command-not-found"}
+	if err = r.run(); err == nil {
+		t.Error("missing command is run")
+	}
+}
+
+// notifyingWriter closes ready on the first write.
+type notifyingWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	ready chan struct{}
+}
+
+func (w *notifyingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		close(w.ready)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *notifyingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestExecForwardsSignals(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	stdout := &notifyingWriter{ready: make(chan struct{})}
+	r := &execRunner{
+		cfg:       &execConfig{Refresh: refreshNone},
+		hooks:     &hookConfig{},
+		operation: defaultExecOperation,
+		args:      []string{"sh", "-c", "trap 'exit 7' TERM; echo ready; while :; do sleep 0.05; done"},
+		fetch: func() (*execCredentials, error) {
+			return parseExecCredentials(testCredentials("AKIA", time.Now().Add(time.Hour)))
+		},
+		signals: sigs,
+		stdout:  stdout,
+		stderr:  io.Discard,
+	}
+	go func() {
+		<-stdout.ready
+		sigs <- syscall.SIGTERM
+	}()
+	err := r.run()
+	if exitCodeOf(err) != 7 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExecRestartsWithNewCredentials(t *testing.T) {
+	defer func(d time.Duration) { minRefreshInterval = d }(minRefreshInterval)
+	minRefreshInterval = 10 * time.Millisecond
+
+	fetched := 0
+	var stdout bytes.Buffer
+	r := &execRunner{
+		cfg:       &execConfig{Refresh: refreshRestart, RefreshBefore: time.Minute},
+		hooks:     &hookConfig{},
+		operation: defaultExecOperation,
+		args: []string{"sh", "-c", `echo $AWS_ACCESS_KEY_ID; [ "$AWS_ACCESS_KEY_ID" = AKIA2 ] && exit 0
+trap 'exit 0' TERM; while :; do sleep 0.05; done`},
+		fetch: func() (*execCredentials, error) {
+			fetched++
+			// the first credentials are refreshed soon
+			return parseExecCredentials(testCredentials(fmt.Sprintf("AKIA%d", fetched), time.Now().Add(time.Minute+100*time.Millisecond)))
+		},
+		stdout: &stdout,
+		stderr: io.Discard,
+	}
+	err := r.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "AKIA1\nAKIA2\n" {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestExecForwardsSignalsWhileRefreshing(t *testing.T) {
+	defer func(d time.Duration) { minRefreshInterval = d }(minRefreshInterval)
+	minRefreshInterval = 10 * time.Millisecond
+
+	sigs := make(chan os.Signal, 1)
+	stdout := &notifyingWriter{ready: make(chan struct{})}
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	fetched := 0
+	r := &execRunner{
+		cfg:       &execConfig{Refresh: refreshRestart, RefreshBefore: time.Minute},
+		hooks:     &hookConfig{},
+		operation: defaultExecOperation,
+		args:      []string{"sh", "-c", "trap 'exit 7' TERM; echo ready; while :; do sleep 0.05; done"},
+		fetch: func() (*execCredentials, error) {
+			fetched++
+			if fetched > 1 {
+				// the refresh hangs, e.g. on a slow modem
+				close(fetching)
+				<-release
+			}
+			return parseExecCredentials(testCredentials("AKIA", time.Now().Add(time.Minute+10*time.Millisecond)))
+		},
+		signals: sigs,
+		stdout:  stdout,
+		stderr:  io.Discard,
+	}
+	go func() {
+		<-stdout.ready
+		<-fetching
+		sigs <- syscall.SIGTERM
+	}()
+	result := make(chan error, 1)
+	go func() {
+		result <- r.run()
+	}()
+	select {
+	case err := <-result:
+		if exitCodeOf(err) != 7 {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the signal is not forwarded while refreshing the credentials")
+	}
+}
+
+func TestCredentialsServer(t *testing.T) {
+	fetched := 0
+	s := &credentialsServer{
+		fetch: func() (*execCredentials, error) {
+			fetched++
+			return parseExecCredentials(testCredentials("AKIA2", time.Now().Add(time.Hour)))
+		},
+		refreshBefore: 5 * time.Minute,
+	}
+	var err error
+	s.current, err = parseExecCredentials(testCredentials("AKIA1", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, token, err := s.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	get := func(token string) (int, map[string]string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var m map[string]string
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp.StatusCode, m
+	}
+
+	if status, _ := get("wrong"); status != http.StatusForbidden {
+		t.Errorf("wrong token is accepted: %d", status)
+	}
+	status, m := get(token)
+	if status != http.StatusOK || m["AccessKeyId"] != "AKIA1" || m["SecretAccessKey"] != "secret" || m["Token"] != "session" || m["Expiration"] == "" {
+		t.Errorf("unexpected response: %d %v", status, m)
+	}
+	if fetched != 0 {
+		t.Error("credentials are fetched before they expire")
+	}
+
+	s.current, _ = parseExecCredentials(testCredentials("AKIA1", time.Now().Add(time.Minute)))
+	if _, m = get(token); m["AccessKeyId"] != "AKIA2" || fetched != 1 {
+		t.Errorf("credentials are not refreshed: %v", m)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// Exit codes of krypton-cli. Keep README.md in sync when changing them.
+const (
+	exitCodeOK                = 0
+	exitCodeUnknown           = 1
+	exitCodeUsage             = 2
+	exitCodeInvalidParameters = 3
+	exitCodeDevice            = 4
+	exitCodeAuthentication    = 5
+	exitCodeNetwork           = 6
+	exitCodeServer            = 7
+	exitCodeOutput            = 8
+	exitCodeHook              = 9
+)
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// error categories which are specific to the CLI
+const (
+	errorCategoryUsage  krypton.ErrorCategory = "usage"
+	errorCategoryOutput krypton.ErrorCategory = "output"
+	errorCategoryHook   krypton.ErrorCategory = "hook"
+)
+
+var exitCodes = map[krypton.ErrorCategory]int{
+	krypton.ErrorCategoryUnknown:           exitCodeUnknown,
+	errorCategoryUsage:                     exitCodeUsage,
+	krypton.ErrorCategoryInvalidParameters: exitCodeInvalidParameters,
+	krypton.ErrorCategoryDevice:            exitCodeDevice,
+	krypton.ErrorCategoryAuthentication:    exitCodeAuthentication,
+	krypton.ErrorCategoryNetwork:           exitCodeNetwork,
+	krypton.ErrorCategoryServer:            exitCodeServer,
+	errorCategoryOutput:                    exitCodeOutput,
+	errorCategoryHook:                      exitCodeHook,
+}
+
+func usageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &krypton.Error{Category: errorCategoryUsage, Err: err}
+}
+
+func outputError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &krypton.Error{Category: errorCategoryOutput, Err: err}
+}
+
+func hookError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &krypton.Error{Category: errorCategoryHook, Err: err}
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return exitCodeOK
+	}
+	var ce *childExitError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	if c, ok := exitCodes[krypton.Category(err)]; ok {
+		return c
+	}
+	return exitCodeUnknown
+}
+
+// printError writes err to w in the format specified by -error-format. Secrets in the message, e.g. in a response
+// body of an error, are redacted unless showSecrets is true.
+func printError(w io.Writer, format string, showSecrets bool, err error) {
+	message := err.Error()
+	if !showSecrets {
+		message = krypton.RedactSecrets(message)
+	}
+	if format != errorFormatJSON {
+		fmt.Fprintln(w, message)
+		return
+	}
+
+	e := struct {
+		Category   krypton.ErrorCategory `json:"category"`
+		ExitCode   int                   `json:"exitCode"`
+		Message    string                `json:"message"`
+		StatusCode int                   `json:"statusCode,omitempty"`
+	}{
+		Category: krypton.Category(err),
+		ExitCode: exitCodeOf(err),
+		Message:  message,
+	}
+	var ke *krypton.Error
+	if errors.As(err, &ke) {
+		e.StatusCode = ke.StatusCode
+	}
+
+	b, _ := json.Marshal(struct {
+		Error interface{} `json:"error"`
+	}{e})
+	fmt.Fprintln(w, string(b))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func TestPrintErrorRedactsSecrets(t *testing.T) {
+	err := &krypton.Error{
+		Category:   krypton.ErrorCategoryServer,
+		StatusCode: http.StatusInternalServerError,
+		Err:        errors.New(`unsuccessful response: 500 Internal Server Error` + "\n" + `{"token":"eyJhbGciOi","message":"failed"}`),
+	}
+
+	var buf bytes.Buffer
+	printError(&buf, errorFormatText, false, err)
+	if strings.Contains(buf.String(), "eyJhbGciOi") || !strings.Contains(buf.String(), `"message":"failed"`) {
+		t.Errorf("the token is not redacted: %s", buf.String())
+	}
+
+	buf.Reset()
+	printError(&buf, errorFormatJSON, false, err)
+	var v struct {
+		Error struct {
+			Category   string `json:"category"`
+			ExitCode   int    `json:"exitCode"`
+			Message    string `json:"message"`
+			StatusCode int    `json:"statusCode"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(buf.Bytes(), &v) != nil {
+		t.Fatalf("invalid JSON: %s", buf.String())
+	}
+	if strings.Contains(v.Error.Message, "eyJhbGciOi") {
+		t.Errorf("the token is not redacted: %s", v.Error.Message)
+	}
+	if v.Error.Category != string(krypton.ErrorCategoryServer) || v.Error.ExitCode != exitCodeServer || v.Error.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected error object: %+v", v.Error)
+	}
+
+	buf.Reset()
+	printError(&buf, errorFormatText, true, err)
+	if !strings.Contains(buf.String(), "eyJhbGciOi") {
+		t.Errorf("the token is redacted with -show-secrets: %s", buf.String())
+	}
+}
+
+func TestExitCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitCodeOK},
+		{"plain error", errors.New("failed"), exitCodeUnknown},
+		{"usage", usageError(errors.New("unknown flag")), exitCodeUsage},
+		{"invalid parameters", &krypton.Error{Category: krypton.ErrorCategoryInvalidParameters, Err: errors.New("bad")}, exitCodeInvalidParameters},
+		{"device", &krypton.Error{Category: krypton.ErrorCategoryDevice, Err: errors.New("no UICC")}, exitCodeDevice},
+		{"authentication", &krypton.Error{Category: krypton.ErrorCategoryAuthentication, Err: errors.New("rejected")}, exitCodeAuthentication},
+		{"network", &krypton.Error{Category: krypton.ErrorCategoryNetwork, Err: errors.New("timeout")}, exitCodeNetwork},
+		{"server", &krypton.Error{Category: krypton.ErrorCategoryServer, Err: errors.New("500")}, exitCodeServer},
+		{"output", outputError(errors.New("read-only")), exitCodeOutput},
+		{"hook", hookError(errors.New("exit 1")), exitCodeHook},
+		{"wrapped", errors.Wrap(outputError(errors.New("read-only")), "cert.pem"), exitCodeOutput},
+		{"child exit code", errors.Wrap(&childExitError{Code: 42}, "exec"), 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeOf(tt.err); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPrintErrorJSON(t *testing.T) {
+	tests := []struct {
+		err        error
+		category   string
+		exitCode   int
+		statusCode int
+	}{
+		{errors.New("failed"), "unknown", exitCodeUnknown, 0},
+		{usageError(errors.New("unknown operation name: foo")), "usage", exitCodeUsage, 0},
+		{&krypton.Error{Category: krypton.ErrorCategoryAuthentication, StatusCode: http.StatusForbidden, Err: errors.New("forbidden")}, "authentication", exitCodeAuthentication, http.StatusForbidden},
+		{hookError(errors.New("exit 1")), "hook", exitCodeHook, 0},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		printError(&buf, errorFormatJSON, false, tt.err)
+		var v map[string]map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+			t.Fatalf("invalid JSON: %s", buf.String())
+		}
+		want := map[string]interface{}{"category": tt.category, "exitCode": float64(tt.exitCode), "message": tt.err.Error()}
+		if tt.statusCode != 0 {
+			want["statusCode"] = float64(tt.statusCode)
+		}
+		if len(v["error"]) != len(want) {
+			t.Errorf("unexpected error object: %s", buf.String())
+		}
+		for k, w := range want {
+			if v["error"][k] != w {
+				t.Errorf("unexpected %s: %v", k, v["error"][k])
+			}
+		}
+	}
+}
+
+func TestUnknownOperationExitCode(t *testing.T) {
+	if os.Getenv("KRYPTON_TEST_MAIN") == "1" {
+		os.Args = []string{"krypton-cli", "-operation", "noSuchOperation", "-error-format", "json"}
+		main()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUnknownOperationExitCode$")
+	cmd.Env = append(os.Environ(), "KRYPTON_TEST_MAIN=1", "XDG_CONFIG_HOME="+t.TempDir(), "KRYPTON_CONFIG=", "KRYPTON_PROFILE=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != exitCodeUsage {
+		t.Fatalf("unexpected exit: %v\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), `"category":"usage"`) {
+		t.Errorf("unexpected error: %s", stderr.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const defaultHookTimeout = time.Minute
+
+type hookConfig struct {
+	// OnSuccess is run after the operation succeeded
+	OnSuccess string
+	// OnChange is run after the content of the files of -out, -out-field or -render is changed
+	OnChange string
+	Timeout  time.Duration
+	Env      hookEnvs
+}
+
+func (hc *hookConfig) enabled() bool {
+	return hc.OnSuccess != "" || hc.OnChange != ""
+}
+
+type hookEnv struct {
+	Name  string
+	Query string
+}
+
+// hookEnvs is a flag.Value which accepts `-hook-env NAME=QUERY` multiple times.
+type hookEnvs []hookEnv
+
+func (h *hookEnvs) String() string {
+	ss := []string{}
+	for _, e := range *h {
+		ss = append(ss, e.Name+"="+e.Query)
+	}
+	return strings.Join(ss, ",")
+}
+
+var hookEnvNamePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+func (h *hookEnvs) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("must be in the form of NAME=QUERY: %s", s)
+	}
+	if !hookEnvNamePattern.MatchString(s[:i]) {
+		return errors.Errorf("invalid environment variable name: %s", s[:i])
+	}
+	*h = append(*h, hookEnv{Name: s[:i], Query: s[i+1:]})
+	return nil
+}
+
+func validateHookConfig(hc *hookConfig, hasOutputs bool) error {
+	if hc.OnChange != "" && !hasOutputs {
+		return errors.New("-on-change requires -out, -out-field or -render to detect changes")
+	}
+	if hc.Timeout <= 0 {
+		return errors.New("-hook-timeout must be positive")
+	}
+	for _, e := range hc.Env {
+		if _, err := parseQuery(e.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookEnvironment returns the environment of hooks: the one of krypton-cli, KRYPTON_RUN_OPERATION,
+// KRYPTON_RUN_CHANGED and the variables of resultEnvironment.
+func hookEnvironment(hc *hookConfig, operation string, result []byte, changed bool) ([]string, error) {
+	env, err := resultEnvironment(hc.Env, result)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(os.Environ(),
+		envRunPrefix+"OPERATION="+operation,
+		envRunPrefix+"CHANGED="+strconv.FormatBool(changed),
+	), env...), nil
+}
+
+// resultEnvironment returns the fields in the result as KRYPTON_RESULT_ + the name in -output env
+// (e.g. KRYPTON_RESULT_IMSI) except secrets, and the values selected by -hook-env as KRYPTON_RESULT_ + NAME including secrets.
+func resultEnvironment(envs hookEnvs, result []byte) ([]string, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(result))
+	d.UseNumber()
+	if d.Decode(&v) != nil {
+		if len(envs) > 0 {
+			return nil, errors.New("-hook-env requires the result in JSON")
+		}
+		return nil, nil
+	}
+
+	var env []string
+	for _, kv := range flatten("", v) {
+		if kv.key == "" || krypton.IsSensitiveField(kv.key[strings.LastIndex(kv.key, ".")+1:]) {
+			continue
+		}
+		env = append(env, envResultPrefix+envKey(kv.key)+"="+kv.value)
+	}
+	for _, e := range envs {
+		q, err := parseQuery(e.Query)
+		if err != nil {
+			return nil, err
+		}
+		fv, err := q.apply(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to set %s%s", envResultPrefix, e.Name)
+		}
+		b, err := fieldContent(fv)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, envResultPrefix+e.Name+"="+string(b))
+	}
+	return env, nil
+}
+
+// runHooks runs -on-success, and -on-change if the outputs are changed.
+func runHooks(hc *hookConfig, operation string, result []byte, changed bool) error {
+	if !hc.enabled() {
+		return nil
+	}
+	env, err := hookEnvironment(hc, operation, result, changed)
+	if err != nil {
+		return hookError(err)
+	}
+	if hc.OnSuccess != "" {
+		err = runHook(hc.OnSuccess, env, hc.Timeout)
+		if err != nil {
+			return err
+		}
+	}
+	if hc.OnChange != "" && changed {
+		return runHook(hc.OnChange, env, hc.Timeout)
+	}
+	return nil
+}
+
+// runHook runs cmd with sh. Its output goes to stderr so that stdout only has the result.
+func runHook(cmd string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Debugf("running hook: %s", cmd)
+	c := exec.CommandContext(ctx, "sh", "-c", cmd)
+	c.Env = env
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	setProcessGroup(c)
+	// do not wait for the children which keep the output open if they are not killed with sh
+	c.WaitDelay = time.Second
+	err := c.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return hookError(errors.Errorf("hook '%s' timed out after %s", cmd, timeout))
+	}
+	if err != nil {
+		return hookError(errors.Wrapf(err, "hook '%s' failed", cmd))
+	}
+	return nil
+}
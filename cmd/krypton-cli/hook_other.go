@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// setProcessGroup does nothing; only the shell is killed on timeout.
+func setProcessGroup(c *exec.Cmd) {}
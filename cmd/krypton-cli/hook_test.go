@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func TestHookEnvironment(t *testing.T) {
+	hc := &hookConfig{Env: hookEnvs{{Name: "IOT_ENDPOINT", Query: "host"}, {Name: "SECRET", Query: "credentials.secretAccessKey"}}}
+	result := []byte(`{"imsi":"001010000000001","host":"example.iot.ap-northeast-1.amazonaws.com","port":8883,"credentials":{"accessKeyId":"AKIA","secretAccessKey":"secret"}}`)
+	env, err := hookEnvironment(hc, "bootstrapAwsIotThing", result, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{}
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok && strings.HasPrefix(k, envPrefix) {
+			vars[k] = v
+		}
+	}
+
+	want := map[string]string{
+		"KRYPTON_RUN_OPERATION":                        "bootstrapAwsIotThing",
+		"KRYPTON_RUN_CHANGED":                          "true",
+		"KRYPTON_RESULT_IMSI":                          "001010000000001",
+		"KRYPTON_RESULT_HOST":                          "example.iot.ap-northeast-1.amazonaws.com",
+		"KRYPTON_RESULT_PORT":                          "8883",
+		"KRYPTON_RESULT_CREDENTIALS_ACCESS_KEY_ID":     "AKIA",
+		"KRYPTON_RESULT_IOT_ENDPOINT":                  "example.iot.ap-northeast-1.amazonaws.com",
+		"KRYPTON_RESULT_SECRET":                        "secret",
+		"KRYPTON_RESULT_CREDENTIALS_SECRET_ACCESS_KEY": "",
+	}
+	for k, v := range want {
+		if vars[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, vars[k])
+		}
+	}
+
+	if _, err = hookEnvironment(hc, "getUserData", []byte("not json"), false); err == nil {
+		t.Error("-hook-env is accepted for a result which is not JSON")
+	}
+	if _, err = hookEnvironment(hc, "getSubscriberMetadata", []byte(`{"imsi":"001010000000001"}`), false); err == nil {
+		t.Error("-hook-env is accepted for a missing field")
+	}
+}
+
+func TestHookEnvironmentIsNotReadAsFlags(t *testing.T) {
+	// fields and -hook-env named after the flags -operation and -params
+	hc := &hookConfig{Env: hookEnvs{{Name: "PARAMS", Query: "params"}}}
+	result := []byte(`{"operation":"getUserData","params":{"name":"device-1"}}`)
+	env, err := hookEnvironment(hc, "getSubscriberMetadata", result, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars []string
+	for _, e := range env {
+		if strings.HasPrefix(e, envPrefix) {
+			vars = append(vars, e)
+		}
+	}
+
+	// krypton-cli run by the hook
+	pf := parseFlagsInChild(t, vars)
+	if pf.Error != "operation must be specified" {
+		t.Errorf("the operation is taken from the hook environment: %+v", pf)
+	}
+	pf = parseFlagsInChild(t, vars, "-operation", "getSubscriberMetadata")
+	if pf.Error != "" || pf.RequestParameters != "" {
+		t.Errorf("the parameters are taken from the hook environment: %+v", pf)
+	}
+}
+
+func TestRunHookErrors(t *testing.T) {
+	err := runHook("exit 3", os.Environ(), time.Minute)
+	if exitCodeOf(err) != exitCodeHook || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	err = runHook("sleep 10", os.Environ(), 100*time.Millisecond)
+	if exitCodeOf(err) != exitCodeHook || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("hook is not killed on timeout: %s", d)
+	}
+
+	if err = validateHookConfig(&hookConfig{OnChange: "true", Timeout: time.Minute}, false); err == nil {
+		t.Error("-on-change is accepted without -out")
+	}
+}
+
+func TestOperationHooks(t *testing.T) {
+	userdata := `{"interval":60}`
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, userdata)
+	}))
+	kc, err := krypton.NewClient(kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kc.Close()
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "hook.log")
+	appCfg := &appConfig{
+		Operation:  "getUserData",
+		FileOutput: fileOutputConfig{Path: filepath.Join(dir, "userdata.json"), Mode: 0600},
+		Hooks: hookConfig{
+			OnSuccess: "echo success $KRYPTON_RESULT_INTERVAL >> " + log,
+			OnChange:  "echo change $KRYPTON_RUN_CHANGED >> " + log,
+			Timeout:   time.Minute,
+		},
+	}
+	for i := 0; i < 2; i++ {
+		err = performSpecifiedOperation(appCfg, kc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	userdata = `{"interval":30}`
+	err = performSpecifiedOperation(appCfg, kc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(log)
+	want := "success 60\nchange true\nsuccess 60\nsuccess 30\nchange true\n"
+	if string(b) != want {
+		t.Errorf("unexpected hooks:\n%s", b)
+	}
+
+	appCfg.Hooks.OnSuccess = "exit 1"
+	err = performSpecifiedOperation(appCfg, kc)
+	if exitCodeOf(err) != exitCodeHook {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
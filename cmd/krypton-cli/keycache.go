@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const (
+	keyCacheStorageNone    = ""
+	keyCacheStorageFile    = "file"
+	keyCacheStorageKeyring = "keyring"
+)
+
+type keyCacheConfig struct {
+	Storage           string
+	Path              string
+	EncryptionKeyFile string
+	TTL               time.Duration
+	Disable           bool
+	Clear             bool
+}
+
+// newKeyCache returns the key cache in the storage specified by cfg regardless of cfg.Disable.
+func newKeyCache(cfg *keyCacheConfig) (krypton.KeyCache, error) {
+	switch cfg.Storage {
+	case keyCacheStorageNone:
+		return nil, errors.New("-key-cache-storage must be specified to use the key cache of krypton-cli")
+	case keyCacheStorageFile:
+	case keyCacheStorageKeyring:
+		return krypton.NewKeyringKeyCache(krypton.KeyringUser)
+	default:
+		return nil, errors.Errorf("unknown key cache storage: %s", cfg.Storage)
+	}
+
+	if cfg.Path == "" {
+		return nil, errors.New("-key-cache-file must be specified since the user cache directory is unknown")
+	}
+	// CK must not be stored in plaintext
+	if cfg.EncryptionKeyFile == "" {
+		return nil, errors.New("-key-cache-encryption-key-file must be specified with -key-cache-storage file")
+	}
+	b, err := os.ReadFile(cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the encryption key of the key cache")
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, errors.Errorf("encryption key file %s is empty", cfg.EncryptionKeyFile)
+	}
+	// any secret such as a passphrase or random bytes can be used as the key
+	key := sha256.Sum256(b)
+	return krypton.NewEncryptedFileKeyCache(cfg.Path, key[:])
+}
+
+func init() {
+	registerCommand(&command{
+		Name:        "key-cache",
+		Description: "list, show or delete the authentication results in the key cache of -key-cache-storage: key-cache list | show KEY | delete KEY... | clear. The key cache of the Keys API client, which is not used with -key-cache-storage, is cleared only by -clear-key-cache",
+		Offline:     true,
+		Run:         runKeyCache,
+	})
+}
+
+// defaultKeyCachePath returns the path of the key cache in the user cache directory, or "" if it is unknown.
+func defaultKeyCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "krypton", "keycache.json")
+}
+
+// keyCacheEntryView is a KeyCacheEntry without CK.
+type keyCacheEntryView struct {
+	Key       string    `json:"key"`
+	IMSI      string    `json:"imsi,omitempty"`
+	KeyID     string    `json:"keyId"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Expired   bool      `json:"expired"`
+}
+
+func newKeyCacheEntryView(e *krypton.KeyCacheEntry, now time.Time) keyCacheEntryView {
+	return keyCacheEntryView{
+		Key:       e.Key,
+		IMSI:      e.IMSI,
+		KeyID:     e.KeyID,
+		CreatedAt: e.CreatedAt,
+		ExpiresAt: e.ExpiresAt,
+		Expired:   e.Expired(now),
+	}
+}
+
+func runKeyCache(cc *commandContext) error {
+	c, err := newKeyCache(&cc.appCfg.KeyCache)
+	if err != nil {
+		return usageError(err)
+	}
+	return keyCache(os.Stdout, c, cc.appCfg.Output.Format, cc.args, time.Now())
+}
+
+func keyCache(w io.Writer, c krypton.KeyCache, format string, args []string, now time.Time) error {
+	if len(args) == 0 {
+		return usageError(errors.New("key-cache requires a subcommand: list, show, delete or clear"))
+	}
+
+	switch sub, args := args[0], args[1:]; sub {
+	case "list":
+		if len(args) != 0 {
+			return usageError(errors.New("key-cache list takes no arguments"))
+		}
+		entries, err := c.List()
+		if err != nil {
+			return err
+		}
+		views := make([]keyCacheEntryView, 0, len(entries))
+		for _, e := range entries {
+			views = append(views, newKeyCacheEntryView(e, now))
+		}
+		return writeKeyCacheEntries(w, format, views, true)
+	case "show":
+		if len(args) != 1 {
+			return usageError(errors.New("key-cache show takes exactly one KEY"))
+		}
+		e, err := c.Get(args[0])
+		if err != nil {
+			return keyCacheError(err, args[0])
+		}
+		return writeKeyCacheEntries(w, format, []keyCacheEntryView{newKeyCacheEntryView(e, now)}, false)
+	case "delete":
+		if len(args) == 0 {
+			return usageError(errors.New("key-cache delete requires at least one KEY"))
+		}
+		for _, key := range args {
+			err := c.Delete(key)
+			if err != nil {
+				return keyCacheError(err, key)
+			}
+		}
+		return nil
+	case "clear":
+		if len(args) != 0 {
+			return usageError(errors.New("key-cache clear takes no arguments"))
+		}
+		return c.Clear()
+	default:
+		return usageError(errors.Errorf("unknown key-cache subcommand: %s", sub))
+	}
+}
+
+func keyCacheError(err error, key string) error {
+	if err == krypton.ErrKeyCacheEntryNotFound {
+		return usageError(errors.Errorf("no entry in the key cache for %s", key))
+	}
+	return err
+}
+
+func writeKeyCacheEntries(w io.Writer, format string, views []keyCacheEntryView, list bool) error {
+	switch format {
+	case outputFormatJSON, outputFormatPretty:
+		var v interface{} = views
+		if !list {
+			v = views[0]
+		}
+		var buf bytes.Buffer
+		e := json.NewEncoder(&buf)
+		if format == outputFormatPretty {
+			e.SetIndent("", "  ")
+		}
+		err := e.Encode(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return outputError(err)
+	case outputFormatRaw, outputFormatTable:
+	default:
+		return errors.Errorf("output format %s is not supported by key-cache", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tIMSI\tKEY ID\tCREATED\tEXPIRES\tSTATUS")
+	for _, v := range views {
+		status := "valid"
+		if v.Expired {
+			status = "expired"
+		}
+		imsi := v.IMSI
+		if imsi == "" {
+			imsi = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Key, imsi, v.KeyID,
+			v.CreatedAt.Format(time.RFC3339), v.ExpiresAt.Format(time.RFC3339), status)
+	}
+	return outputError(tw.Flush())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func newTestKeyCache(t *testing.T, now time.Time) *krypton.FileKeyCache {
+	c := krypton.NewFileKeyCache(filepath.Join(t.TempDir(), "keycache.json"))
+	entries := []*krypton.KeyCacheEntry{
+		{Key: "imsi:440100000000000", IMSI: "440100000000000", KeyID: "key-1", CK: []byte("secret"), CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{Key: "comm:/dev/ttyUSB0", KeyID: "key-2", CK: []byte("secret"), CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+	for _, e := range entries {
+		err := c.Put(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestKeyCacheCommand(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		format string
+		args   []string
+	}{
+		{"list", outputFormatRaw, []string{"list"}},
+		{"list_json", outputFormatJSON, []string{"list"}},
+		{"show", outputFormatTable, []string{"show", "imsi:440100000000000"}},
+		{"show_pretty", outputFormatPretty, []string{"show", "comm:/dev/ttyUSB0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := keyCache(&buf, newTestKeyCache(t, now), tt.format, tt.args, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(buf.String(), "c2VjcmV0") {
+				t.Error("CK is printed")
+			}
+			assertGolden(t, filepath.Join("keycache", tt.name+".golden"), buf.Bytes())
+		})
+	}
+}
+
+func TestKeyCacheCommandDelete(t *testing.T) {
+	now := time.Now()
+	c := newTestKeyCache(t, now)
+
+	err := keyCache(io.Discard, c, "", []string{"delete", "comm:/dev/ttyUSB0"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "imsi:440100000000000" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	err = keyCache(io.Discard, c, "", []string{"delete", "unknown"}, now)
+	if exitCodeOf(err) != exitCodeUsage {
+		t.Errorf("expected usage error, got %v", err)
+	}
+
+	err = keyCache(io.Discard, c, "", []string{"clear"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = c.List()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("unexpected entries after clear: %+v, %v", entries, err)
+	}
+}
+
+func TestKeyCacheCommandErrors(t *testing.T) {
+	c := newTestKeyCache(t, time.Now())
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"list", "extra"},
+		{"show"},
+		{"show", "a", "b"},
+		{"delete"},
+		{"clear", "extra"},
+	} {
+		err := keyCache(io.Discard, c, "", args, time.Now())
+		if exitCodeOf(err) != exitCodeUsage {
+			t.Errorf("%v: expected usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestParseCommandArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+		output   string
+	}{
+		{[]string{"list", "-output", "json"}, []string{"list"}, "json"},
+		{[]string{"-output", "json", "delete", "a", "b"}, []string{"delete", "a", "b"}, "json"},
+		{[]string{"delete", "a", "-output", "json", "b"}, []string{"delete", "a", "b"}, "json"},
+		{[]string{"-output", "json", "--", "cmd", "-output", "yaml"}, []string{"cmd", "-output", "yaml"}, "json"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		output := fs.String("output", "", "")
+		actual, err := parseCommandArgs(fs, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, tt.expected) || *output != tt.output {
+			t.Errorf("%v: unexpected result: %v, %s", tt.args, actual, *output)
+		}
+	}
+}
+
+func TestNewKeyCache(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secret")
+	err := os.WriteFile(keyFile, []byte("passphrase\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &keyCacheConfig{Storage: keyCacheStorageFile, Path: filepath.Join(dir, "keycache.json"), EncryptionKeyFile: keyFile}
+	c, err := newKeyCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Put(&krypton.KeyCacheEntry{Key: "imsi:440100000000000", KeyID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("key-1")) {
+		t.Error("key cache is not encrypted")
+	}
+
+	// the same secret decrypts the cache
+	c, err = newKeyCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get("imsi:440100000000000"); err != nil {
+		t.Error(err)
+	}
+
+	for _, cfg := range []*keyCacheConfig{
+		{Storage: "unknown", Path: cfg.Path},
+		{Storage: keyCacheStorageFile},
+		{Storage: keyCacheStorageFile, Path: cfg.Path},
+		{Storage: keyCacheStorageNone, Path: cfg.Path},
+		{Storage: keyCacheStorageFile, Path: cfg.Path, EncryptionKeyFile: filepath.Join(dir, "missing")},
+	} {
+		if _, err := newKeyCache(cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestKeyCacheStorageDisablesKeysAPIClientCache(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	err := os.WriteFile(keyFile, []byte("secret"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheArgs := []string{"-key-cache-storage", "file", "-key-cache-file", filepath.Join(t.TempDir(), "keycache.json"), "-key-cache-encryption-key-file", keyFile}
+
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{"no key cache", nil, false},
+		{"key cache", cacheArgs, true},
+		{"replay", append([]string{"-replay", t.TempDir()}, cacheArgs...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf := parseFlagsInChild(t, nil, append(tt.args, "-operation", "getSubscriberMetadata")...)
+			if pf.Error != "" {
+				t.Fatal(pf.Error)
+			}
+			if pf.DisableKeyCache != tt.want {
+				t.Errorf("unexpected DisableKeyCache of the Keys API client: %v", pf.DisableKeyCache)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton/inventory"
+)
+
+const operationBootstrapInventoryDevice = "bootstrapInventoryDevice"
+
+type lwm2mConfig struct {
+	Format      inventory.ClientConfigFormat
+	PSKEncoding inventory.PSKEncoding
+	Endpoint    string
+}
+
+func (lc *lwm2mConfig) enabled() bool {
+	return lc.Format != ""
+}
+
+// newLwM2MConfig validates -lwm2m-config and -psk-encoding. The endpoint name is taken from -params.
+func newLwM2MConfig(format, pskEncoding, operation, requestParameters string, oc *outputConfig, fc *fileOutputConfig) (*lwm2mConfig, error) {
+	if format == "" {
+		return &lwm2mConfig{}, nil
+	}
+
+	f, err := inventory.ParseClientConfigFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := inventory.ParsePSKEncoding(pskEncoding)
+	if err != nil {
+		return nil, err
+	}
+	if operation != operationBootstrapInventoryDevice {
+		return nil, errors.Errorf("-lwm2m-config can only be used with -operation %s", operationBootstrapInventoryDevice)
+	}
+	if oc.Format != outputFormatRaw || oc.Template != "" || oc.Query != "" || len(fc.Fields) > 0 {
+		return nil, errors.New("-lwm2m-config cannot be used with -output, -template, -query or -out-field")
+	}
+
+	var params struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if requestParameters != "" {
+		err = json.Unmarshal([]byte(requestParameters), &params)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse -params to generate LwM2M client config")
+		}
+	}
+	if params.Endpoint == "" {
+		return nil, errors.New("endpoint must be specified in -params to generate LwM2M client config")
+	}
+
+	return &lwm2mConfig{
+		Format:      f,
+		PSKEncoding: enc,
+		Endpoint:    params.Endpoint,
+	}, nil
+}
+
+// generateLwM2MConfig converts the result of bootstrapInventoryDevice into the LwM2M client config.
+func generateLwM2MConfig(lc *lwm2mConfig, result []byte) ([]byte, error) {
+	r, err := inventory.ParseBootstrapResult(result)
+	if err != nil {
+		return nil, err
+	}
+	r.Endpoint = lc.Endpoint
+	b, err := inventory.GenerateClientConfig(r, lc.Format, lc.PSKEncoding)
+	if err != nil {
+		return nil, err
+	}
+	// the result is written with a newline like the other results
+	return bytes.TrimSuffix(b, []byte("\n")), nil
+}
@@ -30,13 +30,41 @@ const (
 	runModeListCOMPorts
 	runModeDeviceInfo
 	runModePerformSpecifiedOperation
+	runModeCommand
 	runModeDoNothing
 	runModeUnknown
 )
 
+// errorFormat and showSecrets are referred from main() even when parsing flags failed
+var (
+	errorFormat = errorFormatText
+	showSecrets bool
+)
+
 type appConfig struct {
-	Operation string
-	Debug     bool
+	Command     *command
+	Args        []string
+	Operation   string
+	Output      outputConfig
+	FileOutput  fileOutputConfig
+	LwM2M       lwm2mConfig
+	Metrics     metricsConfig
+	Tracing     tracingConfig
+	KeyCache    keyCacheConfig
+	Ensure      ensureConfig
+	Apply       applyConfig
+	Hooks       hookConfig
+	Exec        execConfig
+	WebIdentity webIdentityConfig
+	Render      renderConfig
+	SIMConfig   string
+	Record      string
+	Replay      string
+	Debug       bool
+	ShowSecrets bool
+
+	DryRun             bool
+	DryRunAuthenticate bool
 }
 
 func main() {
@@ -44,15 +72,19 @@ func main() {
 
 	err := run()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		// the command of exec has reported its error by itself
+		var ce *childExitError
+		if !errors.As(err, &ce) {
+			printError(os.Stderr, errorFormat, showSecrets, err)
+		}
+		os.Exit(exitCodeOf(err))
 	}
 }
 
 func run() error {
 	rm, appCfg, endorseCfg, kryptonCfg, err := parseFlags()
 	if err != nil {
-		return err
+		return usageError(err)
 	}
 	if rm == runModeDoNothing {
 		return nil
@@ -60,13 +92,96 @@ func run() error {
 
 	setupLogger(appCfg)
 
-	ec, err := endorse.NewClient(endorseCfg)
-	if err != nil {
-		return err
+	var ec *endorse.Client
+	var endorseErr error
+	switch {
+	case appCfg.DryRun && !appCfg.DryRunAuthenticate:
+		// the SIM is not used
+	case rm == runModeCommand && appCfg.Command.Offline:
+	case appCfg.Replay != "":
+		r, err := krypton.NewReplayer(appCfg.Replay, appCfg.Operation)
+		if err != nil {
+			return usageError(err)
+		}
+		kryptonCfg.Authenticator = r
+	case appCfg.SIMConfig != "":
+		a, err := newSimulatorAuthenticator(appCfg.SIMConfig, endorseCfg, kryptonCfg)
+		if err != nil {
+			return usageError(err)
+		}
+		kryptonCfg.Authenticator = a
+	default:
+		if kryptonCfg.KeyCache != nil {
+			// endorse-client-go does not tell which SIM it authenticates, so krypton-cli reads the IMSI by itself
+			kryptonCfg.IMSIReader, err = krypton.NewIMSIReader(endorseCfg)
+			if err != nil {
+				return usageError(errors.Wrap(err, "-key-cache-storage cannot be used with this -interface"))
+			}
+		}
+		ec, err = endorse.NewClient(endorseCfg)
+		if err != nil {
+			err = &krypton.Error{Category: krypton.ErrorCategoryDevice, Err: err}
+			if rm != runModeCommand {
+				return err
+			}
+			// commands such as doctor report the error by themselves
+			endorseErr = err
+			break
+		}
+		defer ec.Close()
+		kryptonCfg.EndorseClient = ec
+	}
+
+	// the key cache of the Keys API client is cleared by endorse-client-go
+	if appCfg.KeyCache.Clear && appCfg.KeyCache.Storage != keyCacheStorageNone {
+		c, err := newKeyCache(&appCfg.KeyCache)
+		if err != nil {
+			return usageError(err)
+		}
+		err = c.Clear()
+		if err != nil {
+			return err
+		}
+	}
+
+	if appCfg.Record != "" {
+		var a krypton.Authenticator = kryptonCfg.Authenticator
+		if a == nil && kryptonCfg.EndorseClient != nil {
+			a = kryptonCfg.EndorseClient
+		}
+		r, err := krypton.NewRecorder(a, appCfg.Record)
+		if err != nil {
+			return err
+		}
+		r.Operation = appCfg.Operation
+		r.ShowSecrets = appCfg.ShowSecrets
+		kryptonCfg.Authenticator = r
+	}
+
+	if appCfg.Metrics.enabled() {
+		me, m, err := startMetrics(&appCfg.Metrics)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := me.stop(); err != nil {
+				log.Errorf("unable to write metrics: %v", err)
+			}
+		}()
+		kryptonCfg.Metrics = m
 	}
-	defer ec.Close()
 
-	kryptonCfg.EndorseClient = ec
+	if appCfg.Tracing.enabled() {
+		tp, err := startTracing(&appCfg.Tracing)
+		if err != nil {
+			return err
+		}
+		defer stopTracing(tp)
+		kryptonCfg.TracerProvider = tp
+		if ec != nil {
+			propagateTraceContext(kryptonCfg)
+		}
+	}
 
 	kc, err := krypton.NewClient(kryptonCfg)
 	if err != nil {
@@ -80,7 +195,20 @@ func run() error {
 	case runModeDeviceInfo:
 		return deviceInfo(ec)
 	case runModePerformSpecifiedOperation:
+		if appCfg.DryRun {
+			return dryRun(os.Stdout, appCfg, kc)
+		}
 		return performSpecifiedOperation(appCfg, kc)
+	case runModeCommand:
+		return appCfg.Command.Run(&commandContext{
+			appCfg:        appCfg,
+			endorseCfg:    endorseCfg,
+			kryptonCfg:    kryptonCfg,
+			endorseClient: ec,
+			endorseErr:    endorseErr,
+			kc:            kc,
+			args:          appCfg.Args,
+		})
 	default:
 		return errors.New("unknown run mode")
 	}
@@ -95,6 +223,7 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 		signatureAlgorithm         string
 
 		uiccInterfaceType     string
+		simConfig             string
 		portName              string
 		baudRate              uint
 		dataBits              uint
@@ -107,6 +236,53 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 
 		disableKeyCache bool
 		clearKeyCache   bool
+		keyCacheStorage string
+		keyCacheFile    string
+		keyCacheKeyFile string
+		keyCacheTTL     time.Duration
+
+		outputFormat   string
+		outputTemplate string
+		outputQuery    string
+
+		lwm2mConfigFormat string
+		pskEncoding       string
+
+		outputFile       string
+		outputFields     fieldOutputs
+		outputFileMode   = fileModeValue(defaultOutputFileMode)
+		outputFileOwner  string
+		outputFileBackup bool
+
+		onSuccess   string
+		onChange    string
+		hookTimeout time.Duration
+		hookEnv     hookEnvs
+
+		refresh       string
+		refreshBefore time.Duration
+		once          bool
+
+		renderTargets renderTargets
+
+		metricsAddr     string
+		metricsTextFile string
+		otlpEndpoint    string
+
+		recordDir string
+		replayDir string
+
+		dryRun             bool
+		dryRunAuthenticate bool
+
+		renewBefore time.Duration
+
+		manifestFile string
+		applyPlan    bool
+		applyDiff    bool
+
+		configPath  string
+		profileName string
 
 		help    bool
 		version bool
@@ -120,7 +296,8 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 	flag.StringVar(&keysAPIEndpointURL, "keys-api-endpoint-url", "", "Use the specified URL as a Keys API endpoint")
 	flag.StringVar(&signatureAlgorithm, "signature-algorithm", "SHA-256", "Algorithm for generating signature. (default is SHA-256)")
 
-	flag.StringVar(&uiccInterfaceType, "interface", "autoDetect", "UICC Interface to use. Valid values are iso7816, comm, mmcli or autoDetect")
+	flag.StringVar(&uiccInterfaceType, "interface", "autoDetect", "UICC Interface to use. Valid values are iso7816, comm, mmcli, autoDetect or simulator")
+	flag.StringVar(&simConfig, "sim-config", "", "JSON file with imsi, k and opc (or op) of the simulated SIM for -interface simulator")
 	flag.StringVar(&portName, "port-name", "", "Port name of communication device (e.g. -c COM1 or -c /dev/tty1)")
 	flag.UintVar(&baudRate, "baud-rate", 57600, "Baud rate for communication device (e.g. -b 57600)")
 	flag.UintVar(&dataBits, "data-bits", 8, "Data bits for communication device (e.g. -s 8)")
@@ -131,14 +308,68 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 	flag.BoolVar(&listCOMPorts, "list-com-ports", false, "List all available communication devices and exit")
 	flag.BoolVar(&deviceInfo, "device-info", false, "Query the communication device and print the information")
 
-	flag.BoolVar(&disableKeyCache, "disable-key-cache", false, "Do not store authentication result to the key cache")
-	flag.BoolVar(&clearKeyCache, "clear-key-cache", false, "Remove all items in the key cache")
+	flag.BoolVar(&disableKeyCache, "disable-key-cache", false, "Do not use the key cache of the Keys API client, nor the one of -key-cache-storage")
+	flag.BoolVar(&clearKeyCache, "clear-key-cache", false, "Remove all items in the key cache of the Keys API client, and in the one of -key-cache-storage")
+	flag.StringVar(&keyCacheStorage, "key-cache-storage", keyCacheStorageNone, "Cache authentication results in krypton-cli instead of the Keys API client to skip SIM authentication until they expire, so that the key-cache command can manage them. Only for -interface comm (Linux only), mmcli or simulator, with which the IMSI of the SIM can be read. Valid values are file (-key-cache-file) or keyring (the Linux kernel user keyring) (default: not cached)")
+	flag.StringVar(&keyCacheFile, "key-cache-file", defaultKeyCachePath(), "File to store authentication results in with -key-cache-storage file")
+	flag.StringVar(&keyCacheKeyFile, "key-cache-encryption-key-file", "", "Encrypt -key-cache-file with the secret in the specified file. Required with -key-cache-storage file")
+	flag.DurationVar(&keyCacheTTL, "key-cache-ttl", krypton.DefaultKeyCacheTTL, "How long a cached authentication result is used")
+
+	flag.StringVar(&outputFormat, "output", "", "Output format of the result. Valid values are json, pretty, yaml, env or table (default: the response from the server as is)")
+	flag.StringVar(&outputTemplate, "template", "", "Format the result with the specified Go template (e.g. -template '{{.imsi}}')")
+	flag.StringVar(&outputQuery, "query", "", "Select a value in the result by a JSONPath-style query (e.g. -query imsi, -query '$.tags.name')")
+	flag.StringVar(&lwm2mConfigFormat, "lwm2m-config", "", "Print the result of bootstrapInventoryDevice as a configuration for the LwM2M client. Valid values are wakaama, anjay or leshan")
+	flag.StringVar(&pskEncoding, "psk-encoding", "hex", "Encoding of the PSK in the LwM2M client configuration. Valid values are hex or base64")
+	flag.StringVar(&outputFile, "out", "", "Write the result to the specified file instead of stdout. The file is replaced only when the operation succeeded")
+	flag.Var(&outputFields, "out-field", "Write the value selected by QUERY in the result to FILE, in the form of QUERY=FILE (e.g. -out-field privateKey=/etc/aws/key.pem). Can be specified multiple times")
+	flag.Var(&outputFileMode, "out-mode", "Permission of the files written by -out and -out-field (default 0600)")
+	flag.StringVar(&outputFileOwner, "out-owner", "", "Owner of the files written by -out and -out-field, in the form of USER[:GROUP]")
+	flag.BoolVar(&outputFileBackup, "out-backup", false, "Keep the previous content of the files written by -out and -out-field as FILE.bak")
+	flag.StringVar(&onSuccess, "on-success", "", "Run the specified shell command after the operation succeeded, with the fields in the result as KRYPTON_RESULT_ environment variables (e.g. KRYPTON_RESULT_IMSI)")
+	flag.StringVar(&onChange, "on-change", "", "Run the specified shell command after the content of the files written by -out, -out-field or -render is changed, e.g. to restart a service")
+	flag.DurationVar(&hookTimeout, "hook-timeout", defaultHookTimeout, "Kill -on-success, -on-change and post hooks of the apply command when they run longer than the specified duration")
+	flag.Var(&hookEnv, "hook-env", "Pass the value selected by QUERY in the result to hooks and the command of exec as KRYPTON_RESULT_NAME, in the form of NAME=QUERY (e.g. -hook-env IOT_ENDPOINT=host). Secrets such as privateKey are passed only with it. Can be specified multiple times")
+	flag.StringVar(&refresh, "refresh", refreshNone, "With the exec command, how to refresh the credentials before they expire. Valid values are none, restart (restart the command with new credentials) or serve (serve the credentials to AWS SDKs in the command as the container credentials provider)")
+	flag.DurationVar(&refreshBefore, "refresh-before", defaultRefreshBefore, "With the exec and web-identity-token commands, refresh the credentials or the token when they expire within the specified duration")
+	flag.Var(&renderTargets, "render", "With the render command, render the Go template in TEMPLATE into FILE, in the form of TEMPLATE=FILE (e.g. -render app.conf.tmpl=/etc/app.conf). Can be specified multiple times")
+	flag.BoolVar(&once, "once", false, "With the web-identity-token command, write the token once and exit instead of refreshing it")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at http://ADDR/metrics while running (e.g. -metrics-addr :9100)")
+	flag.StringVar(&metricsTextFile, "metrics-textfile", "", "Write Prometheus metrics to the specified file on exit (for node_exporter textfile collector)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "Export traces to the specified OTLP/HTTP collector (e.g. -otlp-endpoint http://localhost:4318). OTEL_EXPORTER_OTLP_ENDPOINT is also supported")
+
+	flag.StringVar(&recordDir, "record", "", "Record the authentication and the requests and responses of the operation to the specified directory, with secrets redacted unless -show-secrets is specified")
+	flag.StringVar(&replayDir, "replay", "", "Play back the exchanges recorded by -record in the specified directory instead of using the SIM and the API")
+
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate the parameters and show the endpoint URL, the request body and the output destinations of the operation without contacting the API")
+	flag.BoolVar(&dryRunAuthenticate, "dry-run-authenticate", false, "Perform SIM authentication in -dry-run to check the SIM and the Keys API")
+
+	flag.DurationVar(&renewBefore, "renew-before", defaultRenewBefore, "With the ensure command, perform the operation when a certificate, token or credentials in the output files expire within the specified duration")
+
+	flag.StringVar(&manifestFile, "file", "", "Manifest of the steps for the apply command")
+	flag.StringVar(&manifestFile, "f", "", "Manifest of the steps for the apply command")
+	flag.BoolVar(&applyPlan, "plan", false, "With the apply command, show the steps and the state of the outputs without using the SIM")
+	flag.BoolVar(&applyDiff, "diff", false, "With the apply command, perform the operations and show the changes of the outputs without writing them")
+
+	flag.StringVar(&configPath, "config", "", "Read settings from the specified config file instead of /etc/krypton/config.yaml and ~/.config/krypton/config.yaml")
+	flag.StringVar(&profileName, "profile", "", "Name of the profile in the config file to use (default: KRYPTON_PROFILE, default-profile in the config file or \"default\")")
 
 	flag.BoolVar(&help, "help", false, "Display this help message and exit")
 	flag.BoolVar(&help, "h", false, "Display this help message and exit")
 	flag.BoolVar(&version, "version", false, "Show version number")
 	flag.BoolVar(&debug, "debug", false, "Show verbose debug messages")
-	flag.Parse()
+	flag.StringVar(&errorFormat, "error-format", errorFormatText, "Format of error messages printed to stderr. Valid values are text or json")
+	flag.BoolVar(&showSecrets, "show-secrets", false, "Do not redact secrets such as private keys and tokens in debug and error messages. Use with care")
+	flag.Usage = usage
+
+	cmd, args := splitCommand(os.Args[1:])
+	var cmdArgs []string
+	if cmd != nil {
+		// flag.CommandLine exits on errors as flag.Parse does
+		cmdArgs, _ = parseCommandArgs(flag.CommandLine, args)
+	} else {
+		flag.CommandLine.Parse(args)
+		cmdArgs = flag.Args()
+	}
 
 	if help {
 		flag.Usage()
@@ -149,14 +380,108 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 		return runModeDoNothing, nil, nil, nil, nil
 	}
 
+	err := applyProfileAndEnv(flag.CommandLine, configPath, profileName)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+
+	if errorFormat != errorFormatText && errorFormat != errorFormatJSON {
+		err = errors.Errorf("unknown error format: %s", errorFormat)
+		errorFormat = errorFormatText
+		return runModeUnknown, nil, nil, nil, err
+	}
+
 	appCfg := &appConfig{
+		Command:   cmd,
+		Args:      cmdArgs,
 		Operation: operation,
-		Debug:     debug,
+		Output: outputConfig{
+			Format:   outputFormat,
+			Template: outputTemplate,
+			Query:    outputQuery,
+		},
+		FileOutput: fileOutputConfig{
+			Path:   outputFile,
+			Fields: outputFields,
+			Mode:   os.FileMode(outputFileMode),
+			Owner:  outputFileOwner,
+			Backup: outputFileBackup,
+		},
+		Metrics: metricsConfig{
+			ListenAddr: metricsAddr,
+			TextFile:   metricsTextFile,
+		},
+		Tracing: tracingConfig{
+			OTLPEndpoint: otlpEndpoint,
+		},
+		KeyCache: keyCacheConfig{
+			Storage:           keyCacheStorage,
+			Path:              keyCacheFile,
+			EncryptionKeyFile: keyCacheKeyFile,
+			TTL:               keyCacheTTL,
+			Disable:           disableKeyCache,
+			Clear:             clearKeyCache,
+		},
+		Ensure: ensureConfig{
+			RenewBefore: renewBefore,
+		},
+		Hooks: hookConfig{
+			OnSuccess: onSuccess,
+			OnChange:  onChange,
+			Timeout:   hookTimeout,
+			Env:       hookEnv,
+		},
+		Exec: execConfig{
+			Refresh:       refresh,
+			RefreshBefore: refreshBefore,
+		},
+		WebIdentity: webIdentityConfig{
+			Once: once,
+		},
+		Render: renderConfig{
+			Targets: renderTargets,
+		},
+		Apply: applyConfig{
+			File:     manifestFile,
+			Plan:     applyPlan,
+			DiffOnly: applyDiff,
+		},
+		Record:             recordDir,
+		Replay:             replayDir,
+		Debug:              debug,
+		ShowSecrets:        showSecrets,
+		DryRun:             dryRun,
+		DryRunAuthenticate: dryRunAuthenticate,
 	}
 
 	setupLogger(appCfg)
 
-	var err error
+	err = validateOutputConfig(&appCfg.Output)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+
+	err = validateFileOutputConfig(&appCfg.FileOutput)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+
+	err = validateHookConfig(&appCfg.Hooks, appCfg.FileOutput.enabled() || len(appCfg.Render.Targets) > 0)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+
+	err = validateExecConfig(&appCfg.Exec)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+
+	lc, err := newLwM2MConfig(lwm2mConfigFormat, pskEncoding, operation, requestParameters, &appCfg.Output, &appCfg.FileOutput)
+	if err != nil {
+		return runModeUnknown, nil, nil, nil, err
+	}
+	appCfg.LwM2M = *lc
+
 	var kaeu *url.URL
 	if keysAPIEndpointURL != "" {
 		kaeu, err = url.Parse(keysAPIEndpointURL)
@@ -165,9 +490,24 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 		}
 	}
 
-	uit, err := endorse.ParseUICCInterfaceType(uiccInterfaceType)
-	if err != nil {
-		return runModeUnknown, nil, nil, nil, err
+	uit := new(endorse.UICCInterfaceType)
+	if uiccInterfaceType == interfaceSimulator {
+		if simConfig == "" {
+			return runModeUnknown, nil, nil, nil, errors.New("-sim-config must be specified with -interface simulator")
+		}
+		if listCOMPorts || deviceInfo {
+			return runModeUnknown, nil, nil, nil, errors.New("-list-com-ports and -device-info cannot be used with -interface simulator")
+		}
+		appCfg.SIMConfig = simConfig
+		*uit = endorse.UICCInterfaceTypeNone
+	} else {
+		if simConfig != "" {
+			return runModeUnknown, nil, nil, nil, errors.New("-sim-config can be used only with -interface simulator")
+		}
+		uit, err = endorse.ParseUICCInterfaceType(uiccInterfaceType)
+		if err != nil {
+			return runModeUnknown, nil, nil, nil, err
+		}
 	}
 
 	serial := endorse.SerialConfig{
@@ -184,6 +524,8 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 		SignatureAlgorithm: signatureAlgorithm,
 		UICCInterfaceType:  *uit,
 		Serial:             serial,
+		DisableKeyCache:    disableKeyCache,
+		ClearKeyCache:      clearKeyCache,
 		Logger:             log,
 	}
 
@@ -198,7 +540,45 @@ func parseFlags() (runMode, *appConfig, *endorse.Config, *krypton.Config, error)
 	kCfg := &krypton.Config{
 		ProvisioningAPIEndpointURL: paeu,
 		RequestParameters:          requestParameters,
-		Logger:                     log,
+		Logger:                     krypton.NewGoLoggingLogger(log),
+		ShowSecrets:                showSecrets,
+	}
+	if keyCacheTTL <= 0 {
+		return runModeUnknown, nil, nil, nil, errors.New("-key-cache-ttl must be positive")
+	}
+	// the key cache is not used to record or replay SIM authentication
+	if keyCacheStorage != keyCacheStorageNone && !disableKeyCache && recordDir == "" && replayDir == "" {
+		kCfg.KeyCache, err = newKeyCache(&appCfg.KeyCache)
+		if err != nil {
+			return runModeUnknown, nil, nil, nil, err
+		}
+		kCfg.KeyCacheTTL = keyCacheTTL
+		// the key cache of krypton-cli is the only one, so that the key-cache command shows what is used
+		eCfg.DisableKeyCache = true
+	}
+
+	if recordDir != "" && replayDir != "" {
+		return runModeUnknown, nil, nil, nil, errors.New("-record and -replay cannot be used together")
+	}
+	if dryRunAuthenticate && !dryRun {
+		return runModeUnknown, nil, nil, nil, errors.New("-dry-run-authenticate must be specified with -dry-run")
+	}
+	if dryRun && recordDir != "" {
+		return runModeUnknown, nil, nil, nil, errors.New("-dry-run and -record cannot be used together")
+	}
+	if replayDir != "" && (listCOMPorts || deviceInfo) {
+		return runModeUnknown, nil, nil, nil, errors.New("-replay can be used only with -operation")
+	}
+
+	if operation != "" && !krypton.IsOperation(operation) {
+		return runModeUnknown, nil, nil, nil, errors.Errorf("unknown operation name: %s", operation)
+	}
+
+	if cmd != nil {
+		if dryRun {
+			return runModeUnknown, nil, nil, nil, errors.Errorf("-dry-run cannot be used with %s", cmd.Name)
+		}
+		return runModeCommand, appCfg, eCfg, kCfg, nil
 	}
 
 	if listCOMPorts {
@@ -227,7 +607,10 @@ func setupLogger(appCfg *appConfig) {
 	if appCfg.Debug {
 		format = formatDebug
 	}
-	bf := logging.NewBackendFormatter(be, format)
+	var bf logging.Backend = logging.NewBackendFormatter(be, format)
+	if !appCfg.ShowSecrets {
+		bf = &redactingBackend{backend: bf}
+	}
 	ml := logging.AddModuleLevel(bf)
 	level := logging.ERROR
 	if appCfg.Debug {
@@ -237,6 +620,22 @@ func setupLogger(appCfg *appConfig) {
 	logging.SetBackend(ml)
 }
 
+// redactingBackend removes secrets from all the log messages including ones from endorse-client-go.
+type redactingBackend struct {
+	backend logging.Backend
+}
+
+func (b *redactingBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	r := &logging.Record{
+		ID:     rec.ID,
+		Time:   rec.Time,
+		Module: rec.Module,
+		Level:  rec.Level,
+		Args:   []interface{}{krypton.RedactSecrets(rec.Message())},
+	}
+	return b.backend.Log(level, calldepth+1, r)
+}
+
 func listCOMPorts(ec *endorse.Client) error {
 	ports, err := ec.ListCOMPorts()
 	if err != nil {
@@ -258,7 +657,26 @@ func deviceInfo(ec *endorse.Client) error {
 }
 
 func performSpecifiedOperation(appCfg *appConfig, kc *krypton.Client) error {
-	return kc.PerformOperation(appCfg.Operation)
+	result, err := kc.PerformOperationWithResult(appCfg.Operation)
+	if err != nil {
+		return err
+	}
+	if appCfg.LwM2M.enabled() {
+		result, err = generateLwM2MConfig(&appCfg.LwM2M, result)
+		if err != nil {
+			return err
+		}
+	}
+	changed := false
+	if appCfg.FileOutput.enabled() {
+		changed, err = writeFileOutputs(&appCfg.FileOutput, &appCfg.Output, result)
+	} else {
+		err = writeOutput(os.Stdout, &appCfg.Output, result)
+	}
+	if err != nil {
+		return outputError(err)
+	}
+	return runHooks(&appCfg.Hooks, appCfg.Operation, result, changed)
 }
 
 func showVersion() error {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/soracom/krypton-client-go/krypton/metrics"
+)
+
+type metricsConfig struct {
+	ListenAddr string
+	TextFile   string
+}
+
+func (mc *metricsConfig) enabled() bool {
+	return mc.ListenAddr != "" || mc.TextFile != ""
+}
+
+type metricsExporter struct {
+	cfg      *metricsConfig
+	registry *prometheus.Registry
+	listener net.Listener
+}
+
+// startMetrics creates Prometheus metrics and starts serving them at /metrics when -metrics-addr is specified.
+func startMetrics(mc *metricsConfig) (*metricsExporter, *metrics.Prometheus, error) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewPrometheus(reg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	me := &metricsExporter{
+		cfg:      mc,
+		registry: reg,
+	}
+
+	if mc.ListenAddr != "" {
+		l, err := net.Listen("tcp", mc.ListenAddr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to listen for -metrics-addr")
+		}
+		me.listener = l
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		go func() {
+			err := http.Serve(l, mux)
+			if err != nil {
+				log.Debugf("metrics server stopped: %v", err)
+			}
+		}()
+		log.Debugf("serving metrics at http://%s/metrics", l.Addr())
+	}
+
+	return me, m, nil
+}
+
+// stop writes metrics to -metrics-textfile and stops the /metrics endpoint.
+func (me *metricsExporter) stop() error {
+	if me.listener != nil {
+		me.listener.Close()
+	}
+	if me.cfg.TextFile != "" {
+		return prometheus.WriteToTextfile(me.cfg.TextFile, me.registry)
+	}
+	return nil
+}
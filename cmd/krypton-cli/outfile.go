@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultOutputFileMode = 0600
+
+// fieldOutput maps a value in the result selected by Query to a file.
+type fieldOutput struct {
+	Query string
+	Path  string
+}
+
+// fieldOutputs is a flag.Value which accepts `-out-field QUERY=FILE` multiple times.
+type fieldOutputs []fieldOutput
+
+func (f *fieldOutputs) String() string {
+	ss := []string{}
+	for _, fo := range *f {
+		ss = append(ss, fo.Query+"="+fo.Path)
+	}
+	return strings.Join(ss, ",")
+}
+
+func (f *fieldOutputs) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("must be in the form of QUERY=FILE: %s", s)
+	}
+	*f = append(*f, fieldOutput{Query: s[:i], Path: s[i+1:]})
+	return nil
+}
+
+// fileModeValue is a flag.Value which accepts an octal file mode e.g. 0600.
+type fileModeValue os.FileMode
+
+func (m *fileModeValue) String() string {
+	return fmt.Sprintf("%#o", os.FileMode(*m))
+}
+
+func (m *fileModeValue) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return errors.Errorf("invalid file mode: %s", s)
+	}
+	*m = fileModeValue(v)
+	return nil
+}
+
+type fileOutputConfig struct {
+	Path   string
+	Fields fieldOutputs
+	Mode   os.FileMode
+	Owner  string
+	Backup bool
+}
+
+func (fc *fileOutputConfig) enabled() bool {
+	return fc.Path != "" || len(fc.Fields) > 0
+}
+
+func validateFileOutputConfig(fc *fileOutputConfig) error {
+	for _, fo := range fc.Fields {
+		if _, err := parseQuery(fo.Query); err != nil {
+			return err
+		}
+	}
+	if fc.Owner != "" {
+		if _, _, err := lookupOwner(fc.Owner); err != nil {
+			return err
+		}
+	}
+	for _, p := range fc.paths() {
+		st, err := os.Stat(filepath.Dir(p))
+		if err != nil {
+			return errors.Wrapf(err, "unable to write to %s", p)
+		}
+		if !st.IsDir() {
+			return errors.Errorf("unable to write to %s: %s is not a directory", p, filepath.Dir(p))
+		}
+	}
+	return nil
+}
+
+func (fc *fileOutputConfig) paths() []string {
+	paths := []string{}
+	if fc.Path != "" {
+		paths = append(paths, fc.Path)
+	}
+	for _, fo := range fc.Fields {
+		paths = append(paths, fo.Path)
+	}
+	return paths
+}
+
+// writeFileOutputs writes the result to the files specified by -out and -out-field, and returns whether the content
+// of any file is changed.
+// All the contents are prepared before any file is touched so that no file is replaced when a field is missing.
+func writeFileOutputs(fc *fileOutputConfig, oc *outputConfig, body []byte) (bool, error) {
+	contents, err := renderFileOutputs(fc, oc, body)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for path, b := range contents {
+		current, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(current, b) {
+			changed = true
+		}
+	}
+	return changed, writeFiles(fc, contents)
+}
+
+// renderFileOutputs returns the contents of the files specified by -out and -out-field, keyed by the path.
+func renderFileOutputs(fc *fileOutputConfig, oc *outputConfig, body []byte) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+
+	if fc.Path != "" {
+		var buf bytes.Buffer
+		err := writeOutput(&buf, oc, body)
+		if err != nil {
+			return nil, err
+		}
+		contents[fc.Path] = buf.Bytes()
+	}
+
+	if len(fc.Fields) > 0 {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		err := d.Decode(&v)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse the response as JSON")
+		}
+
+		for _, fo := range fc.Fields {
+			q, err := parseQuery(fo.Query)
+			if err != nil {
+				return nil, err
+			}
+			fv, err := q.apply(v)
+			if err != nil {
+				return nil, err
+			}
+			b, err := fieldContent(fv)
+			if err != nil {
+				return nil, err
+			}
+			contents[fo.Path] = b
+		}
+	}
+
+	return contents, nil
+}
+
+// writeFiles replaces the files with contents atomically, with the mode and the owner of fc.
+// Each file is replaced by a rename. When a rename fails, the files already replaced are restored from the previous
+// versions kept as hard links, so that the set of files is not left half-updated.
+func writeFiles(fc *fileOutputConfig, contents map[string][]byte) error {
+	uid, gid := -1, -1
+	if fc.Owner != "" {
+		var err error
+		uid, gid, err = lookupOwner(fc.Owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(contents))
+	for path := range contents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tmpFiles := map[string]string{}
+	defer func() {
+		for _, tmp := range tmpFiles {
+			os.Remove(tmp)
+		}
+	}()
+	for _, path := range paths {
+		tmp, err := writeTempFile(path, contents[path], fc.Mode, uid, gid)
+		if err != nil {
+			return err
+		}
+		tmpFiles[path] = tmp
+	}
+
+	var replaced []replacedFile
+	for _, path := range paths {
+		rs, err := replaceFile(path, tmpFiles[path], fc.Backup)
+		if err != nil {
+			for i := len(replaced) - 1; i >= 0; i-- {
+				replaced[i].rollback()
+			}
+			return err
+		}
+		delete(tmpFiles, path)
+		replaced = append(replaced, rs...)
+	}
+	for _, r := range replaced {
+		r.commit()
+		log.Debugf("wrote %s", r.path)
+	}
+	return nil
+}
+
+// replacedFile is a file replaced by writeFiles.
+type replacedFile struct {
+	path string
+	// previous is a hard link to the previous version, or "" if the file did not exist
+	previous string
+}
+
+// replaceFile renames tmp to path, keeping the previous version of path until commit or rollback. With backup, the
+// current content of path is also written to path.bak in the same way, and is restored together by rollback.
+func replaceFile(path, tmp string, backup bool) ([]replacedFile, error) {
+	var replaced []replacedFile
+	if backup {
+		bakTmp, err := backupFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if bakTmp != "" {
+			r, err := renameFile(path+".bak", bakTmp)
+			if err != nil {
+				os.Remove(bakTmp)
+				return nil, errors.Wrapf(err, "unable to back up %s", path)
+			}
+			replaced = append(replaced, r)
+		}
+	}
+
+	r, err := renameFile(path, tmp)
+	if err != nil {
+		for i := len(replaced) - 1; i >= 0; i-- {
+			replaced[i].rollback()
+		}
+		return nil, errors.Wrapf(err, "unable to write to %s", path)
+	}
+	return append(replaced, r), nil
+}
+
+// renameFile renames tmp to path, keeping a hard link to the previous version of path.
+func renameFile(path, tmp string) (replacedFile, error) {
+	r := replacedFile{path: path}
+	_, err := os.Lstat(path)
+	if err == nil {
+		r.previous, err = linkPrevious(path)
+		if err != nil {
+			return r, err
+		}
+	} else if !os.IsNotExist(err) {
+		return r, err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		r.commit()
+		return r, err
+	}
+	return r, nil
+}
+
+// linkPrevious creates a hard link to path with a unique name in the same directory.
+func linkPrevious(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".prev")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return name, os.Link(path, name)
+}
+
+// commit removes the previous version.
+func (r replacedFile) commit() {
+	if r.previous != "" {
+		os.Remove(r.previous)
+	}
+}
+
+// rollback restores the previous version, or removes the file if it did not exist.
+func (r replacedFile) rollback() {
+	var err error
+	if r.previous != "" {
+		err = os.Rename(r.previous, r.path)
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err != nil {
+		log.Errorf("unable to restore %s: %v", r.path, err)
+		return
+	}
+	log.Debugf("restored %s", r.path)
+}
+
+// fieldContent returns strings as is and other values as JSON.
+func fieldContent(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(v)
+}
+
+// writeTempFile writes b to a temporary file in the same directory as path so that it can be renamed to path atomically.
+func writeTempFile(path string, b []byte, mode os.FileMode, uid, gid int) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to write to %s", path)
+	}
+	tmp := f.Name()
+
+	err = func() error {
+		defer f.Close()
+		if err := f.Chmod(mode); err != nil {
+			return err
+		}
+		if uid >= 0 || gid >= 0 {
+			if err := f.Chown(uid, gid); err != nil {
+				return err
+			}
+		}
+		if _, err := f.Write(b); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrapf(err, "unable to write to %s", path)
+	}
+	return tmp, nil
+}
+
+// backupFile writes the current content of path to a temporary file with the same permission, to be renamed to
+// path.bak. It returns "" if path does not exist.
+func backupFile(path string) (string, error) {
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to back up %s", path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to back up %s", path)
+	}
+	return writeTempFile(path+".bak", b, st.Mode().Perm(), -1, -1)
+}
+
+// lookupOwner parses `user[:group]` where user and group are names or numeric IDs.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		userName, groupName = owner[:i], owner[i+1:]
+	}
+
+	uid, gid := -1, -1
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return -1, -1, errors.Wrapf(err, "invalid owner: %s", owner)
+			}
+			id, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return -1, -1, errors.Errorf("invalid owner: %s", owner)
+			}
+		}
+		uid = id
+	}
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, errors.Wrapf(err, "invalid owner: %s", owner)
+			}
+			id, err = strconv.Atoi(g.Gid)
+			if err != nil {
+				return -1, -1, errors.Errorf("invalid owner: %s", owner)
+			}
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
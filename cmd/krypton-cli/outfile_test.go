@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// listDir returns the names of the files in dir.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != content {
+		t.Errorf("unexpected content of %s: %s", filepath.Base(path), b)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != mode {
+		t.Errorf("unexpected mode of %s: %s", filepath.Base(path), st.Mode())
+	}
+}
+
+func TestWriteFileOutputs(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "key.pem")
+	fc := &fileOutputConfig{
+		Path:   filepath.Join(dir, "result.json"),
+		Fields: fieldOutputs{{Query: "certificate", Path: cert}, {Query: "privateKey", Path: key}},
+		Mode:   0640,
+		Backup: true,
+	}
+
+	changed, err := writeFileOutputs(fc, &outputConfig{}, []byte(`{"certificate":"cert-1","privateKey":"key-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("new files are not reported as changed")
+	}
+	assertFile(t, cert, "cert-1", 0640)
+	assertFile(t, key, "key-1", 0640)
+	assertFile(t, fc.Path, `{"certificate":"cert-1","privateKey":"key-1"}`+"\n", 0640)
+	if names := listDir(t, dir); len(names) != 3 {
+		t.Errorf("unexpected files: %v", names)
+	}
+
+	// the backup keeps the previous content and mode
+	err = os.Chmod(key, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err = writeFileOutputs(fc, &outputConfig{}, []byte(`{"certificate":"cert-1","privateKey":"key-2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("the changed file is not reported")
+	}
+	assertFile(t, key, "key-2", 0640)
+	assertFile(t, key+".bak", "key-1", 0600)
+	assertFile(t, cert+".bak", "cert-1", 0640)
+	want := []string{"cert.pem", "cert.pem.bak", "key.pem", "key.pem.bak", "result.json", "result.json.bak"}
+	if names := listDir(t, dir); !equalStrings(names, want) {
+		t.Errorf("unexpected files: %v", names)
+	}
+
+	changed, err = writeFileOutputs(fc, &outputConfig{}, []byte(`{"certificate":"cert-1","privateKey":"key-2"}`))
+	if err != nil || changed {
+		t.Errorf("unexpected result: %v, %v", changed, err)
+	}
+}
+
+func TestWriteFileOutputsMissingField(t *testing.T) {
+	dir := t.TempDir()
+	fc := &fileOutputConfig{
+		Fields: fieldOutputs{{Query: "certificate", Path: filepath.Join(dir, "cert.pem")}, {Query: "privateKey", Path: filepath.Join(dir, "key.pem")}},
+		Mode:   0600,
+	}
+	_, err := writeFileOutputs(fc, &outputConfig{}, []byte(`{"certificate":"cert"}`))
+	if err == nil {
+		t.Fatal("a missing field is accepted")
+	}
+	if names := listDir(t, dir); len(names) != 0 {
+		t.Errorf("files are written: %v", names)
+	}
+}
+
+func TestWriteFilesRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a")
+	created := filepath.Join(dir, "b")
+	// c cannot be replaced since it is a directory
+	blocked := filepath.Join(dir, "c")
+	err := os.WriteFile(existing, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(blocked, "child"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFiles(&fileOutputConfig{Mode: 0600}, map[string][]byte{
+		existing: []byte("new"),
+		created:  []byte("new"),
+		blocked:  []byte("new"),
+	})
+	if err == nil {
+		t.Fatal("a directory is replaced")
+	}
+	assertFile(t, existing, "old", 0644)
+	if names := listDir(t, dir); !equalStrings(names, []string{"a", "c"}) {
+		t.Errorf("files are not restored or temporary files are left: %v", names)
+	}
+}
+
+func TestWriteFilesRollbackBackup(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a")
+	fresh := filepath.Join(dir, "b")
+	blocked := filepath.Join(dir, "c")
+	files := map[string]string{existing: "old", existing + ".bak": "older", fresh: "old"}
+	for path, content := range files {
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.MkdirAll(filepath.Join(blocked, "child"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFiles(&fileOutputConfig{Mode: 0600, Backup: true}, map[string][]byte{
+		existing: []byte("new"),
+		fresh:    []byte("new"),
+		blocked:  []byte("new"),
+	})
+	if err == nil {
+		t.Fatal("a directory is replaced")
+	}
+	// the backups written before the failure are restored or removed as well
+	for path, content := range files {
+		assertFile(t, path, content, 0644)
+	}
+	if names := listDir(t, dir); !equalStrings(names, []string{"a", "a.bak", "b", "c"}) {
+		t.Errorf("backups are left: %v", names)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
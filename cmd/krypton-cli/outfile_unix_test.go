@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFilesOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	// only the current user and group can be set without privileges
+	owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	err := writeFiles(&fileOutputConfig{Mode: 0600, Owner: owner}, map[string][]byte{path: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := st.Sys().(*syscall.Stat_t)
+	if int(sys.Uid) != os.Getuid() || int(sys.Gid) != os.Getgid() {
+		t.Errorf("unexpected owner: %d:%d", sys.Uid, sys.Gid)
+	}
+
+	if _, _, err = lookupOwner("krypton-test-no-such-user"); err == nil {
+		t.Error("an unknown user is accepted")
+	}
+	err = writeFiles(&fileOutputConfig{Mode: 0600, Owner: "krypton-test-no-such-user"}, map[string][]byte{path: []byte("new")})
+	if err == nil {
+		t.Error("an unknown owner is accepted")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "key" {
+		t.Errorf("the file is replaced: %s", b)
+	}
+}
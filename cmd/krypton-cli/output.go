@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputFormatRaw    = ""
+	outputFormatJSON   = "json"
+	outputFormatPretty = "pretty"
+	outputFormatYAML   = "yaml"
+	outputFormatEnv    = "env"
+	outputFormatTable  = "table"
+)
+
+type outputConfig struct {
+	Format   string
+	Template string
+	Query    string
+}
+
+func validateOutputConfig(oc *outputConfig) error {
+	switch oc.Format {
+	case outputFormatRaw, outputFormatJSON, outputFormatPretty, outputFormatYAML, outputFormatEnv, outputFormatTable:
+	default:
+		return errors.Errorf("unknown output format: %s", oc.Format)
+	}
+	if oc.Template != "" && oc.Format != outputFormatRaw {
+		return errors.New("-template cannot be used with -output")
+	}
+	if oc.Query != "" {
+		if _, err := parseQuery(oc.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOutput formats the response body of an operation according to the output config.
+// Without -output, -template and -query, the response body is written as is.
+func writeOutput(w io.Writer, oc *outputConfig, body []byte) error {
+	if oc.Format == outputFormatRaw && oc.Template == "" && oc.Query == "" {
+		_, err := fmt.Fprintln(w, string(body))
+		return err
+	}
+
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	err := d.Decode(&v)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse the response as JSON")
+	}
+
+	name := "value"
+	if oc.Query != "" {
+		q, err := parseQuery(oc.Query)
+		if err != nil {
+			return err
+		}
+		v, err = q.apply(v)
+		if err != nil {
+			return err
+		}
+		if len(q) > 0 {
+			name = q[len(q)-1].String()
+		}
+	}
+
+	if oc.Template != "" {
+		return writeTemplate(w, oc.Template, v)
+	}
+
+	switch oc.Format {
+	case outputFormatRaw:
+		if s, ok := v.(string); ok {
+			_, err = fmt.Fprintln(w, s)
+			return err
+		}
+		fallthrough
+	case outputFormatJSON:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case outputFormatPretty:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case outputFormatYAML:
+		e := yaml.NewEncoder(w)
+		e.SetIndent(2)
+		err = e.Encode(toYAMLValue(v))
+		if err != nil {
+			return err
+		}
+		return e.Close()
+	case outputFormatEnv:
+		prefix := name
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			prefix = ""
+		}
+		for _, kv := range flatten(prefix, v) {
+			_, err = fmt.Fprintf(w, "%s=%s\n", envKey(kv.key), shellQuote(kv.value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tVALUE")
+		for _, kv := range flatten("", v) {
+			fmt.Fprintf(tw, "%s\t%s\n", kv.key, kv.value)
+		}
+		return tw.Flush()
+	}
+	return errors.Errorf("unknown output format: %s", oc.Format)
+}
+
+func writeTemplate(w io.Writer, text string, v interface{}) error {
+	t, err := template.New("output").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse -template")
+	}
+	err = t.Execute(w, v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// toYAMLValue converts json.Number to int64 / float64 so that they are not quoted in YAML.
+func toYAMLValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = toYAMLValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			a[i] = toYAMLValue(e)
+		}
+		return a
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	}
+	return v
+}
+
+type keyValue struct {
+	key   string
+	value string
+}
+
+// flatten converts nested objects and arrays into key/value pairs sorted by key.
+// Keys of nested values are joined with '.' e.g. "tags.name" or "items.0".
+func flatten(prefix string, v interface{}) []keyValue {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := []keyValue{}
+		for _, k := range keys {
+			result = append(result, flatten(join(k), t[k])...)
+		}
+		return result
+	case []interface{}:
+		result := []keyValue{}
+		for i, e := range t {
+			result = append(result, flatten(join(strconv.Itoa(i)), e)...)
+		}
+		return result
+	case nil:
+		return []keyValue{{key: prefix, value: ""}}
+	case string:
+		return []keyValue{{key: prefix, value: t}}
+	}
+	return []keyValue{{key: prefix, value: fmt.Sprint(v)}}
+}
+
+// envKey converts a flattened key into an environment variable name e.g. "tags.name" -> "TAGS_NAME".
+func envKey(key string) string {
+	var sb strings.Builder
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z':
+			if i > 0 && isUpperCamelBoundary(key, i) {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r - 'a' + 'A')
+		case r >= 'A' && r <= 'Z':
+			if i > 0 && isUpperCamelBoundary(key, i) {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// isUpperCamelBoundary reports whether key[i] starts a new word in camelCase e.g. "s" -> "I" in "pskId".
+func isUpperCamelBoundary(key string, i int) bool {
+	c, p := key[i], key[i-1]
+	return c >= 'A' && c <= 'Z' && p >= 'a' && p <= 'z'
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// querySegment is either an object key or an array index.
+type querySegment struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func (s querySegment) String() string {
+	if s.isKey {
+		return s.key
+	}
+	return strconv.Itoa(s.index)
+}
+
+type query []querySegment
+
+// parseQuery parses a JSONPath-style selector such as `$.tags.name`, `.items[0].id`, `imsi` or `['key.with.dots']`.
+func parseQuery(q string) (query, error) {
+	s := strings.TrimPrefix(q, "$")
+	result := query{}
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid query '%s': missing ']'", q)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				result = append(result, querySegment{key: inner[1 : len(inner)-1], isKey: true})
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil || i < 0 {
+				return nil, errors.Errorf("invalid query '%s': invalid index '%s'", q, inner)
+			}
+			result = append(result, querySegment{index: i})
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			result = append(result, querySegment{key: s[:end], isKey: true})
+			s = s[end:]
+		}
+	}
+	return result, nil
+}
+
+func (q query) apply(v interface{}) (interface{}, error) {
+	for _, seg := range q {
+		if seg.isKey {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("unable to select '%s': not an object", seg.key)
+			}
+			v, ok = m[seg.key]
+			if !ok {
+				return nil, errors.Errorf("unable to select '%s': not found", seg.key)
+			}
+			continue
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("unable to select [%d]: not an array", seg.index)
+		}
+		if seg.index >= len(a) {
+			return nil, errors.Errorf("unable to select [%d]: index out of range", seg.index)
+		}
+		v = a[seg.index]
+	}
+	return v, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func assertGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, actual, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s does not match\nexpected:\n%s\nactual:\n%s", path, expected, actual)
+	}
+}
+
+const testResponse = `{"credentials":{"accessKeyId":"AKIAEXAMPLE","secretAccessKey":"secret 'quoted'","expiration":1600000000},"region":"ap-northeast-1","identityIds":["id-1","id-2"],"enabled":true}
+`
+
+func TestWriteOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		oc   outputConfig
+	}{
+		{"raw", outputConfig{}},
+		{"json", outputConfig{Format: outputFormatJSON}},
+		{"pretty", outputConfig{Format: outputFormatPretty}},
+		{"yaml", outputConfig{Format: outputFormatYAML}},
+		{"env", outputConfig{Format: outputFormatEnv}},
+		{"table", outputConfig{Format: outputFormatTable}},
+		{"template", outputConfig{Template: `{{.credentials.accessKeyId}} {{json .identityIds}}`}},
+		{"query", outputConfig{Query: ".credentials.secretAccessKey"}},
+		{"query_index", outputConfig{Query: ".identityIds[1]"}},
+		{"query_env", outputConfig{Format: outputFormatEnv, Query: ".region"}},
+		{"query_json", outputConfig{Format: outputFormatJSON, Query: ".credentials"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOutputConfig(&tt.oc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = writeOutput(&buf, &tt.oc, []byte(testResponse))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("output", tt.name+".golden"), buf.Bytes())
+		})
+	}
+}
+
+func TestWriteOutputErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		oc   outputConfig
+		body string
+	}{
+		{"malformed JSON", outputConfig{Format: outputFormatJSON}, `{"foo":`},
+		{"no such key", outputConfig{Query: ".noSuchKey"}, testResponse},
+		{"index out of range", outputConfig{Query: ".identityIds[2]"}, testResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeOutput(&buf, &tt.oc, []byte(tt.body))
+			if err == nil {
+				t.Errorf("expected an error but got %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestGenerateLwM2MConfig(t *testing.T) {
+	result := []byte(`{"applicationKey":"orcUjHENbrc/GWSkOV4u+w==","applicationKeySource":"derived","pskId":"test-psk-id","serverUri":"coaps://jp.inventory.soracom.io:5684"}`)
+
+	for _, format := range []string{"wakaama", "anjay", "leshan"} {
+		t.Run(format, func(t *testing.T) {
+			oc := &outputConfig{}
+			lc, err := newLwM2MConfig(format, "hex", "bootstrapInventoryDevice", `{"endpoint":"test-endpoint"}`, oc, &fileOutputConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := generateLwM2MConfig(lc, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = writeOutput(&buf, oc, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("lwm2m", format+".golden"), buf.Bytes())
+		})
+	}
+}
+
+func TestNewLwM2MConfigErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		params    string
+		oc        outputConfig
+	}{
+		{"malformed params", "bootstrapInventoryDevice", `{"endpoint":`, outputConfig{}},
+		{"params not an object", "bootstrapInventoryDevice", `["test-endpoint"]`, outputConfig{}},
+		{"no endpoint", "bootstrapInventoryDevice", `{}`, outputConfig{}},
+		{"no params", "bootstrapInventoryDevice", "", outputConfig{}},
+		{"other operation", "getUserData", `{"endpoint":"test-endpoint"}`, outputConfig{}},
+		{"with -query", "bootstrapInventoryDevice", `{"endpoint":"test-endpoint"}`, outputConfig{Query: ".pskId"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newLwM2MConfig("wakaama", "hex", tt.operation, tt.params, &tt.oc, &fileOutputConfig{}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
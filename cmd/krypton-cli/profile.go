@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix         = "KRYPTON_"
+	envConfigFile     = "KRYPTON_CONFIG"
+	envProfile        = "KRYPTON_PROFILE"
+	defaultProfile    = "default"
+	userConfigDirName = "krypton"
+)
+
+// Prefixes of the environment variables set by krypton-cli for hooks and the command of exec. They are never read as
+// flags, so that krypton-cli run by a hook does not take the result for its flags.
+const (
+	envRunPrefix    = "KRYPTON_RUN_"
+	envResultPrefix = "KRYPTON_RESULT_"
+)
+
+// systemConfigFile is a variable so that tests can replace it.
+var systemConfigFile = "/etc/krypton/config.yaml"
+
+// flags that never come from a config file or an environment variable
+var nonConfigurableFlags = map[string]bool{
+	"config":  true,
+	"profile": true,
+	"help":    true,
+	"h":       true,
+	"p":       true,
+	"f":       true,
+	"version": true,
+}
+
+// short flags which share the variable with the long one
+var flagAliases = map[string]string{
+	"p": "params",
+	"f": "file",
+	"h": "help",
+}
+
+// configFile is the content of a krypton-cli configuration file, e.g.
+//
+//	default-profile: modem
+//	profiles:
+//	  modem:
+//	    interface: comm
+//	    port-name: /dev/ttyUSB2
+//	    baud-rate: 115200
+//
+// Keys in a profile are the same as the command line flag names without the leading '-'.
+type configFile struct {
+	DefaultProfile string                       `yaml:"default-profile"`
+	Profiles       map[string]map[string]string `yaml:"profiles"`
+}
+
+// applyProfileAndEnv fills in the flags which are not specified on the command line.
+// Precedence (highest first) is:
+//  1. command line flags
+//  2. environment variables (KRYPTON_ + upper-cased flag name with '-' replaced by '_', e.g. KRYPTON_PORT_NAME),
+//     except the ones with envRunPrefix and envResultPrefix
+//  3. the selected profile in the user's config file (~/.config/krypton/config.yaml)
+//  4. the selected profile in the system config file (/etc/krypton/config.yaml)
+//  5. default values of the flags
+func applyProfileAndEnv(fs *flag.FlagSet, configPath, profileName string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		if long, ok := flagAliases[f.Name]; ok {
+			explicit[long] = true
+		}
+	})
+
+	profile, err := loadProfile(configPath, profileName)
+	if err != nil {
+		return err
+	}
+
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || explicit[f.Name] || nonConfigurableFlags[f.Name] {
+			return
+		}
+
+		name := envVarName(f.Name)
+		v, found := os.LookupEnv(name)
+		if strings.HasPrefix(name, envRunPrefix) || strings.HasPrefix(name, envResultPrefix) {
+			found = false
+		}
+		if !found {
+			v, found = profile[f.Name]
+		}
+		if !found {
+			return
+		}
+
+		if err := fs.Set(f.Name, v); err != nil {
+			setErr = errors.Wrapf(err, "invalid value for %s", f.Name)
+		}
+	})
+	return setErr
+}
+
+// loadProfile returns the settings of the named profile merged from the config files.
+// An empty name selects KRYPTON_PROFILE, then default-profile in the config files, then "default".
+func loadProfile(configPath, name string) (map[string]string, error) {
+	if configPath == "" {
+		configPath = os.Getenv(envConfigFile)
+	}
+	paths := configFilePaths(configPath)
+
+	files := []*configFile{}
+	for _, p := range paths {
+		cf, err := readConfigFile(p)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) && configPath == "" {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, cf)
+	}
+
+	explicit := true
+	if name == "" {
+		name = os.Getenv(envProfile)
+	}
+	if name == "" {
+		for _, cf := range files {
+			if cf.DefaultProfile != "" {
+				name = cf.DefaultProfile
+			}
+		}
+	}
+	if name == "" {
+		name = defaultProfile
+		explicit = false
+	}
+
+	result := map[string]string{}
+	found := false
+	for _, cf := range files {
+		p, ok := cf.Profiles[name]
+		if !ok {
+			continue
+		}
+		found = true
+		for k, v := range p {
+			result[k] = v
+		}
+	}
+
+	if !found && explicit {
+		return nil, errors.Errorf("profile '%s' is not found in config files: %s", name, strings.Join(paths, ", "))
+	}
+
+	return result, nil
+}
+
+// configFilePaths returns config file paths in ascending order of priority.
+func configFilePaths(configPath string) []string {
+	if configPath != "" {
+		return []string{configPath}
+	}
+
+	paths := []string{systemConfigFile}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, userConfigDirName, "config.yaml"))
+	}
+	return paths
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var cf configFile
+	err = yaml.Unmarshal(b, &cf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse config file %s", path)
+	}
+	return &cf, nil
+}
+
+func envVarName(flagName string) string {
+	return fmt.Sprintf("%s%s", envPrefix, strings.ToUpper(strings.ReplaceAll(flagName, "-", "_")))
+}
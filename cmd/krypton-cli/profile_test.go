@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unsetEnv unsets the environment variable during the test.
+func unsetEnv(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func TestApplyProfileAndEnv(t *testing.T) {
+	const systemConfig = `
+profiles:
+  default:
+    port-name: /dev/system
+    baud-rate: "9600"
+    interface: comm
+  modem:
+    port-name: /dev/system-modem
+`
+	const userConfig = `
+profiles:
+  default:
+    port-name: /dev/user
+  modem:
+    baud-rate: "115200"
+  other:
+    port-name: /dev/user-other
+`
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		userConfig string
+		profile    string
+		want       map[string]string
+		wantErr    string
+	}{
+		{
+			name: "user config overrides system config",
+			want: map[string]string{"port-name": "/dev/user", "baud-rate": "9600", "interface": "comm"},
+		},
+		{
+			name: "env overrides config files",
+			env:  map[string]string{"KRYPTON_PORT_NAME": "/dev/env"},
+			want: map[string]string{"port-name": "/dev/env", "baud-rate": "9600"},
+		},
+		{
+			name: "flags override env",
+			args: []string{"-port-name", "/dev/flag"},
+			env:  map[string]string{"KRYPTON_PORT_NAME": "/dev/env"},
+			want: map[string]string{"port-name": "/dev/flag"},
+		},
+		{
+			name: "short flag overrides env of the long one",
+			args: []string{"-p", `{"a":1}`},
+			env:  map[string]string{"KRYPTON_PARAMS": `{"b":2}`},
+			want: map[string]string{"params": `{"a":1}`},
+		},
+		{
+			name:    "profile merged from both files",
+			profile: "modem",
+			want:    map[string]string{"port-name": "/dev/system-modem", "baud-rate": "115200", "interface": "iso7816"},
+		},
+		{
+			name: "profile from env",
+			env:  map[string]string{"KRYPTON_PROFILE": "other"},
+			want: map[string]string{"port-name": "/dev/user-other", "baud-rate": "57600"},
+		},
+		{
+			name:       "default-profile in config file",
+			userConfig: "default-profile: modem\n" + userConfig,
+			want:       map[string]string{"port-name": "/dev/system-modem", "baud-rate": "115200"},
+		},
+		{
+			name:    "unknown profile",
+			profile: "missing",
+			wantErr: "profile 'missing' is not found",
+		},
+		{
+			name:       "missing default profile",
+			userConfig: "profiles: {}\n",
+			want:       map[string]string{"port-name": "/dev/system", "baud-rate": "9600"},
+		},
+		{
+			name: "variables for hooks are not flags",
+			env:  map[string]string{"KRYPTON_RESULT_FILE": "/tmp/result.json"},
+			want: map[string]string{"result-file": ""},
+		},
+		{
+			name:    "invalid value",
+			env:     map[string]string{"KRYPTON_BAUD_RATE": "fast"},
+			wantErr: "invalid value for baud-rate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			defer func(path string) { systemConfigFile = path }(systemConfigFile)
+			systemConfigFile = filepath.Join(dir, "system.yaml")
+			err := os.WriteFile(systemConfigFile, []byte(systemConfig), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "user"))
+			err = os.MkdirAll(filepath.Join(dir, "user", userConfigDirName), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uc := userConfig
+			if tt.userConfig != "" {
+				uc = tt.userConfig
+			}
+			err = os.WriteFile(filepath.Join(dir, "user", userConfigDirName, "config.yaml"), []byte(uc), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{envConfigFile, envProfile, "KRYPTON_PORT_NAME", "KRYPTON_BAUD_RATE", "KRYPTON_PARAMS", "KRYPTON_INTERFACE"} {
+				unsetEnv(t, name)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			var portName, params, uiccInterface, resultFile string
+			var baudRate uint
+			fs.StringVar(&portName, "port-name", "", "")
+			fs.UintVar(&baudRate, "baud-rate", 57600, "")
+			fs.StringVar(&uiccInterface, "interface", "iso7816", "")
+			fs.StringVar(&params, "params", "", "")
+			fs.StringVar(&params, "p", "", "")
+			fs.StringVar(&resultFile, "result-file", "", "")
+			err = fs.Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = applyProfileAndEnv(fs, "", tt.profile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error with '%s', got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("expected %s=%s, got %s", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProfileConfigPath(t *testing.T) {
+	unsetEnv(t, envConfigFile)
+	unsetEnv(t, envProfile)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(path, []byte("profiles:\n  default:\n    port-name: /dev/config\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadProfile(path, "")
+	if err != nil || p["port-name"] != "/dev/config" {
+		t.Errorf("unexpected profile: %v, %v", p, err)
+	}
+
+	t.Setenv(envConfigFile, path)
+	p, err = loadProfile("", "")
+	if err != nil || p["port-name"] != "/dev/config" {
+		t.Errorf("KRYPTON_CONFIG is not used: %v, %v", p, err)
+	}
+
+	// the config file specified explicitly must exist
+	if _, err = loadProfile(filepath.Join(dir, "missing.yaml"), ""); err == nil {
+		t.Error("a missing config file is accepted")
+	}
+	err = os.WriteFile(path, []byte("profiles: ["), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadProfile(path, ""); err == nil || !strings.Contains(err.Error(), "unable to parse config file") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// parsedFlags is the result of parseFlags in the child process of parseFlagsInChild.
+type parsedFlags struct {
+	Operation         string
+	RequestParameters string
+	DisableKeyCache   bool
+	Error             string
+}
+
+// parseFlagsInChild runs parseFlags with args and env in a child process, since parseFlags uses the global flag set.
+// The environment variables of krypton-cli in the environment of the test are not passed.
+func parseFlagsInChild(t *testing.T, env []string, args ...string) parsedFlags {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "parsed.json")
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestParseFlagsInChild$")
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envPrefix) {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	cmd.Env = append(cmd.Env, "PARSE_FLAGS_OUT="+out, "PARSE_FLAGS_ARGS="+string(b), "XDG_CONFIG_HOME="+t.TempDir())
+	cmd.Env = append(cmd.Env, env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	var pf parsedFlags
+	b, err = os.ReadFile(out)
+	if err == nil {
+		err = json.Unmarshal(b, &pf)
+	}
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	return pf
+}
+
+func TestParseFlagsInChild(t *testing.T) {
+	out := os.Getenv("PARSE_FLAGS_OUT")
+	if out == "" {
+		t.Skip("run by parseFlagsInChild")
+	}
+	var args []string
+	err := json.Unmarshal([]byte(os.Getenv("PARSE_FLAGS_ARGS")), &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Args = append([]string{"krypton-cli"}, args...)
+
+	var pf parsedFlags
+	_, appCfg, endorseCfg, kryptonCfg, err := parseFlags()
+	if err != nil {
+		pf.Error = err.Error()
+	} else {
+		pf.Operation = appCfg.Operation
+		pf.RequestParameters = kryptonCfg.RequestParameters
+		pf.DisableKeyCache = endorseCfg.DisableKeyCache
+	}
+	b, err := json.Marshal(pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(out, b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
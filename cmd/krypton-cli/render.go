@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:        "render",
+		Description: "perform getSubscriberMetadata and getUserData, and render the Go templates of -render into the files. Only the changed files are rewritten",
+		Run:         runRender,
+	})
+}
+
+type renderConfig struct {
+	Targets renderTargets
+}
+
+type renderTarget struct {
+	Template string
+	Path     string
+}
+
+// renderTargets is a flag.Value which accepts `-render TEMPLATE=FILE` multiple times.
+type renderTargets []renderTarget
+
+func (r *renderTargets) String() string {
+	ss := []string{}
+	for _, t := range *r {
+		ss = append(ss, t.Template+"="+t.Path)
+	}
+	return strings.Join(ss, ",")
+}
+
+func (r *renderTargets) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("must be in the form of TEMPLATE=FILE: %s", s)
+	}
+	for _, t := range *r {
+		if t.Path == s[i+1:] {
+			return errors.Errorf("%s is rendered more than once", t.Path)
+		}
+	}
+	*r = append(*r, renderTarget{Template: s[:i], Path: s[i+1:]})
+	return nil
+}
+
+func runRender(cc *commandContext) error {
+	if len(cc.appCfg.Render.Targets) == 0 {
+		return usageError(errors.New("render requires -render"))
+	}
+	if cc.appCfg.FileOutput.Path != "" || len(cc.appCfg.FileOutput.Fields) > 0 {
+		return usageError(errors.New("-out and -out-field cannot be used with render"))
+	}
+	if cc.appCfg.Record != "" || cc.appCfg.Replay != "" {
+		return usageError(errors.New("-record and -replay cannot be used with render"))
+	}
+	templates, err := parseRenderTemplates(cc.appCfg.Render.Targets)
+	if err != nil {
+		return usageError(err)
+	}
+	if cc.endorseErr != nil {
+		return cc.endorseErr
+	}
+	return render(os.Stdout, templates, cc.kryptonCfg, cc.appCfg)
+}
+
+func parseRenderTemplates(targets renderTargets) ([]*template.Template, error) {
+	var templates []*template.Template
+	for _, t := range targets {
+		b, err := ioutil.ReadFile(t.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read the template")
+		}
+		tmpl, err := template.New(filepath.Base(t.Template)).Option("missingkey=error").Funcs(renderFuncs).Parse(string(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse %s", t.Template)
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// renderFuncs are the functions available in the templates of -render, in addition to the ones of text/template.
+var renderFuncs = template.FuncMap{
+	// tag is replaced by renderTag before execution
+	"tag": renderTag(nil),
+	"base64Encode": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"base64Decode": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	// jsonPath selects a value by the query of -query, e.g. {{jsonPath "$.server.host" .userdata}}
+	"jsonPath": func(q string, v interface{}) (interface{}, error) {
+		query, err := parseQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		return query.apply(v)
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// parseJSON parses a string such as a tag value, e.g. {{(parseJSON (tag "config")).interval}}
+	"parseJSON": func(s string) (interface{}, error) {
+		return decodeJSON([]byte(s))
+	},
+}
+
+// renderTag returns the function which returns the tag of the subscriber, or the default (or "") when it is not set,
+// e.g. {{tag "name" "unknown"}}
+func renderTag(metadata interface{}) func(string, ...string) (string, error) {
+	return func(name string, def ...string) (string, error) {
+		if len(def) > 1 {
+			return "", errors.New("tag accepts only one default value")
+		}
+		if m, ok := metadata.(map[string]interface{}); ok {
+			if tags, ok := m["tags"].(map[string]interface{}); ok {
+				if v, ok := tags[name].(string); ok {
+					return v, nil
+				}
+			}
+		}
+		if len(def) > 0 {
+			return def[0], nil
+		}
+		return "", nil
+	}
+}
+
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err := d.Decode(&v)
+	return v, err
+}
+
+// render performs the operations with one SIM authentication, and writes the files whose content is changed after
+// all the templates are rendered, so that no file is replaced when a template fails.
+func render(w io.Writer, templates []*template.Template, kCfg *krypton.Config, appCfg *appConfig) error {
+	cfg := *kCfg
+	kc, err := krypton.NewClient(&cfg)
+	if err != nil {
+		return err
+	}
+	defer kc.Close()
+
+	ar, err := kc.AuthenticationResult()
+	if err != nil {
+		return err
+	}
+	metadata, err := kc.PerformOperationWithAuthentication("getSubscriberMetadata", ar)
+	if err != nil {
+		return err
+	}
+	userdata, err := kc.PerformOperationWithAuthentication("getUserData", ar)
+	if err != nil {
+		return err
+	}
+	data, err := renderData(metadata, userdata)
+	if err != nil {
+		return err
+	}
+
+	contents := map[string][]byte{}
+	for i, t := range appCfg.Render.Targets {
+		var buf bytes.Buffer
+		err = templates[i].Funcs(template.FuncMap{"tag": renderTag(data["metadata"])}).Execute(&buf, data)
+		if err != nil {
+			return outputError(errors.Wrapf(err, "unable to render %s", t.Path))
+		}
+		current, err := ioutil.ReadFile(t.Path)
+		if err == nil && bytes.Equal(current, buf.Bytes()) {
+			continue
+		}
+		contents[t.Path] = buf.Bytes()
+	}
+
+	err = writeFiles(&appCfg.FileOutput, contents)
+	if err != nil {
+		return outputError(err)
+	}
+	for _, t := range appCfg.Render.Targets {
+		state := "unchanged"
+		if _, ok := contents[t.Path]; ok {
+			state = "updated"
+		}
+		fmt.Fprintf(w, "%s: %s\n", t.Path, state)
+	}
+	return runHooks(&appCfg.Hooks, "render", metadata, len(contents) > 0)
+}
+
+// renderData returns the data of the templates: .metadata is the subscriber metadata, and .userdata is the userdata
+// parsed as JSON, or as is when it is not JSON.
+func renderData(metadata, userdata []byte) (map[string]interface{}, error) {
+	m, err := decodeJSON(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse the subscriber metadata as JSON")
+	}
+	var u interface{} = string(userdata)
+	if v, err := decodeJSON(userdata); err == nil {
+		u = v
+	}
+	return map[string]interface{}{"metadata": m, "userdata": u}, nil
+}
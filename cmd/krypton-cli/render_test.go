@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, dir string, templates map[string]string) renderTargets {
+	t.Helper()
+	var targets renderTargets
+	for name, text := range templates {
+		path := filepath.Join(dir, name+".tmpl")
+		err := os.WriteFile(path, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = targets.Set(path + "=" + filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return targets
+}
+
+func TestRenderFuncs(t *testing.T) {
+	dir := t.TempDir()
+	targets := writeTemplates(t, dir, map[string]string{
+		"funcs": `{{tag "name"}} {{tag "missing" "none"}} {{tag "secret" | base64Decode}} {{base64Encode .metadata.imsi}} ` +
+			`{{jsonPath "$.servers[1].host" .userdata}} {{json (jsonPath "servers[0]" .userdata)}} {{(parseJSON (tag "config")).interval}}`,
+	})
+	templates, err := parseRenderTemplates(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := renderData(
+		[]byte(`{"imsi":"001010000000001","tags":{"name":"device-1","secret":"c2VjcmV0","config":"{\"interval\":60}"}}`),
+		[]byte(`{"servers":[{"host":"a.example.com"},{"host":"b.example.com"}]}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = templates[0].Funcs(map[string]interface{}{"tag": renderTag(data["metadata"])}).Execute(&buf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `device-1 none secret MDAxMDEwMDAwMDAwMDAx b.example.com {"host":"a.example.com"} 60`
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	data, err = renderData([]byte(`{"imsi":"001010000000001"}`), []byte("plain text"))
+	if err != nil || data["userdata"] != "plain text" {
+		t.Errorf("unexpected data: %v, %v", data, err)
+	}
+
+	if err = targets.Set("other.tmpl=" + targets[0].Path); err == nil {
+		t.Error("the same file is accepted twice")
+	}
+	if _, err = parseRenderTemplates(writeTemplates(t, dir, map[string]string{"broken": "{{.metadata"})); err == nil {
+		t.Error("a broken template is accepted")
+	}
+}
+
+func TestRender(t *testing.T) {
+	userdata := `{"interval":60}`
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/subscriber_metadata"):
+			io.WriteString(w, `{"imsi":"001010000000001","tags":{"name":"device-1"}}`)
+		case strings.HasSuffix(r.URL.Path, "/userdata"):
+			io.WriteString(w, userdata)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	a := &countingAuthenticator{Authenticator: kCfg.Authenticator}
+	kCfg.Authenticator = a
+
+	dir := t.TempDir()
+	hookLog := filepath.Join(dir, "hook.log")
+	appCfg := &appConfig{
+		FileOutput: fileOutputConfig{Mode: 0644},
+		Hooks:      hookConfig{OnChange: "echo $KRYPTON_RUN_OPERATION $KRYPTON_RESULT_IMSI >> " + hookLog, Timeout: time.Minute},
+		Render: renderConfig{Targets: writeTemplates(t, dir, map[string]string{
+			"name.conf":     `name={{tag "name"}}`,
+			"interval.conf": `interval={{.userdata.interval}}`,
+		})},
+	}
+	templates, err := parseRenderTemplates(appCfg.Render.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = render(&out, templates, kCfg, appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.count != 1 {
+		t.Errorf("SIM authentication is performed %d times", a.count)
+	}
+	for name, want := range map[string]string{"name.conf": "name=device-1", "interval.conf": "interval=60"} {
+		b, _ := os.ReadFile(filepath.Join(dir, name))
+		if string(b) != want {
+			t.Errorf("unexpected %s: %s", name, b)
+		}
+	}
+
+	userdata = `{"interval":30}`
+	out.Reset()
+	err = render(&out, templates, kCfg, appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), filepath.Join(dir, "name.conf")+": unchanged\n") ||
+		!strings.Contains(out.String(), filepath.Join(dir, "interval.conf")+": updated\n") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "interval.conf")); string(b) != "interval=30" {
+		t.Errorf("unexpected interval.conf: %s", b)
+	}
+
+	out.Reset()
+	err = render(&out, templates, kCfg, appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "updated") {
+		t.Errorf("unchanged files are rewritten:\n%s", out.String())
+	}
+	if b, _ := os.ReadFile(hookLog); string(b) != "render 001010000000001\nrender 001010000000001\n" {
+		t.Errorf("unexpected hooks:\n%s", b)
+	}
+
+	// no file is replaced when a template fails
+	userdata = `{"interval":10}`
+	appCfg.Render.Targets = append(appCfg.Render.Targets, writeTemplates(t, dir, map[string]string{"missing.conf": `{{.userdata.missing}}`})...)
+	templates, err = parseRenderTemplates(appCfg.Render.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = render(io.Discard, templates, kCfg, appCfg); exitCodeOf(err) != exitCodeOutput {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "interval.conf")); string(b) != "interval=30" {
+		t.Errorf("interval.conf is replaced: %s", b)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+	"github.com/soracom/krypton-client-go/krypton/simulator"
+)
+
+const (
+	// interfaceSimulator is the value of -interface to use a simulated SIM instead of endorse-client-go.
+	interfaceSimulator = "simulator"
+
+	simulatorHTTPTimeout = 30 * time.Second
+)
+
+// newSimulatorAuthenticator returns an Authenticator of the simulated SIM in simConfigPath, which authenticates
+// with the Keys API endpoint in eCfg. Its requests to the provisioning API in kCfg carry the trace context when tracing is enabled.
+// The endpoints must be stand-ins such as keysapitest, since the SORACOM APIs do not know the protocol of simulated SIMs.
+func newSimulatorAuthenticator(simConfigPath string, eCfg *endorse.Config, kCfg *krypton.Config) (*simulator.Authenticator, error) {
+	if eCfg.KeysAPIEndpointURL == nil {
+		return nil, errors.New("-keys-api-endpoint-url must be specified with -interface simulator since the Keys API does not know simulated SIMs")
+	}
+	if kCfg.ProvisioningAPIEndpointURL == nil {
+		return nil, errors.New("-provisioning-api-endpoint-url must be specified with -interface simulator since the provisioning API rejects the signatures of simulated SIMs")
+	}
+	for _, u := range []*url.URL{eCfg.KeysAPIEndpointURL, kCfg.ProvisioningAPIEndpointURL} {
+		if isSoracomAPI(u) {
+			return nil, errors.Errorf("-interface simulator cannot be used with %s, which does not know the protocol of simulated SIMs", u)
+		}
+	}
+	cfg, err := simulator.LoadSIMConfig(simConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	sim, err := simulator.NewSIM(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return simulator.NewAuthenticator(sim, &simulator.Config{
+		KeysAPIEndpointURL: eCfg.KeysAPIEndpointURL,
+		SignatureAlgorithm: eCfg.SignatureAlgorithm,
+		HTTPClient: &http.Client{
+			Timeout:   simulatorHTTPTimeout,
+			Transport: krypton.NewTracingTransport(http.DefaultTransport),
+		},
+	})
+}
+
+// isSoracomAPI reports whether u is an endpoint of the SORACOM APIs such as https://g.api.soracom.io/.
+func isSoracomAPI(u *url.URL) bool {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	return host == "soracom.io" || strings.HasSuffix(host, ".soracom.io")
+}
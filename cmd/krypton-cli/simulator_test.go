@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+	"github.com/soracom/krypton-client-go/krypton/keysapitest"
+	"github.com/soracom/krypton-client-go/krypton/simulator"
+)
+
+var testSIMConfig = &simulator.SIMConfig{
+	IMSI: "001010000000001",
+	K:    "465b5ce8b199b49faa5f0a2ee238a6bc",
+	OPc:  "cd63cb71954a9f4e48a5994e37a02baf",
+}
+
+// newSimulatedConfig returns krypton.Config with a simulated SIM, and the Keys API stand-in which it authenticates
+// with. api serves the provisioning API, and receives only the requests with valid signatures.
+func newSimulatedConfig(t *testing.T, api http.Handler) (*krypton.Config, *keysapitest.Server) {
+	t.Helper()
+	keys, err := keysapitest.NewServer(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(keys.Close)
+	s := httptest.NewServer(keys.RequireSignature(api))
+	t.Cleanup(s.Close)
+
+	sim, err := simulator.NewSIM(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ku, _ := url.Parse(keys.URL)
+	a, err := simulator.NewAuthenticator(sim, &simulator.Config{KeysAPIEndpointURL: ku})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	return &krypton.Config{ProvisioningAPIEndpointURL: u, Authenticator: a}, keys
+}
+
+func TestNewSimulatorAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.json")
+	err := os.WriteFile(path, []byte(`{"imsi":"001010000000001","k":"465b5ce8b199b49faa5f0a2ee238a6bc","opc":"cd63cb71954a9f4e48a5994e37a02baf"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://127.0.0.1:8080")
+	kCfg := &krypton.Config{ProvisioningAPIEndpointURL: u}
+
+	a, err := newSimulatorAuthenticator(path, &endorse.Config{KeysAPIEndpointURL: u, SignatureAlgorithm: "SHA-256"}, kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imsi, err := a.ReadIMSI()
+	if err != nil || imsi != "001010000000001" {
+		t.Errorf("unexpected IMSI: %s, %v", imsi, err)
+	}
+
+	if _, err = newSimulatorAuthenticator(path, &endorse.Config{SignatureAlgorithm: "SHA-256"}, kCfg); err == nil {
+		t.Error("missing Keys API endpoint was accepted")
+	}
+	if _, err = newSimulatorAuthenticator(path, &endorse.Config{KeysAPIEndpointURL: u}, &krypton.Config{}); err == nil {
+		t.Error("missing provisioning API endpoint was accepted")
+	}
+	if _, err = newSimulatorAuthenticator(filepath.Join(t.TempDir(), "missing.json"), &endorse.Config{KeysAPIEndpointURL: u}, kCfg); err == nil {
+		t.Error("missing SIM config was accepted")
+	}
+
+	// the SORACOM APIs reject simulated SIMs
+	for _, s := range []string{"https://g.api.soracom.io/", "https://api.soracom.io", "https://G.API.SORACOM.IO./"} {
+		su, _ := url.Parse(s)
+		if _, err = newSimulatorAuthenticator(path, &endorse.Config{KeysAPIEndpointURL: su}, kCfg); err == nil {
+			t.Errorf("Keys API endpoint %s was accepted", s)
+		}
+		if _, err = newSimulatorAuthenticator(path, &endorse.Config{KeysAPIEndpointURL: u}, &krypton.Config{ProvisioningAPIEndpointURL: su}); err == nil {
+			t.Errorf("provisioning API endpoint %s was accepted", s)
+		}
+	}
+	notSoracom, _ := url.Parse("https://soracom.io.example.com/")
+	if isSoracomAPI(notSoracom) {
+		t.Errorf("%s is regarded as the SORACOM API", notSoracom)
+	}
+}
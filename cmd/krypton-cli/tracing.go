@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+type tracingConfig struct {
+	OTLPEndpoint string
+}
+
+// enabled reports whether spans should be exported, either by -otlp-endpoint or the standard OTEL_EXPORTER_OTLP_* variables.
+func (tc *tracingConfig) enabled() bool {
+	return tc.OTLPEndpoint != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// startTracing creates a tracer provider exporting spans to an OTLP/HTTP collector.
+func startTracing(tc *tracingConfig) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{}
+	if tc.OTLPEndpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(tc.OTLPEndpoint))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create OTLP exporter")
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "krypton-cli"),
+		attribute.String("service.version", Version),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	return tp, nil
+}
+
+// propagateTraceContext lets the requests of endorse-client-go, which uses the default transport of net/http, carry
+// the trace context of the provisioning API requests.
+func propagateTraceContext(cfg *krypton.Config) {
+	t := krypton.NewTracingTransport(http.DefaultTransport)
+	http.DefaultTransport = t
+	cfg.TracingTransport = t
+	if r, ok := cfg.Authenticator.(*krypton.Recorder); ok {
+		r.TracingTransport = t
+	}
+}
+
+func stopTracing(tp *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		log.Errorf("unable to export spans: %v", err)
+	}
+}
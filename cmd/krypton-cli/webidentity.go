@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const defaultWebIdentityOperation = "generateAmazonCognitoOpenIdToken"
+
+func init() {
+	registerCommand(&command{
+		Name:        "web-identity-token",
+		Description: "write the OpenID token of -operation (default: " + defaultWebIdentityOperation + ") to -out for AWS_WEB_IDENTITY_TOKEN_FILE, and keep it refreshed -refresh-before it expires until SIGINT or SIGTERM. -once writes it only once",
+		Run:         runWebIdentityToken,
+	})
+}
+
+type webIdentityConfig struct {
+	// Once writes the token without refreshing it
+	Once bool
+}
+
+func runWebIdentityToken(cc *commandContext) error {
+	appCfg := cc.appCfg
+	if appCfg.FileOutput.Path == "" || len(appCfg.FileOutput.Fields) > 0 {
+		return usageError(errors.New("web-identity-token requires -out for the token file, and does not accept -out-field"))
+	}
+	if cc.endorseErr != nil {
+		return cc.endorseErr
+	}
+	operation := appCfg.Operation
+	if operation == "" {
+		operation = defaultWebIdentityOperation
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	m := &tokenFileMaintainer{
+		operation:     operation,
+		kc:            cc.kc,
+		file:          appCfg.FileOutput,
+		hooks:         &appCfg.Hooks,
+		refreshBefore: appCfg.Exec.RefreshBefore,
+		once:          appCfg.WebIdentity.Once,
+	}
+	// the token is written as is, not as a JSON string
+	m.file.Path = ""
+	m.file.Fields = fieldOutputs{{Query: "token", Path: appCfg.FileOutput.Path}}
+	return m.run(stop)
+}
+
+// tokenFileMaintainer writes the token in the result of the operation to a file, and rewrites it before it expires.
+type tokenFileMaintainer struct {
+	operation     string
+	kc            *krypton.Client
+	file          fileOutputConfig
+	hooks         *hookConfig
+	refreshBefore time.Duration
+	once          bool
+}
+
+// run writes the token until a signal is received from stop. Errors after the first token is written are logged and
+// retried, since the token in the file is still valid for a while.
+func (m *tokenFileMaintainer) run(stop <-chan os.Signal) error {
+	for first := true; ; first = false {
+		var d time.Duration
+		exp, err := m.refresh()
+		switch {
+		case err != nil && (first || m.once):
+			return err
+		case err != nil:
+			log.Errorf("unable to refresh the token, retrying in %s: %v", refreshRetryInterval, err)
+			d = refreshRetryInterval
+		case m.once:
+			return nil
+		default:
+			d = time.Until(exp.Add(-m.refreshBefore))
+			if d < minRefreshInterval {
+				log.Warningf("the token expires at %s, which is too soon to be refreshed %s before", exp.Format(time.RFC3339), m.refreshBefore)
+				d = minRefreshInterval
+			}
+			log.Debugf("the token expires at %s, refreshing in %s", exp.Format(time.RFC3339), d)
+		}
+
+		select {
+		case <-time.After(d):
+		case sig := <-stop:
+			log.Debugf("stopping on %s", sig)
+			return nil
+		}
+	}
+}
+
+// refresh writes a new token, and returns its expiry.
+func (m *tokenFileMaintainer) refresh() (time.Time, error) {
+	result, err := m.kc.PerformOperationWithResult(m.operation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	contents, err := renderFileOutputs(&m.file, &outputConfig{}, result)
+	if err != nil {
+		return time.Time{}, outputError(errors.Wrapf(err, "the result of %s does not have a token", m.operation))
+	}
+	exp, ok := jwtExpiry(string(contents[m.file.Fields[0].Path]))
+	if !ok && !m.once {
+		return time.Time{}, errors.New("the token is not a JWT with the exp claim, so it cannot be refreshed before it expires")
+	}
+
+	changed, err := writeFileOutputs(&m.file, &outputConfig{}, result)
+	if err != nil {
+		return time.Time{}, outputError(err)
+	}
+	log.Debugf("wrote the token to %s", m.file.Fields[0].Path)
+	err = runHooks(m.hooks, m.operation, result, changed)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return exp, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+func TestTokenFileMaintainer(t *testing.T) {
+	defer func(d time.Duration) { minRefreshInterval = d }(minRefreshInterval)
+	minRefreshInterval = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	fetched := 0
+	token := ""
+	stop := make(chan os.Signal, 1)
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched++
+		token = testJWT(time.Now().Unix() + int64(fetched))
+		if fetched == 2 {
+			stop <- os.Interrupt
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"identityId":"id","token":%q}`, token)
+	}))
+	kc, err := krypton.NewClient(kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kc.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	m := &tokenFileMaintainer{
+		operation:     defaultWebIdentityOperation,
+		kc:            kc,
+		file:          fileOutputConfig{Fields: fieldOutputs{{Query: "token", Path: path}}, Mode: 0600},
+		hooks:         &hookConfig{},
+		refreshBefore: time.Hour,
+	}
+	err = m.run(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched < 2 {
+		t.Errorf("the token is not refreshed: %d", fetched)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != token {
+		t.Errorf("unexpected token: %s", b)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %s", fi.Mode())
+	}
+}
+
+func TestTokenFileMaintainerErrors(t *testing.T) {
+	response := `{"identityId":"id","token":"opaque"}`
+	kCfg, _ := newSimulatedConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+	kc, err := krypton.NewClient(kCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kc.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	m := &tokenFileMaintainer{
+		operation:     defaultWebIdentityOperation,
+		kc:            kc,
+		file:          fileOutputConfig{Fields: fieldOutputs{{Query: "token", Path: path}}, Mode: 0600},
+		hooks:         &hookConfig{},
+		refreshBefore: time.Minute,
+	}
+	if err = m.run(nil); err == nil {
+		t.Error("a token without the exp claim is accepted for refreshing")
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the token file is written: %v", err)
+	}
+
+	m.once = true
+	if err = m.run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "opaque" {
+		t.Errorf("unexpected token: %s", b)
+	}
+
+	response = `{"identityId":"id"}`
+	if err = m.run(nil); exitCodeOf(err) != exitCodeOutput {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
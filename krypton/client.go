@@ -1,32 +1,37 @@
 package krypton
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"time"
 
-	logging "github.com/op/go-logging"
 	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
-	cfg *Config
-}
-
-var (
-	logger *logging.Logger
-)
-
-func log(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Debugf(format, args...)
-	}
+	cfg       *Config
+	logger    Logger
+	logFields []interface{}
+	metrics   Metrics
+	tracer    trace.Tracer
+	ctx       context.Context
+	operation string
+	// authResult is used instead of the key cache and SIM authentication, set by PerformOperationWithAuthentication.
+	authResult *endorse.AuthenticationResult
 }
 
 func NewClient(cfg *Config) (*Client, error) {
 	if cfg == nil {
 		return nil, errors.New("config must be specified")
 	}
-	logger = cfg.Logger
 
 	if cfg.ProvisioningAPIEndpointURL == nil {
 		u, err := url.Parse("https://g.api.soracom.io/")
@@ -36,8 +41,16 @@ func NewClient(cfg *Config) (*Client, error) {
 		cfg.ProvisioningAPIEndpointURL = u
 	}
 
+	var m Metrics = nopMetrics{}
+	if cfg.Metrics != nil {
+		m = cfg.Metrics
+	}
+
 	return &Client{
-		cfg: cfg,
+		cfg:     cfg,
+		logger:  cfg.Logger,
+		metrics: m,
+		tracer:  newTracer(cfg.TracerProvider),
 	}, nil
 }
 
@@ -45,28 +58,197 @@ func (c *Client) Close() {
 	// Do nothing
 }
 
+// PerformOperation performs the operation and prints the result to stdout.
 func (c *Client) PerformOperation(operationName string) error {
-	op, ok := operations[operationName]
-	if !ok {
-		return errors.Errorf("unknown operation name: %s", operationName)
+	result, err := c.PerformOperationWithResult(operationName)
+	if err != nil {
+		return err
 	}
-	return op.Perform(c)
+	fmt.Println(string(result))
+	return nil
 }
 
-func (c *Client) getValueFromRequestParameterOption(name string) (interface{}, error) {
+// PerformOperationWithResult performs the operation and returns the response body.
+func (c *Client) PerformOperationWithResult(operationName string) ([]byte, error) {
+	op, err := resultOperation(operationName)
+	if err != nil {
+		return nil, err
+	}
+
+	oc, span := c.withLogFields("operation", operationName).startSpan("krypton."+operationName, trace.SpanKindInternal,
+		attribute.String("krypton.operation", operationName),
+	)
+	oc.operation = operationName
+	oc.debug("performing operation")
+	start := time.Now()
+	result, err := op.PerformWithResult(oc)
+	oc.metrics.ObserveOperation(operationName, err, time.Since(start))
+	endSpan(span, err)
+	if err != nil {
+		oc.debug("operation failed", "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+	oc.debug("operation completed", "duration", time.Since(start))
+	return result, nil
+}
+
+// PerformOperationWithAuthentication performs the operation with ar, e.g. from AuthenticationResult, instead of the key
+// cache and SIM authentication, so that several operations share one SIM authentication.
+// When the provisioning API rejects the key of ar, the request is retried once with SIM authentication.
+func (c *Client) PerformOperationWithAuthentication(operationName string, ar *endorse.AuthenticationResult) ([]byte, error) {
+	if ar == nil {
+		return nil, errors.New("authentication result must be specified")
+	}
+	cc := *c
+	cc.authResult = ar
+	return cc.PerformOperationWithResult(operationName)
+}
+
+// AuthenticationResult returns the cached authentication result if available, otherwise performs SIM authentication.
+// It is to be passed to PerformOperationWithAuthentication. CK in the result must be kept secret.
+func (c *Client) AuthenticationResult() (*endorse.AuthenticationResult, error) {
+	ar, _, err := c.authenticate()
+	return ar, err
+}
+
+// Authenticate performs SIM authentication only and returns the keyId, e.g. to check the SIM and the Keys API.
+// CK is not returned.
+// The key cache is not used, but the result is stored to it.
+func (c *Client) Authenticate() (string, error) {
+	ar, err := c.doAuthentication()
+	if err != nil {
+		return "", err
+	}
+	return ar.KeyID, nil
+}
+
+// authenticate returns the authentication result given to PerformOperationWithAuthentication or the cached one if
+// available, otherwise performs SIM authentication. cached reports whether SIM authentication was skipped.
+func (c *Client) authenticate() (ar *endorse.AuthenticationResult, cached bool, err error) {
+	if c.authResult != nil {
+		return c.authResult, true, nil
+	}
+	if ar := c.cachedAuthentication(); ar != nil {
+		return ar, true, nil
+	}
+	ar, err = c.doAuthentication()
+	return ar, false, err
+}
+
+func (c *Client) doAuthentication() (*endorse.AuthenticationResult, error) {
+	a := c.cfg.authenticator()
+	if a == nil {
+		return nil, errors.New("EndorseClient or Authenticator must be specified to authenticate the SIM")
+	}
+	_, span := c.startSpan("krypton.authenticate", trace.SpanKindClient)
+	c.debug("performing authentication")
+	start := time.Now()
+	ar, err := a.DoAuthentication()
+	c.metrics.ObserveAuthentication(c.operation, err, time.Since(start))
+	endSpan(span, err)
+	if err != nil {
+		c.debug("authentication failed", "duration", time.Since(start), "error", err)
+		return nil, classifyAuthenticationError(err)
+	}
+	c.debug("authentication completed", "duration", time.Since(start))
+	c.storeAuthentication(ar)
+	return ar, nil
+}
+
+func (c *Client) postWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	rc, span := c.startSpan("POST "+u.Path, trace.SpanKindClient,
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("url.full", u.String()),
+		attribute.String("url.path", u.Path),
+	)
+	c.debug("sending request", "path", u.Path)
+	start := time.Now()
+	resp, err := postWithSignature(rc.ctx, c.cfg.authenticator(), c.cfg.TracingTransport, u, ck, body)
+	if err != nil {
+		endSpan(span, err)
+		c.metrics.ObserveRequest(c.operation, 0, err, time.Since(start))
+		c.debug("request failed", "path", u.Path, "duration", time.Since(start), "error", err)
+		return nil, newError(ErrorCategoryNetwork, err)
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	endSpan(span, nil)
+	c.metrics.ObserveRequest(c.operation, resp.StatusCode, nil, time.Since(start))
+	c.debug("received response", "path", u.Path, "status", resp.StatusCode, "duration", time.Since(start))
+	return resp, nil
+}
+
+// signedRequest authenticates and sends the body built by newBody for the keyId to u, and returns the authentication
+// result and the response body of a successful response.
+// When the provisioning API rejects the key, its key cache entry is deleted, and the request is retried once with SIM
+// authentication if the key was cached.
+func (c *Client) signedRequest(u *url.URL, newBody func(keyID string) interface{}) (*endorse.AuthenticationResult, []byte, error) {
+	ar, cached, err := c.authenticate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.withLogFields("attempt", attempt).postWithSignature(u, ar.CK, newBody(ar.KeyID))
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, newError(ErrorCategoryNetwork, errors.Wrap(err, "unable to read the response"))
+		}
+
+		err = checkResponse(resp, body)
+		if Category(err) == ErrorCategoryAuthentication {
+			c.evictAuthentication()
+			if cached {
+				c.debug("cached key is rejected, retrying with SIM authentication", "path", u.Path, "status", resp.StatusCode, "attempt", attempt)
+				c.metrics.ObserveRetry(c.operation)
+				ar, err = c.doAuthentication()
+				if err != nil {
+					return nil, nil, err
+				}
+				cached = false
+				continue
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return ar, body, nil
+	}
+}
+
+// requestParameters parses RequestParameters. It returns nil when no parameters are specified.
+func (c *Client) requestParameters() (map[string]interface{}, error) {
 	if c.cfg.RequestParameters == "" {
-		return nil, errors.Errorf("parameter '%s' must be specified in -params option", name)
+		return nil, nil
 	}
 
 	var m map[string]interface{}
 	err := json.Unmarshal([]byte(c.cfg.RequestParameters), &m)
 	if err != nil {
-		return nil, errors.Errorf("unable parse -params / -p option")
+		return nil, newError(ErrorCategoryInvalidParameters, errors.Errorf("unable parse -params / -p option"))
+	}
+	return m, nil
+}
+
+func (c *Client) getValueFromRequestParameterOption(name string) (interface{}, error) {
+	if c.cfg.RequestParameters == "" {
+		return nil, newError(ErrorCategoryInvalidParameters, errors.Errorf("parameter '%s' must be specified in -params option", name))
+	}
+
+	m, err := c.requestParameters()
+	if err != nil {
+		return nil, err
 	}
 
 	v, found := m[name]
 	if !found {
-		return nil, errors.Errorf("no parameter found with the name %s in request parameters", name)
+		return nil, newError(ErrorCategoryInvalidParameters, errors.Errorf("no parameter found with the name %s in request parameters", name))
 	}
 
 	return v, nil
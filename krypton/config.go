@@ -1,15 +1,56 @@
 package krypton
 
 import (
+	"net/http"
 	"net/url"
+	"time"
 
-	"github.com/op/go-logging"
 	"github.com/soracom/endorse-client-go/endorse"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Authenticator performs SIM authentication and sends requests signed with the resulting CK.
+// *endorse.Client implements it.
+type Authenticator interface {
+	DoAuthentication() (*endorse.AuthenticationResult, error)
+	PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error)
+}
+
 type Config struct {
 	ProvisioningAPIEndpointURL *url.URL
 	RequestParameters          string
 	EndorseClient              *endorse.Client
-	Logger                     *logging.Logger
+	Logger                     Logger
+	Metrics                    Metrics
+	TracerProvider             trace.TracerProvider
+	// TracingTransport propagates the trace context of the requests of an Authenticator which does not implement
+	// ContextAuthenticator, such as EndorseClient. It must be the transport of the HTTP client of the Authenticator.
+	TracingTransport *TracingTransport
+
+	// Authenticator is used instead of EndorseClient when it is set, e.g. a simulated SIM, a Recorder or a Replayer.
+	Authenticator Authenticator
+
+	// KeyCache stores results of SIM authentication to skip it until they expire. It is used only when the IMSI of the
+	// SIM can be read with IMSIReader or by the Authenticator, and an entry is deleted when the provisioning API
+	// rejects its key. Nil (default) disables the cache.
+	KeyCache KeyCache
+	// IMSIReader reads the IMSI of the SIM for the key cache when the Authenticator does not implement IMSIReader,
+	// e.g. NewIMSIReader for EndorseClient.
+	IMSIReader IMSIReader
+	// KeyCacheTTL is how long a cached result is used. (default: DefaultKeyCacheTTL)
+	KeyCacheTTL time.Duration
+
+	// ShowSecrets disables redaction of sensitive fields such as private keys in log messages.
+	ShowSecrets bool
+}
+
+// authenticator returns Authenticator, or EndorseClient if Authenticator is not set. It returns nil if neither is set.
+func (cfg *Config) authenticator() Authenticator {
+	if cfg.Authenticator != nil {
+		return cfg.Authenticator
+	}
+	if cfg.EndorseClient != nil {
+		return cfg.EndorseClient
+	}
+	return nil
 }
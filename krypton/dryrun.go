@@ -0,0 +1,91 @@
+package krypton
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+// DryRunKeyIDPlaceholder is the keyId in the request body of a dry run without SIM authentication.
+const DryRunKeyIDPlaceholder = "<keyId>"
+
+// DryRunResult is the request an operation would send to the provisioning API.
+type DryRunResult struct {
+	Operation string `json:"operation"`
+	URL       string `json:"url"`
+	// RequestBody is the JSON body of the request. Its keyId is DryRunKeyIDPlaceholder unless Authenticated.
+	RequestBody   json.RawMessage `json:"requestBody"`
+	Authenticated bool            `json:"authenticated"`
+}
+
+var errDryRun = errors.New("dry run")
+
+// dryRunAuthenticator captures the request instead of sending it.
+type dryRunAuthenticator struct {
+	base   Authenticator
+	result *DryRunResult
+}
+
+func (a *dryRunAuthenticator) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	if a.base == nil {
+		return &endorse.AuthenticationResult{KeyID: DryRunKeyIDPlaceholder}, nil
+	}
+	ar, err := a.base.DoAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	a.result.Authenticated = true
+	return &endorse.AuthenticationResult{KeyID: ar.KeyID}, nil
+}
+
+func (a *dryRunAuthenticator) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	err := e.Encode(body)
+	if err != nil {
+		return nil, err
+	}
+	a.result.URL = u.String()
+	a.result.RequestBody = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return nil, errDryRun
+}
+
+// DryRun validates the request parameters of the operation and returns the request it would send, without
+// contacting the provisioning API. SIM authentication is performed only when authenticate is true, otherwise
+// keyId in the request body is DryRunKeyIDPlaceholder. CK is never included in the result.
+func (c *Client) DryRun(operationName string, authenticate bool) (*DryRunResult, error) {
+	op, err := resultOperation(operationName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DryRunResult{Operation: operationName}
+	a := &dryRunAuthenticator{result: result}
+	if authenticate {
+		a.base = c.cfg.authenticator()
+	}
+
+	cfg := *c.cfg
+	cfg.Authenticator = a
+	cfg.KeyCache = nil
+	dc := c.withLogFields("operation", operationName, "dryRun", true)
+	dc.cfg = &cfg
+	dc.operation = operationName
+	dc.metrics = nopMetrics{}
+	dc.tracer = newTracer(nil)
+
+	dc.debug("performing dry run")
+	_, err = op.PerformWithResult(dc)
+	if !errors.Is(err, errDryRun) {
+		if err == nil {
+			err = errors.New("operation completed without sending a request")
+		}
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package krypton
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDryRun(t *testing.T) {
+	auth := &fakeAuthenticator{err: errors.New("must not be called")}
+	c := newTestClient(t, "https://api.example.com/", `{"endpoint":"test-endpoint"}`, auth)
+
+	r, err := c.DryRun("bootstrapInventoryDevice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.URL != "https://api.example.com/v1/provisioning/soracom/inventory/bootstrap" {
+		t.Errorf("unexpected URL: %s", r.URL)
+	}
+	if string(r.RequestBody) != `{"keyId":"<keyId>","endpoint":"test-endpoint","requestParameters":{"endpoint":"test-endpoint"}}` {
+		t.Errorf("unexpected request body: %s", r.RequestBody)
+	}
+	if r.Authenticated {
+		t.Error("must not be authenticated")
+	}
+
+	auth.err = nil
+	r, err = c.DryRun("getUserData", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Authenticated || string(r.RequestBody) != `{"keyId":"test-key-id","requestParameters":{"endpoint":"test-endpoint"}}` {
+		t.Errorf("unexpected result: %+v %s", r, r.RequestBody)
+	}
+	if auth.requests != 0 {
+		t.Error("request must not be sent")
+	}
+}
+
+func TestDryRunErrors(t *testing.T) {
+	c := newTestClient(t, "https://api.example.com/", `{"foo":"bar"}`, &fakeAuthenticator{})
+	_, err := c.DryRun("bootstrapInventoryDevice", false)
+	if Category(err) != ErrorCategoryInvalidParameters {
+		t.Errorf("expected invalid parameters but got %v", err)
+	}
+
+	c = newTestClient(t, "https://api.example.com/", "", &fakeAuthenticator{err: errors.New("authentication failed")})
+	_, err = c.DryRun("getUserData", true)
+	if Category(err) != ErrorCategoryAuthentication {
+		t.Errorf("expected authentication error but got %v", err)
+	}
+
+	_, err = c.DryRun("noSuchOperation", false)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
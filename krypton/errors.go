@@ -0,0 +1,150 @@
+package krypton
+
+import (
+	"io/fs"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorCategory classifies errors returned by Client so that callers can tell the cause of a failure.
+type ErrorCategory string
+
+const (
+	// ErrorCategoryUnknown is for errors which do not fall into any other category.
+	ErrorCategoryUnknown ErrorCategory = "unknown"
+	// ErrorCategoryInvalidParameters is for missing or malformed request parameters, and 4xx responses other than 401 / 403.
+	ErrorCategoryInvalidParameters ErrorCategory = "invalidParameters"
+	// ErrorCategoryDevice is for errors accessing the UICC e.g. no SIM, no card reader or no modem.
+	ErrorCategoryDevice ErrorCategory = "device"
+	// ErrorCategoryAuthentication is for failed SIM authentication, and 401 / 403 responses.
+	ErrorCategoryAuthentication ErrorCategory = "authentication"
+	// ErrorCategoryNetwork is for errors connecting to the API endpoints.
+	ErrorCategoryNetwork ErrorCategory = "network"
+	// ErrorCategoryServer is for 5xx responses and responses which cannot be parsed.
+	ErrorCategoryServer ErrorCategory = "server"
+)
+
+// Error is an error with its category. StatusCode is set when the error is caused by a response from the provisioning API.
+type Error struct {
+	Category   ErrorCategory
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Cause is for github.com/pkg/errors.
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Category returns the category of err, or ErrorCategoryUnknown if err is not an *Error.
+func Category(err error) ErrorCategory {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return ErrorCategoryUnknown
+}
+
+func newError(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Category: category, Err: err}
+}
+
+// checkResponse returns an error for unsuccessful responses from the provisioning API.
+func checkResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	category := ErrorCategoryInvalidParameters
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		category = ErrorCategoryServer
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		category = ErrorCategoryAuthentication
+	}
+	return &Error{
+		Category:   category,
+		StatusCode: resp.StatusCode,
+		Err:        errors.Errorf("unsuccessful response: %s\n%s", resp.Status, string(body)),
+	}
+}
+
+// classifyAuthenticationError tells device errors and network errors from rejected authentications.
+// endorse-client-go does not export error types, so the errors of the OS and the network wrapped in its errors are examined.
+func classifyAuthenticationError(err error) error {
+	switch {
+	case isNetworkError(err):
+		return newError(ErrorCategoryNetwork, err)
+	case isDeviceError(err):
+		return newError(ErrorCategoryDevice, err)
+	}
+	return newError(ErrorCategoryAuthentication, err)
+}
+
+// isDeviceError reports whether err is caused by accessing a device file such as a serial port or a card reader, or by
+// running mmcli.
+func isDeviceError(err error) bool {
+	var pe *fs.PathError
+	if errors.As(err, &pe) && isDevicePath(pe.Path) {
+		return true
+	}
+	var ee *exec.Error
+	if errors.As(err, &ee) {
+		return true
+	}
+	var xe *exec.ExitError
+	if errors.As(err, &xe) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ENODEV, syscall.ENXIO, syscall.EBUSY, syscall.EIO} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// windowsCOMPortPattern matches COM ports of Windows, e.g. COM3 and \\.\COM10.
+var windowsCOMPortPattern = regexp.MustCompile(`^(?i)(\\\\\.\\)?COM[0-9]+$`)
+
+// isDevicePath reports whether path is a device file such as /dev/ttyUSB0 or COM3.
+func isDevicePath(path string) bool {
+	return strings.HasPrefix(path, "/dev/") || windowsCOMPortPattern.MatchString(path)
+}
+
+func isNetworkError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		// syscall.Errno also implements net.Error
+		if _, ok := ne.(syscall.Errno); !ok {
+			return true
+		}
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+	var de *net.DNSError
+	return errors.As(err, &de)
+}
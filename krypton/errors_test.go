@@ -0,0 +1,91 @@
+package krypton
+
+import (
+	"io/fs"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestClassifyAuthenticationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCategory
+	}{
+		{"missing serial port", errors.Wrap(&fs.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.ENOENT}, "unable to open port"), ErrorCategoryDevice},
+		{"serial port without permission", &fs.PathError{Op: "open", Path: "/dev/ttyACM0", Err: syscall.EACCES}, ErrorCategoryDevice},
+		{"windows COM port", &fs.PathError{Op: "open", Path: `\\.\COM10`, Err: syscall.ENOENT}, ErrorCategoryDevice},
+		{"missing file other than a device", &fs.PathError{Op: "open", Path: "/home/user/.cache/key", Err: syscall.ENOENT}, ErrorCategoryAuthentication},
+		{"file whose name starts with COM", &fs.PathError{Op: "open", Path: "COMMON", Err: syscall.ENOENT}, ErrorCategoryAuthentication},
+		{"device is busy", errors.Wrap(syscall.EBUSY, "unable to read"), ErrorCategoryDevice},
+		{"no device", syscall.ENODEV, ErrorCategoryDevice},
+		{"mmcli not found", &exec.Error{Name: "mmcli", Err: exec.ErrNotFound}, ErrorCategoryDevice},
+		{"mmcli failed", &exec.ExitError{}, ErrorCategoryDevice},
+		{"DNS failure", &net.DNSError{Err: "no such host", Name: "g.api.soracom.io"}, ErrorCategoryNetwork},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorCategoryNetwork},
+		{"message mentioning transport", errors.New("unsupported transport, see the report"), ErrorCategoryAuthentication},
+		{"rejected authentication", errors.New("authentication failed: invalid RES"), ErrorCategoryAuthentication},
+		{"categorized error", &Error{Category: ErrorCategoryServer, Err: errors.New("Keys API returned 500")}, ErrorCategoryServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Category(classifyAuthenticationError(tt.err)); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorCategory
+	}{
+		{http.StatusOK, ""},
+		{http.StatusNoContent, ""},
+		{http.StatusBadRequest, ErrorCategoryInvalidParameters},
+		{http.StatusNotFound, ErrorCategoryInvalidParameters},
+		{http.StatusUnauthorized, ErrorCategoryAuthentication},
+		{http.StatusForbidden, ErrorCategoryAuthentication},
+		{http.StatusInternalServerError, ErrorCategoryServer},
+		{http.StatusServiceUnavailable, ErrorCategoryServer},
+	}
+	for _, tt := range tests {
+		err := checkResponse(&http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status)}, []byte("{}"))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %v", tt.status, err)
+			}
+			continue
+		}
+		var e *Error
+		if !errors.As(err, &e) || e.Category != tt.want || e.StatusCode != tt.status {
+			t.Errorf("%d: unexpected error: %#v", tt.status, err)
+		}
+	}
+}
+
+func TestCategory(t *testing.T) {
+	if c := Category(errors.New("plain")); c != ErrorCategoryUnknown {
+		t.Errorf("unexpected category of a plain error: %s", c)
+	}
+	wrapped := errors.Wrap(newError(ErrorCategoryNetwork, errors.New("timeout")), "getUserData")
+	if c := Category(wrapped); c != ErrorCategoryNetwork {
+		t.Errorf("unexpected category of a wrapped error: %s", c)
+	}
+	// the first category is kept
+	if c := Category(newError(ErrorCategoryUnknown, wrapped)); c != ErrorCategoryNetwork {
+		t.Errorf("the category is overwritten: %s", c)
+	}
+	if newError(ErrorCategoryNetwork, nil) != nil {
+		t.Error("nil is wrapped")
+	}
+	if _, err := resultOperation("noSuchOperation"); Category(err) != ErrorCategoryInvalidParameters {
+		t.Errorf("unexpected category of an unknown operation: %v", err)
+	}
+}
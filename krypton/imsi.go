@@ -0,0 +1,134 @@
+package krypton
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+// imsiReadTimeout is how long reading the IMSI from the modem may take.
+const imsiReadTimeout = 10 * time.Second
+
+// NewIMSIReader returns an IMSIReader for the SIM used by *endorse.Client with cfg, to be set to Config.IMSIReader
+// so that the key cache can be used with a physical SIM. The IMSI is read with AT+CIMI on the serial port of the comm
+// interface (Linux only), or from ModemManager with the mmcli interface.
+// The IMSI cannot be read with the other interfaces such as iso7816 and autoDetect, which do not tell which SIM is used.
+func NewIMSIReader(cfg *endorse.Config) (IMSIReader, error) {
+	switch cfg.UICCInterfaceType {
+	case endorse.UICCInterfaceTypeComm:
+		if cfg.Serial.PortName == "" {
+			return nil, errors.New("the port name must be specified to read the IMSI with the comm interface")
+		}
+		return &modemIMSIReader{serial: cfg.Serial, timeout: imsiReadTimeout}, nil
+	case endorse.UICCInterfaceTypeMmcli:
+		return &mmcliIMSIReader{run: runCommand}, nil
+	}
+	return nil, errors.New("the IMSI can be read only with the comm and mmcli interfaces")
+}
+
+// modemIMSIReader reads the IMSI with AT+CIMI on the serial port of the modem.
+type modemIMSIReader struct {
+	serial  endorse.SerialConfig
+	timeout time.Duration
+}
+
+func (r *modemIMSIReader) ReadIMSI() (string, error) {
+	port, err := openSerialPort(&r.serial, r.timeout)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to open %s to read the IMSI", r.serial.PortName)
+	}
+	defer port.Close()
+	return requestIMSI(port)
+}
+
+// requestIMSI sends AT+CIMI to the modem on rw, and returns the IMSI in the response.
+func requestIMSI(rw io.ReadWriter) (string, error) {
+	_, err := io.WriteString(rw, "AT+CIMI\r")
+	if err != nil {
+		return "", err
+	}
+
+	imsi := ""
+	s := bufio.NewScanner(rw)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "OK":
+			if imsi == "" {
+				return "", errors.New("no IMSI in the response to AT+CIMI")
+			}
+			return imsi, nil
+		case line == "ERROR" || strings.HasPrefix(line, "+CME ERROR"):
+			return "", errors.Errorf("AT+CIMI failed: %s", line)
+		case isIMSI(line):
+			imsi = line
+		}
+		// other lines such as the echo of the command are ignored
+	}
+	if s.Err() != nil {
+		return "", errors.Wrap(s.Err(), "unable to read the response to AT+CIMI")
+	}
+	return "", errors.New("the modem closed the port before responding to AT+CIMI")
+}
+
+// mmcliIMSIReader reads the IMSI of the SIM of the modem from ModemManager.
+type mmcliIMSIReader struct {
+	run func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+func (r *mmcliIMSIReader) ReadIMSI() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), imsiReadTimeout)
+	defer cancel()
+
+	out, err := r.run(ctx, "mmcli", "-m", "any", "--output-keyvalue")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to find the modem with mmcli")
+	}
+	sim := keyValue(out, "modem.generic.sim")
+	if sim == "" || sim == "--" {
+		return "", errors.New("the modem has no SIM")
+	}
+	out, err = r.run(ctx, "mmcli", "-i", sim, "--output-keyvalue")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read the SIM with mmcli")
+	}
+	imsi := keyValue(out, "sim.properties.imsi")
+	if !isIMSI(imsi) {
+		return "", errors.Errorf("invalid IMSI from mmcli: %s", imsi)
+	}
+	return imsi, nil
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// keyValue returns the value of key in the output of mmcli --output-keyvalue, e.g. `sim.properties.imsi : 4401...`
+func keyValue(out []byte, key string) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// isIMSI reports whether s is an IMSI, which is up to 15 digits with the MCC and the MNC.
+func isIMSI(s string) bool {
+	if len(s) < 6 || len(s) > 15 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package krypton
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"golang.org/x/sys/unix"
+)
+
+var serialBaudRates = map[uint]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+var serialDataBits = map[uint]uint32{
+	5: unix.CS5,
+	6: unix.CS6,
+	7: unix.CS7,
+	8: unix.CS8,
+}
+
+// openSerialPort opens the port in raw mode with the settings of cfg. Reads and writes fail after timeout.
+func openSerialPort(cfg *endorse.SerialConfig, timeout time.Duration) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(cfg.PortName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = configureSerialPort(f, cfg)
+	if err == nil {
+		err = f.SetDeadline(time.Now().Add(timeout))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func configureSerialPort(f *os.File, cfg *endorse.SerialConfig) error {
+	speed, ok := serialBaudRates[cfg.BaudRate]
+	if !ok {
+		return errors.Errorf("unsupported baud rate: %d", cfg.BaudRate)
+	}
+	size, ok := serialDataBits[cfg.DataBits]
+	if !ok {
+		return errors.Errorf("unsupported data bits: %d", cfg.DataBits)
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var termErr error
+	err = rc.Control(func(fd uintptr) {
+		t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			termErr = err
+			return
+		}
+		// the same as cfmakeraw(3)
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CBAUD | unix.CSIZE | unix.CSTOPB | unix.PARENB | unix.PARODD
+		t.Cflag |= speed | size | unix.CLOCAL | unix.CREAD
+		if cfg.StopBits == 2 {
+			t.Cflag |= unix.CSTOPB
+		}
+		switch cfg.ParityMode {
+		case 1:
+			t.Cflag |= unix.PARENB | unix.PARODD
+		case 2:
+			t.Cflag |= unix.PARENB
+		}
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		termErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err != nil {
+		return err
+	}
+	return termErr
+}
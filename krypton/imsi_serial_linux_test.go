@@ -0,0 +1,73 @@
+//go:build linux
+
+package krypton
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/soracom/endorse-client-go/endorse"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master of a pseudo terminal and the path of its slave, which stands in for the modem port.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals are not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var ptyErr error
+	err = rc.Control(func(fd uintptr) {
+		ptyErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0)
+		if ptyErr == nil {
+			n, ptyErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if err == nil {
+		err = ptyErr
+	}
+	if err != nil {
+		t.Skipf("unable to unlock the pseudo terminal: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestModemIMSIReader(t *testing.T) {
+	master, port := openPTY(t)
+	go func() {
+		line, err := bufio.NewReader(master).ReadString('\r')
+		if err != nil || line != "AT+CIMI\r" {
+			fmt.Fprint(master, "\r\nERROR\r\n")
+			return
+		}
+		fmt.Fprint(master, "\r\n440100000000000\r\n\r\nOK\r\n")
+	}()
+
+	cfg := endorse.SerialConfig{PortName: port, BaudRate: 115200, DataBits: 8, StopBits: 1}
+	r := &modemIMSIReader{serial: cfg, timeout: 5 * time.Second}
+	imsi, err := r.ReadIMSI()
+	if err != nil || imsi != "440100000000000" {
+		t.Errorf("unexpected IMSI: %s %v", imsi, err)
+	}
+
+	// the modem does not respond
+	r.timeout = 100 * time.Millisecond
+	if imsi, err = r.ReadIMSI(); err == nil {
+		t.Errorf("IMSI is read without a response: %s", imsi)
+	}
+
+	cfg.BaudRate = 12345
+	if _, err = (&modemIMSIReader{serial: cfg, timeout: time.Second}).ReadIMSI(); err == nil {
+		t.Error("an unsupported baud rate is accepted")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package krypton
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+func openSerialPort(cfg *endorse.SerialConfig, timeout time.Duration) (io.ReadWriteCloser, error) {
+	return nil, errors.New("reading the IMSI from the serial port is supported only on Linux")
+}
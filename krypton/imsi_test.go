@@ -0,0 +1,109 @@
+package krypton
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+// fakeModem answers AT+CIMI with response.
+type fakeModem struct {
+	response string
+	sent     bytes.Buffer
+	r        io.Reader
+}
+
+func (m *fakeModem) Write(p []byte) (int, error) {
+	m.r = strings.NewReader(m.response)
+	return m.sent.Write(p)
+}
+
+func (m *fakeModem) Read(p []byte) (int, error) {
+	return m.r.Read(p)
+}
+
+func TestRequestIMSI(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{"without echo", "\r\n440100000000000\r\n\r\nOK\r\n", "440100000000000"},
+		{"with echo", "AT+CIMI\r\r\n440100000000000\r\n\r\nOK\r\n", "440100000000000"},
+		{"error", "\r\nERROR\r\n", ""},
+		{"no SIM", "\r\n+CME ERROR: 10\r\n", ""},
+		{"no IMSI", "\r\nOK\r\n", ""},
+		{"closed", "\r\n440100000000000\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &fakeModem{response: tt.response}
+			imsi, err := requestIMSI(m)
+			if m.sent.String() != "AT+CIMI\r" {
+				t.Errorf("unexpected command: %q", m.sent.String())
+			}
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("expected an error but got %s", imsi)
+				}
+				return
+			}
+			if err != nil || imsi != tt.want {
+				t.Errorf("unexpected IMSI: %s %v", imsi, err)
+			}
+		})
+	}
+}
+
+func TestMmcliIMSIReader(t *testing.T) {
+	outputs := map[string]string{
+		"mmcli -m any --output-keyvalue": "modem.dbus-path                 : /org/freedesktop/ModemManager1/Modem/0\n" +
+			"modem.generic.sim               : /org/freedesktop/ModemManager1/SIM/0\n",
+		"mmcli -i /org/freedesktop/ModemManager1/SIM/0 --output-keyvalue": "sim.dbus-path                   : /org/freedesktop/ModemManager1/SIM/0\n" +
+			"sim.properties.imsi             : 440100000000000\n",
+	}
+	r := &mmcliIMSIReader{run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		out, ok := outputs[name+" "+strings.Join(args, " ")]
+		if !ok {
+			return nil, errors.New("exit status 1")
+		}
+		return []byte(out), nil
+	}}
+	imsi, err := r.ReadIMSI()
+	if err != nil || imsi != "440100000000000" {
+		t.Errorf("unexpected IMSI: %s %v", imsi, err)
+	}
+
+	outputs["mmcli -m any --output-keyvalue"] = "modem.generic.sim               : --\n"
+	if imsi, err = r.ReadIMSI(); err == nil {
+		t.Errorf("IMSI is read without a SIM: %s", imsi)
+	}
+	delete(outputs, "mmcli -m any --output-keyvalue")
+	if imsi, err = r.ReadIMSI(); err == nil {
+		t.Errorf("IMSI is read without a modem: %s", imsi)
+	}
+}
+
+func TestNewIMSIReader(t *testing.T) {
+	tests := []struct {
+		cfg     endorse.Config
+		wantErr bool
+	}{
+		{endorse.Config{UICCInterfaceType: endorse.UICCInterfaceTypeComm, Serial: endorse.SerialConfig{PortName: "/dev/ttyUSB2"}}, false},
+		{endorse.Config{UICCInterfaceType: endorse.UICCInterfaceTypeComm}, true},
+		{endorse.Config{UICCInterfaceType: endorse.UICCInterfaceTypeMmcli}, false},
+		{endorse.Config{UICCInterfaceType: endorse.UICCInterfaceTypeIso7816}, true},
+		{endorse.Config{UICCInterfaceType: endorse.UICCInterfaceTypeAutoDetect}, true},
+	}
+	for _, tt := range tests {
+		_, err := NewIMSIReader(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("unexpected result for interface %d: %v", tt.cfg.UICCInterfaceType, err)
+		}
+	}
+}
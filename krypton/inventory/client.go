@@ -0,0 +1,619 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v3"
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const (
+	defaultLifetime     = 300 * time.Second
+	ackTimeout          = 2 * time.Second
+	maxRetransmit       = 4
+	maxMessageSize      = 1500
+	lwm2mVersion        = "1.0"
+	serverShortServerID = 123
+
+	// EXCHANGE_LIFETIME of RFC 7252 with the default transmission parameters
+	defaultExchangeTimeout = 247 * time.Second
+)
+
+// LwM2M object IDs
+const (
+	objectIDServer = 1
+	objectIDDevice = 3
+)
+
+// Device object (/3) resource IDs
+const (
+	resourceManufacturer     = 0
+	resourceModelNumber      = 1
+	resourceSerialNumber     = 2
+	resourceFirmwareVersion  = 3
+	resourceReboot           = 4
+	resourceErrorCode        = 11
+	resourceCurrentTime      = 13
+	resourceSupportedBinding = 16
+)
+
+// Server object (/1) resource IDs
+const (
+	resourceShortServerID      = 0
+	resourceLifetime           = 1
+	resourceNotificationStored = 6
+	resourceBinding            = 7
+)
+
+// DeviceInfo holds resources of the LwM2M Device object (/3/0) exposed to SORACOM Inventory.
+type DeviceInfo struct {
+	Manufacturer    string
+	ModelNumber     string
+	SerialNumber    string
+	FirmwareVersion string
+}
+
+// ClientConfig is the configuration of Client.
+type ClientConfig struct {
+	// Bootstrap is the result of bootstrapInventoryDevice. Endpoint must be set.
+	Bootstrap *BootstrapResult
+	// Lifetime of the registration. Client updates the registration before it expires. (default: 300s)
+	Lifetime time.Duration
+	// ExchangeTimeout limits the time to wait for the response to a request, including a separate response after
+	// an empty ACK. (default: 247s, EXCHANGE_LIFETIME of RFC 7252)
+	ExchangeTimeout time.Duration
+	Device          DeviceInfo
+	// OnReboot is called when the server executes the Reboot resource (/3/0/4).
+	OnReboot func()
+	// Dial connects to the LwM2M server. By default, coaps:// server URIs are connected with DTLS using
+	// pskId and applicationKey as the PSK identity and the key, and coap:// server URIs with plain UDP.
+	Dial   func(ctx context.Context) (net.Conn, error)
+	Logger krypton.Logger
+}
+
+// Client is a minimal LwM2M client which registers to SORACOM Inventory with the result of bootstrapInventoryDevice
+// and exposes the Server (/1/0) and Device (/3/0) objects.
+type Client struct {
+	cfg  *ClientConfig
+	conn net.Conn
+
+	mu        sync.Mutex
+	nextMID   uint16
+	pending   map[uint16]chan *coapMessage
+	separate  map[string]chan *coapMessage
+	location  string
+	readerErr error
+	done      chan struct{}
+}
+
+// NewClient validates cfg and creates a Client. Call Run, or Connect and Register to start.
+func NewClient(cfg *ClientConfig) (*Client, error) {
+	if cfg == nil || cfg.Bootstrap == nil {
+		return nil, errors.New("bootstrap result must be specified")
+	}
+	if cfg.Bootstrap.Endpoint == "" {
+		return nil, errors.New("endpoint name must be specified")
+	}
+	if cfg.Lifetime == 0 {
+		cfg.Lifetime = defaultLifetime
+	}
+	if cfg.ExchangeTimeout == 0 {
+		cfg.ExchangeTimeout = defaultExchangeTimeout
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = func(ctx context.Context) (net.Conn, error) {
+			return dialServer(ctx, cfg.Bootstrap)
+		}
+	}
+
+	var mid [2]byte
+	_, err := rand.Read(mid[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		cfg:      cfg,
+		nextMID:  binary.BigEndian.Uint16(mid[:]),
+		pending:  map[uint16]chan *coapMessage{},
+		separate: map[string]chan *coapMessage{},
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func dialServer(ctx context.Context, r *BootstrapResult) (net.Conn, error) {
+	u, err := url.Parse(r.ServerURI)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid serverUri: %s", r.ServerURI)
+	}
+	host, port, err := r.ServerHostPort()
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "coap" && u.Port() == "" {
+		port = "5683"
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "coap":
+		return net.DialUDP("udp", nil, addr)
+	case "coaps":
+		psk, err := r.PSK()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dtls.Dial("udp", addr, &dtls.Config{
+			PSK: func([]byte) ([]byte, error) {
+				return psk, nil
+			},
+			PSKIdentityHint: []byte(r.PSKID),
+			CipherSuites: []dtls.CipherSuiteID{
+				dtls.TLS_PSK_WITH_AES_128_CCM_8,
+				dtls.TLS_PSK_WITH_AES_128_CBC_SHA256,
+				dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = conn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "DTLS handshake failed")
+		}
+		return conn, nil
+	}
+	return nil, errors.Errorf("unsupported scheme of serverUri: %s", r.ServerURI)
+}
+
+func (c *Client) debug(msg string, keysAndValues ...interface{}) {
+	if c.cfg.Logger != nil {
+		c.cfg.Logger.Debug(msg, keysAndValues...)
+	}
+}
+
+// Connect connects to the server and starts handling requests from the server.
+func (c *Client) Connect(ctx context.Context) error {
+	conn, err := c.cfg.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	go c.readLoop()
+	return nil
+}
+
+// Close closes the connection without deregistration.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Location returns the location of the registration e.g. "rd/abc", or an empty string if not registered.
+func (c *Client) Location() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.location
+}
+
+// Register registers the client to the server.
+func (c *Client) Register(ctx context.Context) error {
+	req := c.newRequest(coapCodePOST, "rd")
+	req.addStringOption(coapOptionURIQuery, "ep="+c.cfg.Bootstrap.Endpoint)
+	req.addStringOption(coapOptionURIQuery, "lt="+strconv.Itoa(int(c.cfg.Lifetime.Seconds())))
+	req.addStringOption(coapOptionURIQuery, "lwm2m="+lwm2mVersion)
+	req.addStringOption(coapOptionURIQuery, "b=U")
+	req.addUintOption(coapOptionContentFormat, contentFormatLinkFormat)
+	req.Payload = []byte(fmt.Sprintf("</%d/0>,</%d/0>", objectIDServer, objectIDDevice))
+
+	resp, err := c.exchange(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Code != coapCodeCreated {
+		return errors.Errorf("registration failed: %s", codeString(resp.Code))
+	}
+
+	location := strings.Join(resp.stringOptions(coapOptionLocationPath), "/")
+	if location == "" {
+		return errors.New("registration failed: no location is returned")
+	}
+	c.mu.Lock()
+	c.location = location
+	c.mu.Unlock()
+	c.debug("registered", "endpoint", c.cfg.Bootstrap.Endpoint, "location", location)
+	return nil
+}
+
+// Update updates the registration to extend its lifetime.
+func (c *Client) Update(ctx context.Context) error {
+	location := c.Location()
+	if location == "" {
+		return errors.New("not registered")
+	}
+
+	resp, err := c.exchange(ctx, c.newRequest(coapCodePOST, location))
+	if err != nil {
+		return err
+	}
+	if resp.Code != coapCodeChanged {
+		return errors.Errorf("registration update failed: %s", codeString(resp.Code))
+	}
+	c.debug("registration updated", "location", location)
+	return nil
+}
+
+// Deregister removes the registration from the server.
+func (c *Client) Deregister(ctx context.Context) error {
+	location := c.Location()
+	if location == "" {
+		return nil
+	}
+
+	resp, err := c.exchange(ctx, c.newRequest(coapCodeDELETE, location))
+	if err != nil {
+		return err
+	}
+	if resp.Code != coapCodeDeleted {
+		return errors.Errorf("deregistration failed: %s", codeString(resp.Code))
+	}
+	c.mu.Lock()
+	c.location = ""
+	c.mu.Unlock()
+	c.debug("deregistered", "location", location)
+	return nil
+}
+
+// Run connects, registers and keeps the registration updated until ctx is done, then deregisters.
+func (c *Client) Run(ctx context.Context) error {
+	if c.conn == nil {
+		err := c.Connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	defer c.Close()
+
+	err := c.Register(ctx)
+	if err != nil {
+		return err
+	}
+
+	interval := c.cfg.Lifetime * 3 / 4
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			dctx, cancel := context.WithTimeout(context.Background(), ackTimeout*2)
+			defer cancel()
+			return c.Deregister(dctx)
+		case <-c.done:
+			return c.readerError()
+		case <-t.C:
+			err := c.Update(ctx)
+			if err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) readerError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readerErr
+}
+
+func (c *Client) newRequest(code uint8, path string) *coapMessage {
+	token := make([]byte, 4)
+	rand.Read(token)
+
+	m := &coapMessage{
+		Type:  coapTypeConfirmable,
+		Code:  code,
+		Token: token,
+	}
+	m.setPath(path)
+	return m
+}
+
+func (c *Client) newMessageID() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextMID++
+	return c.nextMID
+}
+
+func (c *Client) send(m *coapMessage) error {
+	b, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(b)
+	return err
+}
+
+// exchange sends a confirmable request and waits for the response, retransmitting it as specified in RFC 7252.
+// It gives up when no response is received within ExchangeTimeout.
+func (c *Client) exchange(ctx context.Context, req *coapMessage) (*coapMessage, error) {
+	if c.conn == nil {
+		return nil, errors.New("not connected")
+	}
+	deadline := time.NewTimer(c.cfg.ExchangeTimeout)
+	defer deadline.Stop()
+
+	req.MessageID = c.newMessageID()
+	ackCh := make(chan *coapMessage, 1)
+	respCh := make(chan *coapMessage, 1)
+	c.mu.Lock()
+	c.pending[req.MessageID] = ackCh
+	c.separate[string(req.Token)] = respCh
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.MessageID)
+		delete(c.separate, string(req.Token))
+		c.mu.Unlock()
+	}()
+
+	timeout := ackTimeout
+	for attempt := 0; ; attempt++ {
+		err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t := time.NewTimer(timeout)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-c.done:
+			t.Stop()
+			return nil, errors.Wrap(c.readerError(), "connection closed")
+		case ack := <-ackCh:
+			t.Stop()
+			if ack.Type == coapTypeReset {
+				return nil, errors.New("request rejected by the server")
+			}
+			if ack.Code != coapCodeEmpty {
+				return ack, nil
+			}
+			// empty ACK: the response will be sent separately
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.done:
+				return nil, errors.Wrap(c.readerError(), "connection closed")
+			case <-deadline.C:
+				return nil, errors.New("no separate response from the server")
+			case resp := <-respCh:
+				return resp, nil
+			}
+		case resp := <-respCh:
+			t.Stop()
+			return resp, nil
+		case <-t.C:
+			if attempt >= maxRetransmit {
+				return nil, errors.New("no response from the server")
+			}
+			timeout *= 2
+			c.debug("retransmitting", "path", req.path(), "attempt", attempt+2)
+		}
+	}
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.mu.Lock()
+			c.readerErr = err
+			c.mu.Unlock()
+			return
+		}
+
+		m, err := parseCoAPMessage(buf[:n])
+		if err != nil {
+			c.debug("ignoring malformed message", "error", err)
+			continue
+		}
+		c.dispatch(m)
+	}
+}
+
+func (c *Client) dispatch(m *coapMessage) {
+	switch {
+	case m.Type == coapTypeAcknowledgment || m.Type == coapTypeReset:
+		c.mu.Lock()
+		ch, ok := c.pending[m.MessageID]
+		c.mu.Unlock()
+		if ok {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+	case m.Code>>5 >= coapCodeClassSuccess:
+		// separate response
+		if m.Type == coapTypeConfirmable {
+			c.send(&coapMessage{Type: coapTypeAcknowledgment, Code: coapCodeEmpty, MessageID: m.MessageID})
+		}
+		c.mu.Lock()
+		ch, ok := c.separate[string(m.Token)]
+		c.mu.Unlock()
+		if ok {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+	case m.Code == coapCodeEmpty:
+		// CoAP ping
+		if m.Type == coapTypeConfirmable {
+			c.send(&coapMessage{Type: coapTypeReset, Code: coapCodeEmpty, MessageID: m.MessageID})
+		}
+	default:
+		resp := c.handleRequest(m)
+		resp.Token = m.Token
+		if m.Type == coapTypeConfirmable {
+			resp.Type = coapTypeAcknowledgment
+			resp.MessageID = m.MessageID
+		} else {
+			resp.Type = coapTypeNonConfirmable
+			resp.MessageID = c.newMessageID()
+		}
+		err := c.send(resp)
+		if err != nil {
+			c.debug("unable to send response", "error", err)
+		}
+	}
+}
+
+// handleRequest handles Read on /1/0 and /3/0 and Execute on /3/0/4.
+func (c *Client) handleRequest(req *coapMessage) *coapMessage {
+	path := req.path()
+	c.debug("received request", "code", codeString(req.Code), "path", path)
+
+	segs := strings.Split(path, "/")
+	ids := make([]int, 0, len(segs))
+	for _, s := range segs {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return &coapMessage{Code: coapCodeNotFound}
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) < 1 || len(ids) > 3 {
+		return &coapMessage{Code: coapCodeNotFound}
+	}
+
+	resources := c.objectResources(ids[0])
+	if resources == nil || (len(ids) >= 2 && ids[1] != 0) {
+		return &coapMessage{Code: coapCodeNotFound}
+	}
+
+	switch req.Code {
+	case coapCodeGET:
+		return c.read(req, ids, resources)
+	case coapCodePOST:
+		if len(ids) == 3 && ids[0] == objectIDDevice && ids[2] == resourceReboot {
+			if c.cfg.OnReboot != nil {
+				go c.cfg.OnReboot()
+			}
+			return &coapMessage{Code: coapCodeChanged}
+		}
+	}
+	return &coapMessage{Code: coapCodeMethodNotAllowed}
+}
+
+func (c *Client) read(req *coapMessage, ids []int, resources map[int]interface{}) *coapMessage {
+	accept, hasAccept := req.uintOption(coapOptionAccept)
+
+	if len(ids) == 3 {
+		v, ok := resources[ids[2]]
+		if !ok {
+			return &coapMessage{Code: coapCodeNotFound}
+		}
+		if !hasAccept || accept == contentFormatTextPlain {
+			if _, multiple := v.([]interface{}); !multiple {
+				resp := &coapMessage{Code: coapCodeContent, Payload: textValue(v)}
+				resp.addUintOption(coapOptionContentFormat, contentFormatTextPlain)
+				return resp
+			}
+		}
+		if hasAccept && accept != contentFormatTLV && accept != contentFormatTextPlain {
+			return &coapMessage{Code: coapCodeNotAcceptable}
+		}
+		resp := &coapMessage{Code: coapCodeContent, Payload: resourceTLV(ids[2], v)}
+		resp.addUintOption(coapOptionContentFormat, contentFormatTLV)
+		return resp
+	}
+
+	if hasAccept && accept != contentFormatTLV {
+		return &coapMessage{Code: coapCodeNotAcceptable}
+	}
+	var buf bytes.Buffer
+	for id := 0; id <= resourceSupportedBinding; id++ {
+		if v, ok := resources[id]; ok {
+			buf.Write(resourceTLV(id, v))
+		}
+	}
+	payload := buf.Bytes()
+	if len(ids) == 1 {
+		payload = encodeTLV(tlvTypeObjectInstance, 0, payload)
+	}
+	resp := &coapMessage{Code: coapCodeContent, Payload: payload}
+	resp.addUintOption(coapOptionContentFormat, contentFormatTLV)
+	return resp
+}
+
+// objectResources returns readable resources of the object instance 0, or nil if the object is not supported.
+// Multiple-instance resources are []interface{}. Executable resources are not included.
+func (c *Client) objectResources(objectID int) map[int]interface{} {
+	switch objectID {
+	case objectIDServer:
+		return map[int]interface{}{
+			resourceShortServerID:      serverShortServerID,
+			resourceLifetime:           int(c.cfg.Lifetime.Seconds()),
+			resourceNotificationStored: false,
+			resourceBinding:            "U",
+		}
+	case objectIDDevice:
+		d := c.cfg.Device
+		return map[int]interface{}{
+			resourceManufacturer:     d.Manufacturer,
+			resourceModelNumber:      d.ModelNumber,
+			resourceSerialNumber:     d.SerialNumber,
+			resourceFirmwareVersion:  d.FirmwareVersion,
+			resourceErrorCode:        []interface{}{0},
+			resourceCurrentTime:      time.Now(),
+			resourceSupportedBinding: "U",
+		}
+	}
+	return nil
+}
+
+func resourceTLV(id int, v interface{}) []byte {
+	if vs, ok := v.([]interface{}); ok {
+		var buf bytes.Buffer
+		for i, e := range vs {
+			buf.Write(encodeTLV(tlvTypeResourceInstance, uint16(i), tlvValue(e)))
+		}
+		return encodeTLV(tlvTypeMultipleResource, uint16(id), buf.Bytes())
+	}
+	return encodeTLV(tlvTypeResource, uint16(id), tlvValue(v))
+}
+
+func textValue(v interface{}) []byte {
+	switch t := v.(type) {
+	case time.Time:
+		return []byte(strconv.FormatInt(t.Unix(), 10))
+	case bool:
+		if t {
+			return []byte("1")
+		}
+		return []byte("0")
+	}
+	return []byte(fmt.Sprint(v))
+}
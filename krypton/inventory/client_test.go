@@ -0,0 +1,334 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v3"
+)
+
+// standInServer is a minimal LwM2M server which accepts registrations from Client.
+type standInServer struct {
+	t         *testing.T
+	conn      net.Conn
+	events    chan string
+	responses chan *coapMessage
+	nextMID   uint16
+}
+
+func (s *standInServer) serve() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			close(s.events)
+			return
+		}
+		m, err := parseCoAPMessage(buf[:n])
+		if err != nil {
+			s.t.Errorf("malformed message from the client: %v", err)
+			continue
+		}
+		if m.Type == coapTypeAcknowledgment {
+			s.responses <- m
+			continue
+		}
+
+		resp := &coapMessage{Type: coapTypeAcknowledgment, MessageID: m.MessageID, Token: m.Token}
+		event := fmt.Sprintf("%s %s", codeString(m.Code), m.path())
+		switch {
+		case m.Code == coapCodePOST && m.path() == "rd":
+			resp.Code = coapCodeCreated
+			resp.addStringOption(coapOptionLocationPath, "rd")
+			resp.addStringOption(coapOptionLocationPath, "abc")
+			event = fmt.Sprintf("register %s %s", strings.Join(m.stringOptions(coapOptionURIQuery), "&"), m.Payload)
+		case m.Code == coapCodePOST && m.path() == "rd/abc":
+			resp.Code = coapCodeChanged
+			event = "update"
+		case m.Code == coapCodeDELETE && m.path() == "rd/abc":
+			resp.Code = coapCodeDeleted
+			event = "deregister"
+		default:
+			resp.Code = coapCodeNotFound
+		}
+		s.send(resp)
+		s.events <- event
+	}
+}
+
+func (s *standInServer) send(m *coapMessage) {
+	b, err := m.marshal()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	_, err = s.conn.Write(b)
+	if err != nil {
+		s.t.Error(err)
+	}
+}
+
+// request sends a request to the client and waits for the piggybacked response.
+func (s *standInServer) request(code uint8, path string, accept int) *coapMessage {
+	s.nextMID++
+	req := &coapMessage{Type: coapTypeConfirmable, Code: code, MessageID: s.nextMID, Token: []byte{0x01, byte(s.nextMID)}}
+	req.setPath(path)
+	if accept >= 0 {
+		req.addUintOption(coapOptionAccept, uint32(accept))
+	}
+	s.send(req)
+
+	select {
+	case resp := <-s.responses:
+		if resp.MessageID != req.MessageID || !bytes.Equal(resp.Token, req.Token) {
+			s.t.Fatalf("unexpected response: %+v", resp)
+		}
+		return resp
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("no response for %s %s", codeString(code), path)
+	}
+	return nil
+}
+
+func (s *standInServer) expect(event string) {
+	s.t.Helper()
+	select {
+	case e := <-s.events:
+		if e != event {
+			s.t.Fatalf("expected %q but got %q", event, e)
+		}
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("timed out waiting for %q", event)
+	}
+}
+
+func TestClientOverDTLS(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	l, err := dtls.Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &dtls.Config{
+		PSK: func(identity []byte) ([]byte, error) {
+			if string(identity) != "test-psk-id" {
+				return nil, fmt.Errorf("unknown identity: %s", identity)
+			}
+			return psk, nil
+		},
+		CipherSuites: []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srvCh := make(chan *standInServer, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s := &standInServer{t: t, conn: conn, events: make(chan string, 10), responses: make(chan *coapMessage, 1)}
+		srvCh <- s
+		s.serve()
+	}()
+
+	rebooted := make(chan struct{})
+	c, err := NewClient(&ClientConfig{
+		Bootstrap: &BootstrapResult{
+			Endpoint:       "test-endpoint",
+			ServerURI:      "coaps://" + l.Addr().String(),
+			PSKID:          "test-psk-id",
+			ApplicationKey: base64.StdEncoding.EncodeToString(psk),
+		},
+		Lifetime: time.Second,
+		Device: DeviceInfo{
+			Manufacturer: "ACME",
+			ModelNumber:  "M1",
+		},
+		OnReboot: func() { close(rebooted) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	var s *standInServer
+	select {
+	case s = <-srvCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+	}
+	s.expect("register ep=test-endpoint&lt=1&lwm2m=1.0&b=U </1/0>,</3/0>")
+
+	resp := s.request(coapCodeGET, "3/0/0", -1)
+	if resp.Code != coapCodeContent || string(resp.Payload) != "ACME" {
+		t.Errorf("unexpected response for reading manufacturer: %s %q", codeString(resp.Code), resp.Payload)
+	}
+
+	resp = s.request(coapCodeGET, "3/0", contentFormatTLV)
+	if resp.Code != coapCodeContent {
+		t.Fatalf("unexpected response for reading device object: %s", codeString(resp.Code))
+	}
+	if cf, _ := resp.uintOption(coapOptionContentFormat); cf != contentFormatTLV {
+		t.Errorf("unexpected content format: %d", cf)
+	}
+	if !bytes.HasPrefix(resp.Payload, []byte{0xc4, 0x00, 'A', 'C', 'M', 'E', 0xc2, 0x01, 'M', '1'}) {
+		t.Errorf("unexpected TLV: %x", resp.Payload)
+	}
+
+	resp = s.request(coapCodeGET, "5/0", -1)
+	if resp.Code != coapCodeNotFound {
+		t.Errorf("expected 4.04 for unsupported object but got %s", codeString(resp.Code))
+	}
+
+	resp = s.request(coapCodePOST, "3/0/4", -1)
+	if resp.Code != coapCodeChanged {
+		t.Errorf("unexpected response for reboot: %s", codeString(resp.Code))
+	}
+	select {
+	case <-rebooted:
+	case <-time.After(5 * time.Second):
+		t.Error("OnReboot was not called")
+	}
+
+	s.expect("update")
+	if c.Location() != "rd/abc" {
+		t.Errorf("unexpected location: %s", c.Location())
+	}
+	cancel()
+	s.expect("deregister")
+	err = <-runErr
+	if err != nil {
+		t.Errorf("Run returned an error: %v", err)
+	}
+	if c.Location() != "" {
+		t.Errorf("location remains after deregistration: %s", c.Location())
+	}
+}
+
+func TestCoAPMessageRoundTrip(t *testing.T) {
+	m := &coapMessage{Type: coapTypeConfirmable, Code: coapCodePOST, MessageID: 0x1234, Token: []byte{1, 2, 3, 4}}
+	m.addUintOption(coapOptionContentFormat, contentFormatLinkFormat)
+	m.setPath("/rd")
+	m.addStringOption(coapOptionURIQuery, "ep="+strings.Repeat("x", 300))
+	m.Payload = []byte("</3/0>")
+
+	b, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:8], []byte{0x44, 0x02, 0x12, 0x34, 1, 2, 3, 4}) {
+		t.Errorf("unexpected header: %x", b[:8])
+	}
+
+	p, err := parseCoAPMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != m.Type || p.Code != m.Code || p.MessageID != m.MessageID || !bytes.Equal(p.Token, m.Token) {
+		t.Errorf("header mismatch: %+v", p)
+	}
+	if p.path() != "rd" {
+		t.Errorf("unexpected path: %s", p.path())
+	}
+	if q := p.stringOptions(coapOptionURIQuery); len(q) != 1 || q[0] != "ep="+strings.Repeat("x", 300) {
+		t.Errorf("unexpected query: %v", q)
+	}
+	if cf, _ := p.uintOption(coapOptionContentFormat); cf != contentFormatLinkFormat {
+		t.Errorf("unexpected content format: %d", cf)
+	}
+	if string(p.Payload) != "</3/0>" {
+		t.Errorf("unexpected payload: %q", p.Payload)
+	}
+}
+
+func TestEncodeTLV(t *testing.T) {
+	// examples from OMA LwM2M 1.0 section 6.4.3.1
+	manufacturer := encodeTLV(tlvTypeResource, 0, []byte("Open Mobile Alliance"))
+	if !bytes.Equal(manufacturer[:3], []byte{0xc8, 0x00, 0x14}) {
+		t.Errorf("unexpected manufacturer TLV: %x", manufacturer)
+	}
+
+	errorCodes := resourceTLV(11, []interface{}{1, 5})
+	if !bytes.Equal(errorCodes, []byte{0x86, 0x0b, 0x41, 0x00, 0x01, 0x41, 0x01, 0x05}) {
+		t.Errorf("unexpected error code TLV: %x", errorCodes)
+	}
+
+	currentTime := resourceTLV(13, time.Unix(0x5182428f, 0))
+	if !bytes.Equal(currentTime, []byte{0xc4, 0x0d, 0x51, 0x82, 0x42, 0x8f}) {
+		t.Errorf("unexpected current time TLV: %x", currentTime)
+	}
+}
+
+func TestExchangeSeparateResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		separate bool
+	}{
+		{"separate response", true},
+		{"no separate response", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer serverConn.Close()
+			c, err := NewClient(&ClientConfig{
+				Bootstrap:       &BootstrapResult{Endpoint: "test-endpoint"},
+				ExchangeTimeout: 200 * time.Millisecond,
+				Dial:            func(context.Context) (net.Conn, error) { return clientConn, nil },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Connect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			s := &standInServer{t: t, conn: serverConn}
+			go func() {
+				buf := make([]byte, maxMessageSize)
+				n, err := serverConn.Read(buf)
+				if err != nil {
+					return
+				}
+				req, err := parseCoAPMessage(buf[:n])
+				if err != nil {
+					t.Errorf("malformed message from the client: %v", err)
+					return
+				}
+				s.send(&coapMessage{Type: coapTypeAcknowledgment, Code: coapCodeEmpty, MessageID: req.MessageID})
+				if tt.separate {
+					resp := &coapMessage{Type: coapTypeNonConfirmable, Code: coapCodeCreated, MessageID: req.MessageID + 1, Token: req.Token}
+					resp.addStringOption(coapOptionLocationPath, "rd")
+					resp.addStringOption(coapOptionLocationPath, "abc")
+					s.send(resp)
+				}
+			}()
+
+			start := time.Now()
+			err = c.Register(context.Background())
+			if tt.separate {
+				if err != nil || c.Location() != "rd/abc" {
+					t.Errorf("unexpected registration: %s, %v", c.Location(), err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("the registration succeeded without a response")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("the exchange timeout is not applied: %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CoAP message types (RFC 7252)
+const (
+	coapTypeConfirmable    uint8 = 0
+	coapTypeNonConfirmable uint8 = 1
+	coapTypeAcknowledgment uint8 = 2
+	coapTypeReset          uint8 = 3
+)
+
+// CoAP codes, written as class.detail in comments
+const (
+	coapCodeEmpty  uint8 = 0x00
+	coapCodeGET    uint8 = 0x01
+	coapCodePOST   uint8 = 0x02
+	coapCodeDELETE uint8 = 0x04
+
+	coapCodeCreated          uint8 = 0x41 // 2.01
+	coapCodeDeleted          uint8 = 0x42 // 2.02
+	coapCodeChanged          uint8 = 0x44 // 2.04
+	coapCodeContent          uint8 = 0x45 // 2.05
+	coapCodeNotFound         uint8 = 0x84 // 4.04
+	coapCodeMethodNotAllowed uint8 = 0x85 // 4.05
+	coapCodeNotAcceptable    uint8 = 0x86 // 4.06
+
+	coapCodeClassSuccess uint8 = 2
+)
+
+const (
+	coapVersion        uint8 = 1
+	coapPayloadMarker  byte  = 0xff
+	coapMaxTokenLength       = 8
+)
+
+// CoAP option numbers
+const (
+	coapOptionLocationPath  uint16 = 8
+	coapOptionURIPath       uint16 = 11
+	coapOptionContentFormat uint16 = 12
+	coapOptionURIQuery      uint16 = 15
+	coapOptionAccept        uint16 = 17
+)
+
+// content formats used by LwM2M
+const (
+	contentFormatTextPlain  = 0
+	contentFormatLinkFormat = 40
+	contentFormatTLV        = 11542
+)
+
+type coapOption struct {
+	Number uint16
+	Value  []byte
+}
+
+type coapMessage struct {
+	Type      uint8
+	Code      uint8
+	MessageID uint16
+	Token     []byte
+	Options   []coapOption
+	Payload   []byte
+}
+
+func codeString(code uint8) string {
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+func (m *coapMessage) addOption(number uint16, value []byte) {
+	m.Options = append(m.Options, coapOption{Number: number, Value: value})
+}
+
+func (m *coapMessage) addStringOption(number uint16, value string) {
+	m.addOption(number, []byte(value))
+}
+
+func (m *coapMessage) addUintOption(number uint16, value uint32) {
+	m.addOption(number, encodeUint(value))
+}
+
+func (m *coapMessage) setPath(path string) {
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		if seg != "" {
+			m.addStringOption(coapOptionURIPath, seg)
+		}
+	}
+}
+
+func (m *coapMessage) stringOptions(number uint16) []string {
+	result := []string{}
+	for _, o := range m.Options {
+		if o.Number == number {
+			result = append(result, string(o.Value))
+		}
+	}
+	return result
+}
+
+func (m *coapMessage) uintOption(number uint16) (uint32, bool) {
+	for _, o := range m.Options {
+		if o.Number == number {
+			return decodeUint(o.Value), true
+		}
+	}
+	return 0, false
+}
+
+func (m *coapMessage) path() string {
+	return strings.Join(m.stringOptions(coapOptionURIPath), "/")
+}
+
+func encodeUint(v uint32) []byte {
+	switch {
+	case v == 0:
+		return []byte{}
+	case v < 1<<8:
+		return []byte{byte(v)}
+	case v < 1<<16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(v))
+		return b
+	case v < 1<<24:
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func decodeUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// marshal encodes the message in the CoAP wire format.
+func (m *coapMessage) marshal() ([]byte, error) {
+	if len(m.Token) > coapMaxTokenLength {
+		return nil, errors.Errorf("token too long: %d", len(m.Token))
+	}
+
+	b := []byte{
+		coapVersion<<6 | m.Type<<4 | uint8(len(m.Token)),
+		m.Code,
+		byte(m.MessageID >> 8),
+		byte(m.MessageID),
+	}
+	b = append(b, m.Token...)
+
+	opts := append([]coapOption{}, m.Options...)
+	sort.SliceStable(opts, func(i, j int) bool {
+		return opts[i].Number < opts[j].Number
+	})
+
+	prev := uint16(0)
+	for _, o := range opts {
+		delta := int(o.Number - prev)
+		prev = o.Number
+
+		dn, dext := optionNibble(delta)
+		ln, lext := optionNibble(len(o.Value))
+		b = append(b, dn<<4|ln)
+		b = append(b, dext...)
+		b = append(b, lext...)
+		b = append(b, o.Value...)
+	}
+
+	if len(m.Payload) > 0 {
+		b = append(b, coapPayloadMarker)
+		b = append(b, m.Payload...)
+	}
+	return b, nil
+}
+
+func optionNibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	}
+	v -= 269
+	return 14, []byte{byte(v >> 8), byte(v)}
+}
+
+// parseCoAPMessage decodes a message in the CoAP wire format.
+func parseCoAPMessage(b []byte) (*coapMessage, error) {
+	if len(b) < 4 {
+		return nil, errors.New("CoAP message too short")
+	}
+	if b[0]>>6 != coapVersion {
+		return nil, errors.Errorf("unsupported CoAP version: %d", b[0]>>6)
+	}
+
+	m := &coapMessage{
+		Type:      (b[0] >> 4) & 0x03,
+		Code:      b[1],
+		MessageID: binary.BigEndian.Uint16(b[2:4]),
+	}
+	tkl := int(b[0] & 0x0f)
+	if tkl > coapMaxTokenLength || len(b) < 4+tkl {
+		return nil, errors.New("invalid CoAP token length")
+	}
+	m.Token = append([]byte{}, b[4:4+tkl]...)
+	b = b[4+tkl:]
+
+	number := uint16(0)
+	for len(b) > 0 {
+		if b[0] == coapPayloadMarker {
+			if len(b) == 1 {
+				return nil, errors.New("CoAP payload marker followed by no payload")
+			}
+			m.Payload = append([]byte{}, b[1:]...)
+			break
+		}
+
+		dn, ln := int(b[0]>>4), int(b[0]&0x0f)
+		b = b[1:]
+		delta, rest, err := readOptionNibble(dn, b)
+		if err != nil {
+			return nil, err
+		}
+		length, rest, err := readOptionNibble(ln, rest)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) < length {
+			return nil, errors.New("CoAP option value too short")
+		}
+		number += uint16(delta)
+		m.Options = append(m.Options, coapOption{Number: number, Value: append([]byte{}, rest[:length]...)})
+		b = rest[length:]
+	}
+	return m, nil
+}
+
+func readOptionNibble(n int, b []byte) (int, []byte, error) {
+	switch n {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errors.New("CoAP option too short")
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errors.New("CoAP option too short")
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errors.New("invalid CoAP option nibble 15")
+	}
+	return n, b, nil
+}
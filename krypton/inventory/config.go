@@ -0,0 +1,195 @@
+// Package inventory provides helpers to use the result of bootstrapInventoryDevice with LwM2M clients for SORACOM Inventory.
+package inventory
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultCoAPSPort = "5684"
+
+// BootstrapResult is the result of bootstrapInventoryDevice operation.
+// Endpoint is not included in the response from the server and has to be set by the caller.
+type BootstrapResult struct {
+	Endpoint       string `json:"endpoint,omitempty"`
+	ServerURI      string `json:"serverUri"`
+	PSKID          string `json:"pskId"`
+	ApplicationKey string `json:"applicationKey"`
+}
+
+// ParseBootstrapResult parses the output of bootstrapInventoryDevice operation.
+func ParseBootstrapResult(b []byte) (*BootstrapResult, error) {
+	var r BootstrapResult
+	err := json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse the result of bootstrapInventoryDevice")
+	}
+	if r.ServerURI == "" || r.PSKID == "" || r.ApplicationKey == "" {
+		return nil, errors.New("serverUri, pskId and applicationKey must be included in the result of bootstrapInventoryDevice")
+	}
+	return &r, nil
+}
+
+// PSK returns the pre-shared key for DTLS, which is the base64-decoded applicationKey.
+func (r *BootstrapResult) PSK() ([]byte, error) {
+	psk, err := base64.StdEncoding.DecodeString(r.ApplicationKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "applicationKey must be base64 encoded")
+	}
+	return psk, nil
+}
+
+// ServerHostPort returns the host and the port of ServerURI. The port defaults to 5684 (CoAP over DTLS).
+func (r *BootstrapResult) ServerHostPort() (string, string, error) {
+	u, err := url.Parse(r.ServerURI)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid serverUri: %s", r.ServerURI)
+	}
+	if u.Hostname() == "" {
+		return "", "", errors.Errorf("invalid serverUri: %s", r.ServerURI)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultCoAPSPort
+	}
+	return u.Hostname(), port, nil
+}
+
+// ClientConfigFormat is a type of LwM2M client whose configuration is generated by GenerateClientConfig.
+type ClientConfigFormat string
+
+const (
+	// ClientConfigFormatWakaama generates command line arguments for lwm2mclient of Eclipse Wakaama.
+	ClientConfigFormatWakaama ClientConfigFormat = "wakaama"
+	// ClientConfigFormatAnjay generates command line arguments for the demo client of Anjay.
+	ClientConfigFormatAnjay ClientConfigFormat = "anjay"
+	// ClientConfigFormatLeshan generates JSON with the endpoint, server URI and PSK security information for Eclipse Leshan client.
+	ClientConfigFormatLeshan ClientConfigFormat = "leshan"
+)
+
+// PSKEncoding is an encoding of the pre-shared key in a generated configuration.
+type PSKEncoding string
+
+const (
+	PSKEncodingHex    PSKEncoding = "hex"
+	PSKEncodingBase64 PSKEncoding = "base64"
+)
+
+// ParseClientConfigFormat validates s as a ClientConfigFormat.
+func ParseClientConfigFormat(s string) (ClientConfigFormat, error) {
+	switch f := ClientConfigFormat(s); f {
+	case ClientConfigFormatWakaama, ClientConfigFormatAnjay, ClientConfigFormatLeshan:
+		return f, nil
+	}
+	return "", errors.Errorf("unknown LwM2M client config format: %s", s)
+}
+
+// ParsePSKEncoding validates s as a PSKEncoding.
+func ParsePSKEncoding(s string) (PSKEncoding, error) {
+	switch e := PSKEncoding(s); e {
+	case PSKEncodingHex, PSKEncodingBase64:
+		return e, nil
+	}
+	return "", errors.Errorf("unknown PSK encoding: %s", s)
+}
+
+// GenerateClientConfig generates a configuration for the LwM2M client to register to SORACOM Inventory.
+// Wakaama and Anjay only accept hex encoded keys, so enc must be PSKEncodingHex for them.
+func GenerateClientConfig(r *BootstrapResult, format ClientConfigFormat, enc PSKEncoding) ([]byte, error) {
+	if r.Endpoint == "" {
+		return nil, errors.New("endpoint name must be specified")
+	}
+	psk, err := r.PSK()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case ClientConfigFormatWakaama:
+		if enc != PSKEncodingHex {
+			return nil, errors.New("wakaama only accepts hex encoded PSK")
+		}
+		host, port, err := r.ServerHostPort()
+		if err != nil {
+			return nil, err
+		}
+		args := []string{
+			"-n", r.Endpoint,
+			"-h", host,
+			"-p", port,
+			"-i", r.PSKID,
+			"-s", hex.EncodeToString(psk),
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+			args = append(args, "-4")
+		}
+		return []byte(shellJoin(args) + "\n"), nil
+	case ClientConfigFormatAnjay:
+		if enc != PSKEncodingHex {
+			return nil, errors.New("anjay only accepts hex encoded PSK")
+		}
+		args := []string{
+			"--endpoint-name", r.Endpoint,
+			"--server-uri", r.ServerURI,
+			"--security-mode", "psk",
+			"--identity", hex.EncodeToString([]byte(r.PSKID)),
+			"--key", hex.EncodeToString(psk),
+		}
+		return []byte(shellJoin(args) + "\n"), nil
+	case ClientConfigFormatLeshan:
+		key := hex.EncodeToString(psk)
+		if enc == PSKEncodingBase64 {
+			key = base64.StdEncoding.EncodeToString(psk)
+		}
+		c := leshanConfig{
+			Endpoint:  r.Endpoint,
+			ServerURI: r.ServerURI,
+			Security: leshanSecurity{
+				Mode:        "psk",
+				Identity:    r.PSKID,
+				Key:         key,
+				KeyEncoding: string(enc),
+			},
+		}
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	return nil, errors.Errorf("unknown LwM2M client config format: %s", format)
+}
+
+type leshanConfig struct {
+	Endpoint  string         `json:"endpoint"`
+	ServerURI string         `json:"serverUri"`
+	Security  leshanSecurity `json:"security"`
+}
+
+type leshanSecurity struct {
+	Mode        string `json:"mode"`
+	Identity    string `json:"identity"`
+	Key         string `json:"key"`
+	KeyEncoding string `json:"keyEncoding"`
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.IndexFunc(a, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@", r))
+		}) < 0 {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = fmt.Sprintf("'%s'", strings.ReplaceAll(a, "'", `'\''`))
+	}
+	return strings.Join(quoted, " ")
+}
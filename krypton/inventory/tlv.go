@@ -0,0 +1,88 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TLV identifier types (OMA LwM2M 1.0 section 6.4.3)
+const (
+	tlvTypeObjectInstance   byte = 0x00
+	tlvTypeResourceInstance byte = 0x40
+	tlvTypeMultipleResource byte = 0x80
+	tlvTypeResource         byte = 0xc0
+)
+
+// encodeTLV encodes one TLV entry.
+func encodeTLV(typ byte, id uint16, value []byte) []byte {
+	b := []byte{typ}
+
+	var idBytes []byte
+	if id > 0xff {
+		b[0] |= 0x20
+		idBytes = []byte{byte(id >> 8), byte(id)}
+	} else {
+		idBytes = []byte{byte(id)}
+	}
+
+	var lenBytes []byte
+	l := len(value)
+	switch {
+	case l < 8:
+		b[0] |= byte(l)
+	case l < 1<<8:
+		b[0] |= 0x08
+		lenBytes = []byte{byte(l)}
+	case l < 1<<16:
+		b[0] |= 0x10
+		lenBytes = []byte{byte(l >> 8), byte(l)}
+	default:
+		b[0] |= 0x18
+		lenBytes = []byte{byte(l >> 16), byte(l >> 8), byte(l)}
+	}
+
+	b = append(b, idBytes...)
+	b = append(b, lenBytes...)
+	return append(b, value...)
+}
+
+// tlvValue encodes a resource value as specified for TLV: strings as UTF-8, integers and times as
+// the shortest big-endian two's complement, and booleans as a single byte.
+func tlvValue(v interface{}) []byte {
+	switch t := v.(type) {
+	case string:
+		return []byte(t)
+	case []byte:
+		return t
+	case bool:
+		if t {
+			return []byte{1}
+		}
+		return []byte{0}
+	case int:
+		return tlvInt(int64(t))
+	case int64:
+		return tlvInt(t)
+	case time.Time:
+		return tlvInt(t.Unix())
+	}
+	return nil
+}
+
+func tlvInt(v int64) []byte {
+	switch {
+	case v >= -1<<7 && v < 1<<7:
+		return []byte{byte(v)}
+	case v >= -1<<15 && v < 1<<15:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(v))
+		return b
+	case v >= -1<<31 && v < 1<<31:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+		return b
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
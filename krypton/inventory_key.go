@@ -0,0 +1,121 @@
+package krypton
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// InventoryApplicationKeyLength is the length in bytes of the application key for SORACOM Inventory.
+const InventoryApplicationKeyLength = 16
+
+// InventoryApplicationKeySource tells where an Inventory application key came from.
+type InventoryApplicationKeySource string
+
+const (
+	// InventoryApplicationKeySourceServer is for keys included as applicationKey in the bootstrap response.
+	InventoryApplicationKeySourceServer InventoryApplicationKeySource = "server"
+	// InventoryApplicationKeySourceDerived is for keys derived locally from nonce, timestamp and CK.
+	InventoryApplicationKeySourceDerived InventoryApplicationKeySource = "derived"
+)
+
+// InventoryApplicationKey is an application key (PSK) for SORACOM Inventory and where it came from.
+// Key is nil when applicationKey provided by the server is not encoded in standard base64.
+type InventoryApplicationKey struct {
+	Key    []byte
+	Source InventoryApplicationKeySource
+
+	// serverValue is applicationKey provided by the server, which is passed through unchanged.
+	serverValue string
+}
+
+// String returns the key as applicationKey in the output of bootstrapInventoryDevice: the value provided by the server
+// as is, or the derived key encoded in base64.
+func (k *InventoryApplicationKey) String() string {
+	if k.Source == InventoryApplicationKeySourceServer {
+		return k.serverValue
+	}
+	return base64.StdEncoding.EncodeToString(k.Key)
+}
+
+// DeriveInventoryApplicationKey derives the application key for SORACOM Inventory from the nonce and the timestamp
+// returned by the bootstrap API and the CK obtained by SIM authentication.
+// The key is the first 16 bytes of SHA-256(nonce || timestampMillis || ck), where timestampMillis is the decimal
+// string of the timestamp in milliseconds as returned by the server.
+func DeriveInventoryApplicationKey(nonce []byte, timestampMillis string, ck []byte) ([]byte, error) {
+	if len(nonce) == 0 {
+		return nil, errors.New("nonce must not be empty")
+	}
+	err := validateTimestampMillis(timestampMillis)
+	if err != nil {
+		return nil, err
+	}
+	if len(ck) == 0 {
+		return nil, errors.New("CK must not be empty")
+	}
+
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write([]byte(timestampMillis))
+	h.Write(ck)
+	return h.Sum(nil)[:InventoryApplicationKeyLength], nil
+}
+
+func validateTimestampMillis(s string) error {
+	if s == "" {
+		return errors.New("timestamp must not be empty")
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return errors.Errorf("timestamp must be milliseconds in decimal without leading zeros: %s", s)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return errors.Errorf("timestamp must be milliseconds in decimal: %s", s)
+		}
+	}
+	return nil
+}
+
+// InventoryApplicationKeyFromResponse returns the application key for SORACOM Inventory from the parsed response of
+// the bootstrap API. A string in applicationKey, even an empty one, is passed through as is, otherwise the key is
+// derived from nonce (base64) and timestamp (milliseconds in decimal) with ck.
+func InventoryApplicationKeyFromResponse(m map[string]interface{}, ck []byte) (*InventoryApplicationKey, error) {
+	if s, ok := m["applicationKey"].(string); ok {
+		// the value is not validated so that the key is passed through even if the server changes its encoding
+		key, _ := base64.StdEncoding.DecodeString(s)
+		return &InventoryApplicationKey{Key: key, Source: InventoryApplicationKeySourceServer, serverValue: s}, nil
+	}
+
+	nonceStr, err := stringField(m, "nonce")
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(nonceStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "nonce must be base64 encoded")
+	}
+
+	timestamp, err := stringField(m, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveInventoryApplicationKey(nonce, timestamp, ck)
+	if err != nil {
+		return nil, err
+	}
+	return &InventoryApplicationKey{Key: key, Source: InventoryApplicationKeySourceDerived}, nil
+}
+
+func stringField(m map[string]interface{}, name string) (string, error) {
+	raw, found := m[name]
+	if !found {
+		return "", errors.Errorf("%s is not found in the response from the server", name)
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", errors.Errorf("%s must be a string", name)
+	}
+	return s, nil
+}
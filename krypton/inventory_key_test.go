@@ -0,0 +1,169 @@
+package krypton
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeriveInventoryApplicationKey(t *testing.T) {
+	vectors := []struct {
+		nonce     string
+		timestamp string
+		ck        string
+		expected  string
+	}{
+		{"AAECAwQFBgcICQoLDA0ODw==", "1600000000000", "101112131415161718191a1b1c1d1e1f", "orcUjHENbrc/GWSkOV4u+w=="},
+		{"bm9uY2UtdmFsdWU=", "1700000000123", "00000000000000000000000000000000", "54VOGU15z/hbihJAfXTmCg=="},
+	}
+	for _, v := range vectors {
+		nonce, _ := base64.StdEncoding.DecodeString(v.nonce)
+		key, err := DeriveInventoryApplicationKey(nonce, v.timestamp, mustDecodeHex(t, v.ck))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(key) != InventoryApplicationKeyLength {
+			t.Errorf("unexpected key length: %d", len(key))
+		}
+		if base64.StdEncoding.EncodeToString(key) != v.expected {
+			t.Errorf("expected %s but got %s", v.expected, base64.StdEncoding.EncodeToString(key))
+		}
+	}
+}
+
+func TestDeriveInventoryApplicationKeyValidation(t *testing.T) {
+	ck := mustDecodeHex(t, "101112131415161718191a1b1c1d1e1f")
+	tests := []struct {
+		name      string
+		nonce     []byte
+		timestamp string
+		ck        []byte
+	}{
+		{"empty nonce", nil, "1600000000000", ck},
+		{"empty timestamp", []byte("nonce"), "", ck},
+		{"timestamp in seconds with fraction", []byte("nonce"), "1600000000.000", ck},
+		{"RFC 3339 timestamp", []byte("nonce"), "2020-09-13T12:26:40Z", ck},
+		{"negative timestamp", []byte("nonce"), "-1", ck},
+		{"leading zero", []byte("nonce"), "01600000000000", ck},
+		{"empty CK", []byte("nonce"), "1600000000000", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DeriveInventoryApplicationKey(tt.nonce, tt.timestamp, tt.ck)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestInventoryApplicationKeyFromResponse(t *testing.T) {
+	ck := mustDecodeHex(t, "101112131415161718191a1b1c1d1e1f")
+
+	tests := []struct {
+		name     string
+		resp     map[string]interface{}
+		expected string
+		source   InventoryApplicationKeySource
+		wantErr  bool
+	}{
+		{
+			name:     "server-provided",
+			resp:     map[string]interface{}{"applicationKey": "c2VydmVyLWtleQ==", "nonce": "AAECAwQFBgcICQoLDA0ODw==", "timestamp": "1600000000000"},
+			expected: "c2VydmVyLWtleQ==",
+			source:   InventoryApplicationKeySourceServer,
+		},
+		{
+			name:     "derived",
+			resp:     map[string]interface{}{"nonce": "AAECAwQFBgcICQoLDA0ODw==", "timestamp": "1600000000000"},
+			expected: "orcUjHENbrc/GWSkOV4u+w==",
+			source:   InventoryApplicationKeySourceDerived,
+		},
+		{
+			name:     "server-provided not base64",
+			resp:     map[string]interface{}{"applicationKey": "not base64!"},
+			expected: "not base64!",
+			source:   InventoryApplicationKeySourceServer,
+		},
+		{
+			name:     "empty applicationKey",
+			resp:     map[string]interface{}{"applicationKey": "", "nonce": "AAECAwQFBgcICQoLDA0ODw==", "timestamp": "1600000000000"},
+			expected: "",
+			source:   InventoryApplicationKeySourceServer,
+		},
+		{
+			name:     "applicationKey not a string",
+			resp:     map[string]interface{}{"applicationKey": 1, "nonce": "AAECAwQFBgcICQoLDA0ODw==", "timestamp": "1600000000000"},
+			expected: "orcUjHENbrc/GWSkOV4u+w==",
+			source:   InventoryApplicationKeySourceDerived,
+		},
+		{name: "no nonce", resp: map[string]interface{}{"timestamp": "1600000000000"}, wantErr: true},
+		{name: "nonce not base64", resp: map[string]interface{}{"nonce": "%%%", "timestamp": "1600000000000"}, wantErr: true},
+		{name: "no timestamp", resp: map[string]interface{}{"nonce": "AAECAwQFBgcICQoLDA0ODw=="}, wantErr: true},
+		{name: "timestamp not a string", resp: map[string]interface{}{"nonce": "AAECAwQFBgcICQoLDA0ODw==", "timestamp": 1600000000000.0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := InventoryApplicationKeyFromResponse(tt.resp, ck)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error but got %+v", k)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.String() != tt.expected || k.Source != tt.source {
+				t.Errorf("expected %s (%s) but got %s (%s)", tt.expected, tt.source, k.String(), k.Source)
+			}
+		})
+	}
+}
+
+func FuzzInventoryApplicationKeyFromResponse(f *testing.F) {
+	f.Add(`{"nonce":"AAECAwQFBgcICQoLDA0ODw==","timestamp":"1600000000000"}`, []byte("ck"))
+	f.Add(`{"applicationKey":"c2VydmVyLWtleQ=="}`, []byte("ck"))
+	f.Add(`{"nonce":"","timestamp":"0"}`, []byte{})
+	f.Add(`{"nonce":1,"timestamp":null}`, []byte("ck"))
+
+	f.Fuzz(func(t *testing.T, resp string, ck []byte) {
+		var m map[string]interface{}
+		if json.Unmarshal([]byte(resp), &m) != nil {
+			return
+		}
+		k, err := InventoryApplicationKeyFromResponse(m, ck)
+		if err != nil {
+			return
+		}
+		switch k.Source {
+		case InventoryApplicationKeySourceServer:
+			key, _ := base64.StdEncoding.DecodeString(m["applicationKey"].(string))
+			if !bytes.Equal(key, k.Key) || k.String() != m["applicationKey"] {
+				t.Errorf("server-provided key is modified: %x %s", k.Key, k.String())
+			}
+		case InventoryApplicationKeySourceDerived:
+			if len(k.Key) != InventoryApplicationKeyLength {
+				t.Errorf("unexpected key length: %d", len(k.Key))
+			}
+			nonce, _ := base64.StdEncoding.DecodeString(m["nonce"].(string))
+			key, err := DeriveInventoryApplicationKey(nonce, m["timestamp"].(string), ck)
+			if err != nil || !bytes.Equal(key, k.Key) {
+				t.Errorf("derivation is not deterministic: %x %x %v", key, k.Key, err)
+			}
+		default:
+			t.Errorf("unknown source: %s", k.Source)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package krypton
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+// DefaultKeyCacheTTL is how long a cached authentication result is used when Config.KeyCacheTTL is not specified.
+const DefaultKeyCacheTTL = time.Hour
+
+// ErrKeyCacheEntryNotFound is returned when no entry is cached for the key.
+var ErrKeyCacheEntryNotFound = errors.New("key cache entry not found")
+
+// KeyCacheEntry is a cached result of SIM authentication.
+type KeyCacheEntry struct {
+	// Key identifies the SIM, e.g. "imsi:440100000000000".
+	Key       string    `json:"key"`
+	IMSI      string    `json:"imsi,omitempty"`
+	KeyID     string    `json:"keyId"`
+	CK        []byte    `json:"ck"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired returns whether the entry must not be used at t.
+func (e *KeyCacheEntry) Expired(t time.Time) bool {
+	return !t.Before(e.ExpiresAt)
+}
+
+// IMSIReader reads the IMSI of the SIM without authentication. It is implemented by Authenticators such as the
+// simulated SIM, and NewIMSIReader returns one for *endorse.Client.
+// The IMSI identifies entries in the key cache so that a different SIM does not use a cached key. The key cache is
+// not used when the IMSI cannot be read.
+type IMSIReader interface {
+	ReadIMSI() (string, error)
+}
+
+// KeyCache stores results of SIM authentication.
+type KeyCache interface {
+	// List returns all the entries including expired ones, sorted by Key.
+	List() ([]*KeyCacheEntry, error)
+	// Get returns the entry for key, or ErrKeyCacheEntryNotFound.
+	Get(key string) (*KeyCacheEntry, error)
+	// Put adds or replaces the entry for e.Key.
+	Put(e *KeyCacheEntry) error
+	// Delete removes the entry for key, or returns ErrKeyCacheEntryNotFound.
+	Delete(key string) error
+	// Clear removes all the entries.
+	Clear() error
+}
+
+// keyCacheStorage stores all the entries of a key cache as a single blob.
+type keyCacheStorage interface {
+	// read returns nil if nothing is stored
+	read() ([]byte, error)
+	write(b []byte) error
+	remove() error
+}
+
+// keyCacheLocker is implemented by storages shared with other processes. The storage is locked while the entries
+// are loaded and saved so that concurrent updates are not lost.
+type keyCacheLocker interface {
+	lock() (unlock func(), err error)
+}
+
+// blobKeyCache implements KeyCache on top of a keyCacheStorage.
+type blobKeyCache struct {
+	storage keyCacheStorage
+	// seal and open encrypt and decrypt the blob if set
+	seal func(b []byte) ([]byte, error)
+	open func(b []byte) ([]byte, error)
+
+	mu sync.Mutex
+}
+
+type keyCacheBlob struct {
+	Entries []*KeyCacheEntry `json:"entries"`
+}
+
+func (c *blobKeyCache) load() (map[string]*KeyCacheEntry, error) {
+	entries := map[string]*KeyCacheEntry{}
+	b, err := c.storage.read()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return entries, nil
+	}
+	if c.open != nil {
+		b, err = c.open(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blob keyCacheBlob
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse the key cache")
+	}
+	for _, e := range blob.Entries {
+		entries[e.Key] = e
+	}
+	return entries, nil
+}
+
+func (c *blobKeyCache) save(entries map[string]*KeyCacheEntry) error {
+	b, err := json.MarshalIndent(keyCacheBlob{Entries: sortedEntries(entries)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c.seal != nil {
+		b, err = c.seal(b)
+		if err != nil {
+			return err
+		}
+	}
+	return c.storage.write(b)
+}
+
+// lock locks c in this process, and the storage if it is shared with other processes.
+func (c *blobKeyCache) lock() (func(), error) {
+	c.mu.Lock()
+	l, ok := c.storage.(keyCacheLocker)
+	if !ok {
+		return c.mu.Unlock, nil
+	}
+	unlock, err := l.lock()
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		c.mu.Unlock()
+	}, nil
+}
+
+func sortedEntries(entries map[string]*KeyCacheEntry) []*KeyCacheEntry {
+	result := make([]*KeyCacheEntry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func (c *blobKeyCache) List() ([]*KeyCacheEntry, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return sortedEntries(entries), nil
+}
+
+func (c *blobKeyCache) Get(key string) (*KeyCacheEntry, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := entries[key]
+	if !ok {
+		return nil, ErrKeyCacheEntryNotFound
+	}
+	return e, nil
+}
+
+func (c *blobKeyCache) Put(e *KeyCacheEntry) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	entries[e.Key] = e
+	return c.save(entries)
+}
+
+func (c *blobKeyCache) Delete(key string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[key]; !ok {
+		return ErrKeyCacheEntryNotFound
+	}
+	delete(entries, key)
+	return c.save(entries)
+}
+
+func (c *blobKeyCache) Clear() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return c.storage.remove()
+}
+
+// MemoryKeyCache stores entries in memory, e.g. for long-running processes on read-only root filesystems.
+type MemoryKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]*KeyCacheEntry
+}
+
+// NewMemoryKeyCache returns an empty MemoryKeyCache.
+func NewMemoryKeyCache() *MemoryKeyCache {
+	return &MemoryKeyCache{entries: map[string]*KeyCacheEntry{}}
+}
+
+// List implements KeyCache.
+func (c *MemoryKeyCache) List() ([]*KeyCacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedEntries(c.entries), nil
+}
+
+// Get implements KeyCache.
+func (c *MemoryKeyCache) Get(key string) (*KeyCacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, ErrKeyCacheEntryNotFound
+	}
+	copied := *e
+	return &copied, nil
+}
+
+// Put implements KeyCache.
+func (c *MemoryKeyCache) Put(e *KeyCacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	copied := *e
+	c.entries[e.Key] = &copied
+	return nil
+}
+
+// Delete implements KeyCache.
+func (c *MemoryKeyCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		return ErrKeyCacheEntryNotFound
+	}
+	delete(c.entries, key)
+	return nil
+}
+
+// Clear implements KeyCache.
+func (c *MemoryKeyCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*KeyCacheEntry{}
+	return nil
+}
+
+// keyCacheKey returns the key of the cache entry for the SIM and its IMSI. ok is false when the IMSI cannot be read,
+// and then the key cache is not used since another SIM could be inserted in the same interface.
+func (c *Client) keyCacheKey() (key string, imsi string, ok bool) {
+	r, ok := c.cfg.authenticator().(IMSIReader)
+	if !ok {
+		r = c.cfg.IMSIReader
+	}
+	if r == nil {
+		c.debug("the key cache is not used since the IMSI cannot be read")
+		return "", "", false
+	}
+	imsi, err := r.ReadIMSI()
+	if err != nil || imsi == "" {
+		c.debug("unable to read IMSI for the key cache", "error", err)
+		return "", "", false
+	}
+	return "imsi:" + imsi, imsi, true
+}
+
+// cachedAuthentication returns the cached authentication result, or nil if it is not available.
+func (c *Client) cachedAuthentication() *endorse.AuthenticationResult {
+	if c.cfg.KeyCache == nil {
+		return nil
+	}
+	key, _, ok := c.keyCacheKey()
+	if !ok {
+		return nil
+	}
+	e, err := c.cfg.KeyCache.Get(key)
+	if err != nil {
+		if err != ErrKeyCacheEntryNotFound {
+			c.debug("unable to read the key cache", "error", err)
+		}
+		c.metrics.ObserveKeyCache(c.operation, false)
+		return nil
+	}
+	if e.Expired(time.Now()) {
+		c.debug("cached key is expired", "key", key, "keyId", e.KeyID, "expiresAt", e.ExpiresAt)
+		c.metrics.ObserveKeyCache(c.operation, false)
+		return nil
+	}
+	c.debug("using cached key", "key", key, "keyId", e.KeyID, "expiresAt", e.ExpiresAt)
+	c.metrics.ObserveKeyCache(c.operation, true)
+	return &endorse.AuthenticationResult{KeyID: e.KeyID, CK: e.CK}
+}
+
+func (c *Client) storeAuthentication(ar *endorse.AuthenticationResult) {
+	if c.cfg.KeyCache == nil {
+		return
+	}
+	key, imsi, ok := c.keyCacheKey()
+	if !ok {
+		return
+	}
+	ttl := c.cfg.KeyCacheTTL
+	if ttl == 0 {
+		ttl = DefaultKeyCacheTTL
+	}
+	now := time.Now()
+	err := c.cfg.KeyCache.Put(&KeyCacheEntry{
+		Key:       key,
+		IMSI:      imsi,
+		KeyID:     ar.KeyID,
+		CK:        ar.CK,
+		CreatedAt: now.UTC(),
+		ExpiresAt: now.Add(ttl).UTC(),
+	})
+	if err != nil {
+		c.debug("unable to store the key to the key cache", "error", err)
+	}
+}
+
+// evictAuthentication deletes the cache entry for the SIM, e.g. when the provisioning API rejected the key.
+func (c *Client) evictAuthentication() {
+	if c.cfg.KeyCache == nil {
+		return
+	}
+	key, _, ok := c.keyCacheKey()
+	if !ok {
+		return
+	}
+	err := c.cfg.KeyCache.Delete(key)
+	if err != nil && err != ErrKeyCacheEntryNotFound {
+		c.debug("unable to delete the key from the key cache", "error", err)
+		return
+	}
+	c.debug("deleted the key from the key cache", "key", key)
+}
@@ -0,0 +1,147 @@
+package krypton
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// KeyCacheEncryptionKeyLength is the length of the key to encrypt FileKeyCache with AES-256-GCM.
+const KeyCacheEncryptionKeyLength = 32
+
+// encryptedKeyCacheMagic precedes the nonce and the ciphertext in an encrypted key cache file.
+var encryptedKeyCacheMagic = []byte("KRYPTON-KEYCACHE-AES256GCM\n")
+
+// FileKeyCache stores entries in a JSON file readable only by the owner, optionally encrypted. The file is locked
+// while it is updated so that it can be shared by concurrent processes.
+type FileKeyCache struct {
+	*blobKeyCache
+}
+
+// NewFileKeyCache returns a FileKeyCache which stores entries in path in plaintext, including CK. The file is created
+// when needed. Use NewEncryptedFileKeyCache unless the file is protected by other means.
+func NewFileKeyCache(path string) *FileKeyCache {
+	c := &FileKeyCache{blobKeyCache: &blobKeyCache{storage: fileStorage(path)}}
+	c.open = func(b []byte) ([]byte, error) {
+		if bytes.HasPrefix(b, encryptedKeyCacheMagic) {
+			return nil, errors.Errorf("key cache %s is encrypted; the encryption key is required", path)
+		}
+		return b, nil
+	}
+	return c
+}
+
+// NewEncryptedFileKeyCache returns a FileKeyCache which encrypts the file with AES-256-GCM using key.
+// A file which is not encrypted yet is still read, and encrypted when it is written next time.
+func NewEncryptedFileKeyCache(path string, key []byte) (*FileKeyCache, error) {
+	if len(key) != KeyCacheEncryptionKeyLength {
+		return nil, errors.Errorf("encryption key of the key cache must be %d bytes", KeyCacheEncryptionKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewFileKeyCache(path)
+	c.seal = func(b []byte) ([]byte, error) {
+		nonce := make([]byte, aead.NonceSize())
+		_, err := io.ReadFull(rand.Reader, nonce)
+		if err != nil {
+			return nil, err
+		}
+		sealed := append(append([]byte{}, encryptedKeyCacheMagic...), nonce...)
+		return aead.Seal(sealed, nonce, b, encryptedKeyCacheMagic), nil
+	}
+	c.open = func(b []byte) ([]byte, error) {
+		if !bytes.HasPrefix(b, encryptedKeyCacheMagic) {
+			return b, nil
+		}
+		b = b[len(encryptedKeyCacheMagic):]
+		if len(b) < aead.NonceSize() {
+			return nil, errors.New("encrypted key cache is truncated")
+		}
+		plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], encryptedKeyCacheMagic)
+		if err != nil {
+			return nil, errors.New("unable to decrypt the key cache; the encryption key may be wrong")
+		}
+		return plain, nil
+	}
+	return c, nil
+}
+
+// fileStorage is the path of the file.
+type fileStorage string
+
+func (s fileStorage) read() ([]byte, error) {
+	b, err := os.ReadFile(string(s))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the key cache")
+	}
+	return b, nil
+}
+
+func (s fileStorage) write(b []byte) error {
+	path := string(s)
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "unable to create the directory for the key cache")
+	}
+	// CreateTemp creates the file with 0600
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "unable to write the key cache")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to write the key cache")
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lock locks the file path.lock exclusively. The key cache file itself is replaced on writes, so it cannot be locked.
+func (s fileStorage) lock() (func(), error) {
+	path := string(s) + ".lock"
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the directory for the key cache")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to lock the key cache")
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "unable to lock the key cache")
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+func (s fileStorage) remove() error {
+	err := os.Remove(string(s))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to clear the key cache")
+	}
+	return nil
+}
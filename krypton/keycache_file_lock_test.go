@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package krypton
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileKeyCacheConcurrentProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keycache.json")
+	key := make([]byte, KeyCacheEncryptionKeyLength)
+
+	// each FileKeyCache has its own mutex like the ones in different processes
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c, err := NewEncryptedFileKeyCache(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := c.Put(&KeyCacheEntry{Key: fmt.Sprintf("imsi:%d-%d", i, j), CK: testCK})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := NewFileKeyCache(path).List(); err == nil {
+		t.Error("the encrypted file is read without the key")
+	}
+	c, _ := NewEncryptedFileKeyCache(path, key)
+	entries, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 40 {
+		t.Errorf("updates are lost: %d entries", len(entries))
+	}
+}
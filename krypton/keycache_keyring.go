@@ -0,0 +1,30 @@
+package krypton
+
+// KeyringKeyCacheDescription is the description of the user key which holds the entries in the kernel keyring.
+const KeyringKeyCacheDescription = "krypton:keycache"
+
+// Keyrings which KeyringKeyCache can store entries in.
+const (
+	// KeyringUser is shared by all the processes of the user, and is kept until the user logs out.
+	KeyringUser = "user"
+	// KeyringSession is shared by the processes in the login session.
+	KeyringSession = "session"
+	// KeyringProcess is only for the current process.
+	KeyringProcess = "process"
+)
+
+// KeyringKeyCache stores entries in a user key of the Linux kernel keyring. Entries are kept in the kernel memory
+// only, so it works on devices with a read-only root filesystem, and each user of a gateway has their own keyring.
+type KeyringKeyCache struct {
+	*blobKeyCache
+}
+
+// NewKeyringKeyCache returns a KeyringKeyCache which stores entries in the keyring, one of KeyringUser,
+// KeyringSession or KeyringProcess. It returns an error on platforms other than Linux.
+func NewKeyringKeyCache(keyring string) (*KeyringKeyCache, error) {
+	s, err := newKeyringStorage(keyring, KeyringKeyCacheDescription)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyringKeyCache{blobKeyCache: &blobKeyCache{storage: s}}, nil
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package krypton
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// maxKeyringPayload is the maximum size of the payload of a user key.
+const maxKeyringPayload = 32767
+
+var keyringIDs = map[string]int{
+	KeyringUser:    unix.KEY_SPEC_USER_KEYRING,
+	KeyringSession: unix.KEY_SPEC_SESSION_KEYRING,
+	KeyringProcess: unix.KEY_SPEC_PROCESS_KEYRING,
+}
+
+type keyringStorage struct {
+	ringID      int
+	description string
+}
+
+func newKeyringStorage(keyring, description string) (keyCacheStorage, error) {
+	id, ok := keyringIDs[keyring]
+	if !ok {
+		return nil, errors.Errorf("unknown keyring: %s", keyring)
+	}
+	return &keyringStorage{ringID: id, description: description}, nil
+}
+
+// search returns the ID of the key, or 0 if it does not exist.
+func (s *keyringStorage) search() (int, error) {
+	id, err := unix.KeyctlSearch(s.ringID, "user", s.description, 0)
+	switch err {
+	case nil:
+		return id, nil
+	case unix.ENOKEY, unix.EKEYEXPIRED, unix.EKEYREVOKED:
+		return 0, nil
+	default:
+		return 0, errors.Wrap(err, "unable to search the keyring for the key cache")
+	}
+}
+
+func (s *keyringStorage) read() ([]byte, error) {
+	id, err := s.search()
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the key cache from the keyring")
+	}
+	b := make([]byte, size)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, b, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the key cache from the keyring")
+	}
+	return b[:n], nil
+}
+
+func (s *keyringStorage) write(b []byte) error {
+	if len(b) > maxKeyringPayload {
+		return errors.Errorf("key cache is too large for the keyring: %d bytes", len(b))
+	}
+	// add_key(2) replaces the payload of the existing key
+	_, err := unix.AddKey("user", s.description, b, s.ringID)
+	if err != nil {
+		return errors.Wrap(err, "unable to write the key cache to the keyring")
+	}
+	return nil
+}
+
+func (s *keyringStorage) remove() error {
+	id, err := s.search()
+	if err != nil || id == 0 {
+		return err
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, id, s.ringID, 0, 0)
+	if err != nil {
+		return errors.Wrap(err, "unable to clear the key cache in the keyring")
+	}
+	return nil
+}
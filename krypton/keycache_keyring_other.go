@@ -0,0 +1,9 @@
+//go:build !linux
+
+package krypton
+
+import "github.com/pkg/errors"
+
+func newKeyringStorage(keyring, description string) (keyCacheStorage, error) {
+	return nil, errors.New("the kernel keyring is supported only on Linux")
+}
@@ -0,0 +1,475 @@
+package krypton
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+// countingAuthenticator counts SIM authentications, and reads imsi if it is set.
+type countingAuthenticator struct {
+	fakeAuthenticator
+	authentications int
+	imsi            string
+}
+
+func (a *countingAuthenticator) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	a.authentications++
+	return a.fakeAuthenticator.DoAuthentication()
+}
+
+func (a *countingAuthenticator) ReadIMSI() (string, error) {
+	return a.imsi, nil
+}
+
+// testKeyCache checks the behavior common to all the KeyCache implementations.
+func testKeyCache(t *testing.T, c KeyCache) {
+	t.Helper()
+	err := c.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected entries of empty cache: %v, %v", entries, err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, key := range []string{"imsi:2", "imsi:1"} {
+		err = c.Put(&KeyCacheEntry{Key: key, KeyID: "key-" + key, CK: testCK, CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err = c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "imsi:1" || entries[1].Key != "imsi:2" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	e, err := c.Get("imsi:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.KeyID != "key-imsi:1" || !bytes.Equal(e.CK, testCK) || !e.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if e.Expired(now) || !e.Expired(now.Add(time.Hour)) {
+		t.Error("unexpected expiry")
+	}
+
+	err = c.Delete("imsi:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get("imsi:1"); err != ErrKeyCacheEntryNotFound {
+		t.Errorf("expected ErrKeyCacheEntryNotFound, got %v", err)
+	}
+	if err = c.Delete("imsi:1"); err != ErrKeyCacheEntryNotFound {
+		t.Errorf("expected ErrKeyCacheEntryNotFound, got %v", err)
+	}
+
+	err = c.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = c.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected entries after clear: %v, %v", entries, err)
+	}
+	if err = c.Clear(); err != nil {
+		t.Errorf("clearing an empty cache failed: %v", err)
+	}
+}
+
+func TestMemoryKeyCache(t *testing.T) {
+	testKeyCache(t, NewMemoryKeyCache())
+}
+
+func TestFileKeyCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "keycache.json")
+	c := NewFileKeyCache(path)
+	testKeyCache(t, c)
+
+	err := c.Put(&KeyCacheEntry{Key: "imsi:1", CK: testCK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %v", fi.Mode())
+	}
+}
+
+func TestEncryptedFileKeyCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keycache.json")
+	key := bytes.Repeat([]byte{1}, KeyCacheEncryptionKeyLength)
+	c, err := NewEncryptedFileKeyCache(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeyCache(t, c)
+
+	err = c.Put(&KeyCacheEntry{Key: "imsi:1", KeyID: "key-id", CK: testCK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("key-id")) || bytes.Contains(b, []byte("imsi:1")) {
+		t.Error("key cache is not encrypted")
+	}
+
+	if _, err = NewFileKeyCache(path).List(); err == nil {
+		t.Error("encrypted key cache was read without the key")
+	}
+	wrong, err := NewEncryptedFileKeyCache(path, bytes.Repeat([]byte{2}, KeyCacheEncryptionKeyLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wrong.List(); err == nil {
+		t.Error("encrypted key cache was read with a wrong key")
+	}
+
+	// a file which is not encrypted is read and encrypted on the next write
+	plainPath := filepath.Join(t.TempDir(), "keycache.json")
+	err = NewFileKeyCache(plainPath).Put(&KeyCacheEntry{Key: "imsi:1", KeyID: "key-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewEncryptedFileKeyCache(plainPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get("imsi:1"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Put(&KeyCacheEntry{Key: "imsi:2", KeyID: "key-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, encryptedKeyCacheMagic) {
+		t.Error("key cache is not encrypted on write")
+	}
+
+	if _, err = NewEncryptedFileKeyCache(path, []byte("short")); err == nil {
+		t.Error("short key was accepted")
+	}
+}
+
+func TestKeyringKeyCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := NewKeyringKeyCache(KeyringProcess); err == nil {
+			t.Error("keyring is available on " + runtime.GOOS)
+		}
+		return
+	}
+	if _, err := NewKeyringKeyCache("unknown"); err == nil {
+		t.Error("unknown keyring was accepted")
+	}
+
+	// the process keyring is discarded when the test exits
+	c, err := NewKeyringKeyCache(KeyringProcess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Put(&KeyCacheEntry{Key: "probe"}); err != nil {
+		t.Skipf("kernel keyring is not available: %v", err)
+	}
+	testKeyCache(t, c)
+}
+
+func TestClientUsesKeyCache(t *testing.T) {
+	s := newStandInAPI(t, 200, `{"imsi":"440100000000000"}`)
+	a := &countingAuthenticator{imsi: "440100000000000"}
+	c := newTestClient(t, s.URL, "", a)
+	cache := NewFileKeyCache(filepath.Join(t.TempDir(), "keycache.json"))
+	c.cfg.KeyCache = cache
+
+	for i := 0; i < 2; i++ {
+		_, err := c.PerformOperationWithResult("getSubscriberMetadata")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.authentications != 1 {
+		t.Errorf("expected 1 authentication, got %d", a.authentications)
+	}
+	if !bytes.Equal(a.ck, testCK) {
+		t.Errorf("cached CK was not used: %x", a.ck)
+	}
+
+	e, err := cache.Get("imsi:440100000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.IMSI != "440100000000000" || e.KeyID != "test-key-id" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if d := e.ExpiresAt.Sub(e.CreatedAt); d != DefaultKeyCacheTTL {
+		t.Errorf("unexpected TTL: %v", d)
+	}
+
+	// expired entries are not used
+	e.ExpiresAt = time.Now().Add(-time.Second)
+	err = cache.Put(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.PerformOperationWithResult("getSubscriberMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.authentications != 2 {
+		t.Errorf("expected 2 authentications, got %d", a.authentications)
+	}
+
+	// Authenticate always uses the SIM
+	_, err = c.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.authentications != 3 {
+		t.Errorf("expected 3 authentications, got %d", a.authentications)
+	}
+}
+
+func TestClientDoesNotCacheWithoutIMSI(t *testing.T) {
+	s := newStandInAPI(t, 200, `{"imsi":"440100000000000"}`)
+	a := &countingAuthenticator{}
+	c := newTestClient(t, s.URL, "", a)
+	c.cfg.KeyCache = NewMemoryKeyCache()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.PerformOperationWithResult("getSubscriberMetadata")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.authentications != 2 {
+		t.Errorf("expected 2 authentications, got %d", a.authentications)
+	}
+	if entries, _ := c.cfg.KeyCache.List(); len(entries) != 0 {
+		t.Errorf("the key is cached without IMSI: %v", entries)
+	}
+}
+
+// imsiReaderFunc reads the IMSI with the function.
+type imsiReaderFunc func() (string, error)
+
+func (f imsiReaderFunc) ReadIMSI() (string, error) {
+	return f()
+}
+
+func TestClientUsesKeyCacheWithIMSIReader(t *testing.T) {
+	s := newStandInAPI(t, 200, `{"imsi":"440100000000000"}`)
+	a := &countingAuthenticator{}
+	// like *endorse.Client, the authenticator does not implement IMSIReader
+	c := newTestClient(t, s.URL, "", struct{ Authenticator }{a})
+	c.cfg.KeyCache = NewMemoryKeyCache()
+	imsi := "440100000000000"
+	c.cfg.IMSIReader = imsiReaderFunc(func() (string, error) { return imsi, nil })
+
+	for i := 0; i < 2; i++ {
+		_, err := c.PerformOperationWithResult("getSubscriberMetadata")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.authentications != 1 {
+		t.Errorf("expected 1 authentication, got %d", a.authentications)
+	}
+
+	// another SIM is inserted
+	imsi = "440100000000001"
+	_, err := c.PerformOperationWithResult("getSubscriberMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.authentications != 2 {
+		t.Errorf("expected 2 authentications, got %d", a.authentications)
+	}
+}
+
+func TestClientEvictsRejectedKey(t *testing.T) {
+	rejected := map[string]bool{"stale-key-id": true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			KeyID string `json:"keyId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if rejected[body.KeyID] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	a := &countingAuthenticator{imsi: "440100000000000"}
+	c := newTestClient(t, s.URL, "", a)
+	cache := NewMemoryKeyCache()
+	c.cfg.KeyCache = cache
+	stale := &KeyCacheEntry{Key: "imsi:440100000000000", KeyID: "stale-key-id", CK: testCK, ExpiresAt: time.Now().Add(time.Hour)}
+	err := cache.Put(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached key is rejected, and the request is retried with SIM authentication
+	_, err = c.PerformOperationWithResult("getSubscriberMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.authentications != 1 || a.requests != 2 {
+		t.Errorf("unexpected authentications and requests: %d, %d", a.authentications, a.requests)
+	}
+	e, err := cache.Get("imsi:440100000000000")
+	if err != nil || e.KeyID != "test-key-id" {
+		t.Errorf("the rejected key is not replaced: %+v, %v", e, err)
+	}
+
+	// a key from SIM authentication is not retried, but is deleted
+	rejected["test-key-id"] = true
+	err = cache.Put(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.PerformOperationWithResult("getSubscriberMetadata")
+	if Category(err) != ErrorCategoryAuthentication {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if a.authentications != 2 || a.requests != 4 {
+		t.Errorf("unexpected authentications and requests: %d, %d", a.authentications, a.requests)
+	}
+	if _, err = cache.Get("imsi:440100000000000"); err != ErrKeyCacheEntryNotFound {
+		t.Errorf("the rejected key is not deleted: %v", err)
+	}
+}
+
+func TestClientPerformOperationWithAuthentication(t *testing.T) {
+	rejected := map[string]bool{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			KeyID string `json:"keyId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if rejected[body.KeyID] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	// without the key cache nor IMSIReader, like *endorse.Client
+	a := &countingAuthenticator{}
+	c := newTestClient(t, s.URL, "", struct{ Authenticator }{a})
+	ar, err := c.AuthenticationResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = c.PerformOperationWithAuthentication("getSubscriberMetadata", ar)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.authentications != 1 || a.requests != 2 {
+		t.Errorf("unexpected authentications and requests: %d, %d", a.authentications, a.requests)
+	}
+
+	// the rejected key is retried with SIM authentication
+	rejected["stale-key-id"] = true
+	_, err = c.PerformOperationWithAuthentication("getSubscriberMetadata", &endorse.AuthenticationResult{KeyID: "stale-key-id", CK: testCK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.authentications != 2 || a.requests != 4 {
+		t.Errorf("unexpected authentications and requests: %d, %d", a.authentications, a.requests)
+	}
+
+	if _, err = c.PerformOperationWithAuthentication("getSubscriberMetadata", nil); err == nil {
+		t.Error("nil authentication result is accepted")
+	}
+}
+
+// countingMetrics counts key cache lookups and retries.
+type countingMetrics struct {
+	nopMetrics
+	hits, misses, retries int
+}
+
+func (m *countingMetrics) ObserveRetry(string) {
+	m.retries++
+}
+
+func (m *countingMetrics) ObserveKeyCache(_ string, hit bool) {
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+}
+
+func TestClientObservesKeyCacheAndRetries(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			KeyID string `json:"keyId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.KeyID == "stale-key-id" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	m := &countingMetrics{}
+	c := newTestClient(t, s.URL, "", &countingAuthenticator{imsi: "440100000000000"})
+	c.metrics = m
+	c.cfg.KeyCache = NewMemoryKeyCache()
+
+	// miss, then hit
+	for i := 0; i < 2; i++ {
+		if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.hits != 1 || m.misses != 1 || m.retries != 0 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+
+	err := c.cfg.KeyCache.Put(&KeyCacheEntry{Key: "imsi:440100000000000", KeyID: "stale-key-id", CK: testCK, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.PerformOperationWithResult("getSubscriberMetadata"); err != nil {
+		t.Fatal(err)
+	}
+	if m.hits != 2 || m.misses != 1 || m.retries != 1 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
@@ -0,0 +1,345 @@
+// Package keysapitest provides a local stand-in of the Keys API which authenticates simulated SIMs, and verifies
+// signatures of requests sent with PostWithSignature of the simulator, so that tests can run the whole flow of the
+// CLI without a physical SIM. It implements the simulator-only protocol defined in the simulator package, not the
+// wire format of the SORACOM Keys API. endorse.Client reads keys from a physical UICC and speaks the SORACOM Keys
+// API, so it cannot be run against this server.
+package keysapitest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton/simulator"
+)
+
+// DefaultKeyTTL is how long an issued key is valid when Server.KeyTTL is not set.
+const DefaultKeyTTL = time.Hour
+
+// amf is the authentication management field of the challenges, with the separation bit set.
+var amf = []byte{0x80, 0x00}
+
+type subscriber struct {
+	milenage *simulator.Milenage
+	sqn      uint64
+}
+
+// key is an issued key, which is usable after RES is verified.
+type key struct {
+	imsi      string
+	xres      []byte
+	ck        []byte
+	verified  bool
+	expiresAt time.Time
+}
+
+// Server is a stand-in of the Keys API. Its zero value is not usable; use NewServer or NewHandler.
+type Server struct {
+	// URL is the endpoint URL to be given as the Keys API endpoint, set by NewServer.
+	URL string
+	// KeyTTL is how long an issued key is valid. (default: DefaultKeyTTL)
+	KeyTTL time.Duration
+
+	server *httptest.Server
+	now    func() time.Time
+
+	mu          sync.Mutex
+	subscribers map[string]*subscriber
+	keys        map[string]*key
+}
+
+// NewHandler returns a Server which knows the subscriptions of the simulated SIMs, without starting it.
+// Use it as an http.Handler, e.g. with httptest.NewServer.
+func NewHandler(sims ...*simulator.SIMConfig) (*Server, error) {
+	s := &Server{
+		now:         time.Now,
+		subscribers: map[string]*subscriber{},
+		keys:        map[string]*key{},
+	}
+	for _, cfg := range sims {
+		err := s.AddSubscriber(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewServer starts a Server on a local address. URL is the endpoint to use as -keys-api-endpoint-url.
+func NewServer(sims ...*simulator.SIMConfig) (*Server, error) {
+	s, err := NewHandler(sims...)
+	if err != nil {
+		return nil, err
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s, nil
+}
+
+// Close stops the server started by NewServer.
+func (s *Server) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// AddSubscriber adds or replaces the subscription of a simulated SIM. SQN starts from 1.
+func (s *Server) AddSubscriber(cfg *simulator.SIMConfig) error {
+	// NewSIM validates the IMSI
+	sim, err := simulator.NewSIM(cfg)
+	if err != nil {
+		return err
+	}
+	m, err := cfg.Milenage()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[sim.IMSI()] = &subscriber{milenage: m}
+	return nil
+}
+
+// CK returns the CK of a verified key which is not expired.
+func (s *Server) CK(keyID string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[keyID]
+	if !ok || !k.verified || !s.now().Before(k.expiresAt) {
+		return nil, false
+	}
+	return k.ck, true
+}
+
+// ServeHTTP implements http.Handler for the paths in the simulator package.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.URL.Path == simulator.KeysPath {
+		s.initiate(w, r)
+		return
+	}
+	if keyID, ok := strings.CutPrefix(r.URL.Path, simulator.KeysPath+"/"); ok {
+		if keyID, ok = strings.CutSuffix(keyID, "/verify"); ok {
+			s.verify(w, r, keyID)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(simulator.StandInHeader, "keysapitest")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) initiate(w http.ResponseWriter, r *http.Request) {
+	var req simulator.InitiateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscribers[req.IMSI]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown IMSI")
+		return
+	}
+	if req.Resync != nil {
+		err = sub.resync(req.Resync)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	challenge := make([]byte, simulator.RandLength)
+	_, err = io.ReadFull(rand.Reader, challenge)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sub.sqn++
+	autn, err := sub.milenage.GenerateAUTN(challenge, sqnBytes(sub.sqn), amf)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	xres, ck, _, _, err := sub.milenage.F2345(challenge)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	id := make([]byte, 16)
+	_, err = io.ReadFull(rand.Reader, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	keyID := hex.EncodeToString(id)
+	s.keys[keyID] = &key{imsi: req.IMSI, xres: xres, ck: ck}
+
+	writeJSON(w, http.StatusCreated, &simulator.InitiateResponse{
+		KeyID: keyID,
+		Rand:  hex.EncodeToString(challenge),
+		AUTN:  hex.EncodeToString(autn),
+	})
+}
+
+// resync sets SQN to the one in AUTS so that the next challenge is fresh for the SIM.
+func (sub *subscriber) resync(r *simulator.Resync) error {
+	challenge, err := hex.DecodeString(r.Rand)
+	if err != nil || len(challenge) != simulator.RandLength {
+		return errors.New("invalid rand for resynchronisation")
+	}
+	auts, err := hex.DecodeString(r.AUTS)
+	if err != nil || len(auts) != simulator.SQNLength+8 {
+		return errors.New("invalid AUTS")
+	}
+	akStar, err := sub.milenage.F5Star(challenge)
+	if err != nil {
+		return err
+	}
+	sqn := make([]byte, simulator.SQNLength)
+	for i := range sqn {
+		sqn[i] = auts[i] ^ akStar[i]
+	}
+	_, macS, err := sub.milenage.F1(challenge, sqn, make([]byte, len(amf)))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(macS, auts[simulator.SQNLength:]) {
+		return errors.New("MAC-S in AUTS is invalid")
+	}
+	sub.sqn = binary.BigEndian.Uint64(append([]byte{0, 0}, sqn...))
+	return nil
+}
+
+func sqnBytes(sqn uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, sqn)
+	return b[8-simulator.SQNLength:]
+}
+
+func (s *Server) verify(w http.ResponseWriter, r *http.Request, keyID string) {
+	var req simulator.VerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	res, err := hex.DecodeString(req.RES)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid res")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[keyID]
+	if !ok || k.verified {
+		writeError(w, http.StatusNotFound, "unknown key ID")
+		return
+	}
+	if !hmac.Equal(res, k.xres) {
+		delete(s.keys, keyID)
+		writeError(w, http.StatusForbidden, "RES does not match")
+		return
+	}
+	ttl := s.KeyTTL
+	if ttl == 0 {
+		ttl = DefaultKeyTTL
+	}
+	k.verified = true
+	k.expiresAt = s.now().Add(ttl)
+	writeJSON(w, http.StatusOK, &simulator.VerifyResponse{KeyID: keyID, ExpiresAt: k.expiresAt.UTC()})
+}
+
+// VerifySignature checks the signature of a request sent with PostWithSignature, and returns the key ID in the
+// body and the body. The body of r is replaced so that it can be read again.
+func (s *Server) VerifySignature(r *http.Request) (string, []byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var b struct {
+		KeyID string `json:"keyId"`
+	}
+	err = json.Unmarshal(body, &b)
+	if err != nil || b.KeyID == "" {
+		return "", body, errors.New("keyId is not found in the request body")
+	}
+	ck, ok := s.CK(b.KeyID)
+	if !ok {
+		return b.KeyID, body, errors.Errorf("key %s is unknown, not verified or expired", b.KeyID)
+	}
+	algorithm := r.Header.Get(simulator.SignatureAlgorithmHeader)
+	h, ok := signatureHashes[algorithm]
+	if !ok {
+		return b.KeyID, body, errors.Errorf("unsupported signature algorithm: %s", algorithm)
+	}
+	signature, err := hex.DecodeString(r.Header.Get(simulator.SignatureHeader))
+	if err != nil {
+		return b.KeyID, body, errors.Wrap(err, "signature is not hex encoded")
+	}
+	if !hmac.Equal(mac(h, ck, body), signature) {
+		return b.KeyID, body, errors.New("signature does not match")
+	}
+	return b.KeyID, body, nil
+}
+
+// signatureHashes are the hash functions of the signature algorithms. The signature is verified independently of
+// simulator.Sign so that a bug in the signer is not hidden by the same bug in the verifier.
+var signatureHashes = map[string]func() hash.Hash{
+	"SHA-256": sha256.New,
+	"SHA-384": sha512.New384,
+	"SHA-512": sha512.New,
+}
+
+// mac returns the HMAC of body with key.
+func mac(h func() hash.Hash, key, body []byte) []byte {
+	m := hmac.New(h, key)
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// RequireSignature returns a handler which responds 403 to requests without a valid signature, and passes the
+// others to h, e.g. a stand-in of the provisioning API.
+func (s *Server) RequireSignature(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err := s.VerifySignature(r)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,281 @@
+package keysapitest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soracom/krypton-client-go/krypton"
+	"github.com/soracom/krypton-client-go/krypton/simulator"
+)
+
+var testSIMConfig = &simulator.SIMConfig{
+	IMSI: "001010000000001",
+	K:    "465b5ce8b199b49faa5f0a2ee238a6bc",
+	OPc:  "cd63cb71954a9f4e48a5994e37a02baf",
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestAuthenticator(t *testing.T, sim *simulator.SIM, endpoint string) *simulator.Authenticator {
+	t.Helper()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := simulator.NewAuthenticator(sim, &simulator.Config{KeysAPIEndpointURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func newTestSIM(t *testing.T) *simulator.SIM {
+	t.Helper()
+	sim, err := simulator.NewSIM(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// newProvisioningAPI returns a stand-in of the provisioning API which requires valid signatures.
+func newProvisioningAPI(t *testing.T, keys *Server) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(keys.RequireSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"imsi":"001010000000001"}`)
+	})))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAuthenticationFlow(t *testing.T) {
+	keys := newTestServer(t)
+	api := newProvisioningAPI(t, keys)
+	u, _ := url.Parse(api.URL)
+
+	c, err := krypton.NewClient(&krypton.Config{
+		ProvisioningAPIEndpointURL: u,
+		Authenticator:              newTestAuthenticator(t, newTestSIM(t), keys.URL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.PerformOperationWithResult("getSubscriberMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"imsi":"001010000000001"}` {
+		t.Errorf("unexpected result: %s", result)
+	}
+
+	// a second authentication uses the next SQN
+	keyID, err := c.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.CK(keyID); !ok {
+		t.Errorf("key %s is not issued", keyID)
+	}
+}
+
+func TestSignatureVerification(t *testing.T) {
+	keys := newTestServer(t)
+	api := newProvisioningAPI(t, keys)
+	a := newTestAuthenticator(t, newTestSIM(t), keys.URL)
+	ar, err := a.DoAuthentication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, ok := keys.CK(ar.KeyID)
+	if !ok || !bytes.Equal(ck, ar.CK) {
+		t.Fatalf("CK of the server does not match: %x %x", ck, ar.CK)
+	}
+	u, _ := url.Parse(api.URL + "/v1/provisioning/soracom/air/subscriber_metadata")
+
+	post := func(ck []byte, body interface{}) int {
+		t.Helper()
+		resp, err := a.PostWithSignature(u, ck, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(ar.CK, map[string]string{"keyId": ar.KeyID}); status != http.StatusOK {
+		t.Errorf("valid signature was rejected: %d", status)
+	}
+	if status := post(make([]byte, len(ar.CK)), map[string]string{"keyId": ar.KeyID}); status != http.StatusForbidden {
+		t.Errorf("signature with a wrong CK was accepted: %d", status)
+	}
+	if status := post(ar.CK, map[string]string{"keyId": "unknown"}); status != http.StatusForbidden {
+		t.Errorf("unknown key ID was accepted: %d", status)
+	}
+	if status := post(ar.CK, map[string]string{}); status != http.StatusForbidden {
+		t.Errorf("request without key ID was accepted: %d", status)
+	}
+
+	// the body is signed as it is sent, so a modified body is rejected
+	body := `{"keyId":"` + ar.KeyID + `"}`
+	sig, err := simulator.Sign("SHA-256", ar.CK, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(body+" "))
+	req.Header.Set(simulator.SignatureAlgorithmHeader, "SHA-256")
+	req.Header.Set(simulator.SignatureHeader, sig)
+	if _, _, err = keys.VerifySignature(req); err == nil {
+		t.Error("modified body was accepted")
+	}
+
+	// expired keys are rejected
+	keys.now = func() time.Time { return time.Now().Add(DefaultKeyTTL) }
+	if status := post(ar.CK, map[string]string{"keyId": ar.KeyID}); status != http.StatusForbidden {
+		t.Errorf("expired key was accepted: %d", status)
+	}
+}
+
+// TestSignatureKnownAnswers checks both the signer and the verifier against fixed HMAC values, test case 2 of
+// RFC 4231 and the same key over a request body, instead of against each other.
+func TestSignatureKnownAnswers(t *testing.T) {
+	ck := []byte("Jefe")
+	tests := []struct {
+		algorithm string
+		rfc4231   string
+		body      string
+	}{
+		{
+			"SHA-256",
+			"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+			"0b69a3c010b1eba55e2d3a132232cf4da0e5fe0d6dab993f581a757e478b2d62",
+		},
+		{
+			"SHA-384",
+			"af45d2e376484031617f78d2b58a6b1b9c7ef464f5a01b47e42ec3736322445e8e2240ca5e69e2c78b3239ecfab21649",
+			"fe4133f59e9ac0ea14822fcf9fd29c32147140e7c3cafab1a669b7a92f35204f21823eb7d750fe20f3fa93eb84ae1e1a",
+		},
+		{
+			"SHA-512",
+			"164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea2505549758bf75c05a994a6d034f65f8f0e6fdcaeab1a34d4a6b4b636e070a38bce737",
+			"2eafb71059443eb81433d68cc9198428053dcb064a7d9f6355db8a86ad07ee1f13721480895ce1d0ec2cd04321148ff17e9fb42b5d7282b1c39ffa2dbdb12248",
+		},
+	}
+
+	keys := newTestServer(t)
+	keys.keys["jefe"] = &key{ck: ck, verified: true, expiresAt: time.Now().Add(time.Hour)}
+	body := `{"keyId":"jefe"}`
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			if got, err := simulator.Sign(tt.algorithm, ck, []byte("what do ya want for nothing?")); err != nil || got != tt.rfc4231 {
+				t.Errorf("unexpected signature of the simulator: %s %v", got, err)
+			}
+			if got, err := simulator.Sign(tt.algorithm, ck, []byte(body)); err != nil || got != tt.body {
+				t.Errorf("unexpected signature of the body: %s %v", got, err)
+			}
+			if got := hex.EncodeToString(mac(signatureHashes[tt.algorithm], ck, []byte("what do ya want for nothing?"))); got != tt.rfc4231 {
+				t.Errorf("unexpected signature of the verifier: %s", got)
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, keys.URL, strings.NewReader(body))
+			req.Header.Set(simulator.SignatureAlgorithmHeader, tt.algorithm)
+			req.Header.Set(simulator.SignatureHeader, tt.body)
+			if _, _, err := keys.VerifySignature(req); err != nil {
+				t.Errorf("known signature was rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestResynchronisation(t *testing.T) {
+	sim := newTestSIM(t)
+	keys := newTestServer(t)
+	a := newTestAuthenticator(t, sim, keys.URL)
+	for i := 0; i < 3; i++ {
+		_, err := a.DoAuthentication()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new server starts from SQN 1, which the SIM has already seen
+	keys = newTestServer(t)
+	a = newTestAuthenticator(t, sim, keys.URL)
+	ar, err := a.DoAuthentication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.CK(ar.KeyID); !ok {
+		t.Error("key is not issued after resynchronisation")
+	}
+	keys.mu.Lock()
+	sqn := keys.subscribers[testSIMConfig.IMSI].sqn
+	keys.mu.Unlock()
+	if sqn != 4 {
+		t.Errorf("expected SQN 4, got %d", sqn)
+	}
+}
+
+func TestAuthenticationFailures(t *testing.T) {
+	keys := newTestServer(t)
+
+	unknown, err := simulator.NewSIM(&simulator.SIMConfig{IMSI: "001010000000002", K: testSIMConfig.K, OPc: testSIMConfig.OPc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestAuthenticator(t, unknown, keys.URL).DoAuthentication()
+	if krypton.Category(err) != krypton.ErrorCategoryAuthentication {
+		t.Errorf("expected an authentication error for unknown IMSI, got %v", err)
+	}
+
+	// a SIM with a different K rejects the challenge
+	wrong, err := simulator.NewSIM(&simulator.SIMConfig{IMSI: testSIMConfig.IMSI, K: strings.Repeat("00", simulator.KeyLength), OPc: testSIMConfig.OPc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestAuthenticator(t, wrong, keys.URL).DoAuthentication()
+	if err == nil || krypton.Category(err) != krypton.ErrorCategoryAuthentication {
+		t.Errorf("expected an authentication error for wrong K, got %v", err)
+	}
+
+	// wrong RES
+	resp, err := http.Post(keys.URL+simulator.KeysPath, "application/json", strings.NewReader(`{"imsi":"001010000000001"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	keys.mu.Lock()
+	var keyID string
+	for id := range keys.keys {
+		keyID = id
+	}
+	keys.mu.Unlock()
+	resp, err = http.Post(keys.URL+"/v1/keys/"+keyID+"/verify", "application/json", strings.NewReader(`{"res":"0000000000000000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong RES was accepted: %d", resp.StatusCode)
+	}
+	if _, ok := keys.CK(keyID); ok {
+		t.Error("key is issued for wrong RES")
+	}
+}
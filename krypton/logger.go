@@ -0,0 +1,73 @@
+package krypton
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+// Logger receives debug messages of a Client with structured fields given as alternating keys and values
+// e.g. logger.Debug("received response", "operation", "getUserData", "status", 200).
+//
+// *slog.Logger satisfies this interface. Use NewGoLoggingLogger for github.com/op/go-logging.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+}
+
+// NewSlogLogger returns a Logger which writes to l. A nil l falls back to slog.Default().
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
+
+type goLoggingLogger struct {
+	l *logging.Logger
+}
+
+// NewGoLoggingLogger returns a Logger which writes to l as `msg key=value key=value ...`.
+func NewGoLoggingLogger(l *logging.Logger) Logger {
+	return &goLoggingLogger{l: l}
+}
+
+func (g *goLoggingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	if g.l == nil {
+		return
+	}
+	g.l.Debug(formatLogMessage(msg, keysAndValues))
+}
+
+func formatLogMessage(msg string, keysAndValues []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		sb.WriteString(" ")
+		if i+1 >= len(keysAndValues) {
+			fmt.Fprintf(&sb, "!BADKEY=%v", keysAndValues[i])
+			break
+		}
+		fmt.Fprintf(&sb, "%v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return sb.String()
+}
+
+func (c *Client) withLogFields(keysAndValues ...interface{}) *Client {
+	cc := *c
+	cc.logFields = append(append([]interface{}{}, c.logFields...), keysAndValues...)
+	return &cc
+}
+
+func (c *Client) debug(msg string, keysAndValues ...interface{}) {
+	if c.logger == nil {
+		return
+	}
+	kv := append(append([]interface{}{}, c.logFields...), keysAndValues...)
+	if !c.cfg.ShowSecrets {
+		msg = RedactSecrets(msg)
+		kv = RedactKeysAndValues(kv)
+	}
+	c.logger.Debug(msg, kv...)
+}
@@ -0,0 +1,93 @@
+package krypton
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingLogger records debug messages as `msg key=value ...`.
+type recordingLogger struct {
+	messages []string
+	fields   []map[string]interface{}
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.messages = append(l.messages, formatLogMessage(msg, keysAndValues))
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	l.fields = append(l.fields, fields)
+}
+
+func TestClientDebugRedactsFields(t *testing.T) {
+	l := &recordingLogger{}
+	c := &Client{cfg: &Config{}, logger: l}
+	c.withLogFields("operation", "test").debug("message with privateKey=secret",
+		"ck", "000102030405060708090a0b0c0d0e0f",
+		"sessionToken", []byte("token"),
+		"body", `{"psk":"secret","imsi":"440100000000000"}`,
+		"attempt", 1,
+	)
+	want := `message with privateKey=******** operation=test ck=******** sessionToken=******** body={"psk":"********","imsi":"440100000000000"} attempt=1`
+	if l.messages[0] != want {
+		t.Errorf("unexpected message:\n%s\nwant:\n%s", l.messages[0], want)
+	}
+
+	c.cfg.ShowSecrets = true
+	c.debug("shown", "ck", "0001")
+	if l.messages[1] != "shown ck=0001" {
+		t.Errorf("secrets are redacted with ShowSecrets: %s", l.messages[1])
+	}
+
+	// a dangling key is kept as is
+	if kv := RedactKeysAndValues([]interface{}{"status", 200, "ck"}); len(kv) != 3 || kv[1] != 200 || kv[2] != "ck" {
+		t.Errorf("unexpected keys and values: %v", kv)
+	}
+}
+
+func TestClientLogsAttempts(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			KeyID string `json:"keyId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.KeyID == "stale-key-id" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	l := &recordingLogger{}
+	c := newTestClient(t, s.URL, "", &countingAuthenticator{imsi: "440100000000000"})
+	c.logger = l
+	c.cfg.KeyCache = NewMemoryKeyCache()
+	err := c.cfg.KeyCache.Put(&KeyCacheEntry{Key: "imsi:440100000000000", KeyID: "stale-key-id", CK: testCK, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.PerformOperationWithResult("getSubscriberMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attempts []interface{}
+	for i, m := range l.messages {
+		if l.fields[i]["operation"] != "getSubscriberMetadata" {
+			t.Errorf("the operation is not logged: %s", m)
+		}
+		if _, ok := l.fields[i]["status"]; ok && l.fields[i]["path"] != nil {
+			attempts = append(attempts, l.fields[i]["attempt"])
+		}
+	}
+	if fmt.Sprint(attempts) != "[1 1 2]" {
+		t.Errorf("unexpected attempts of the responses: %v\n%v", attempts, l.messages)
+	}
+}
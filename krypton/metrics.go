@@ -0,0 +1,27 @@
+package krypton
+
+import "time"
+
+// Metrics receives measurements of provisioning operations performed by a Client.
+// Implementations must be safe for concurrent use. See the metrics package for a Prometheus implementation.
+type Metrics interface {
+	// ObserveOperation is called when an operation is completed. err is nil when the operation succeeded.
+	ObserveOperation(operation string, err error, duration time.Duration)
+	// ObserveAuthentication is called when SIM authentication for the operation is completed.
+	ObserveAuthentication(operation string, err error, duration time.Duration)
+	// ObserveRequest is called when a response to the provisioning API request is received.
+	// statusCode is 0 when no response is received.
+	ObserveRequest(operation string, statusCode int, err error, duration time.Duration)
+	// ObserveRetry is called when a request is retried with SIM authentication because the cached key is rejected.
+	ObserveRetry(operation string)
+	// ObserveKeyCache is called when the key cache is looked up. hit is false when no valid entry is found.
+	ObserveKeyCache(operation string, hit bool)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveOperation(string, error, time.Duration)      {}
+func (nopMetrics) ObserveAuthentication(string, error, time.Duration) {}
+func (nopMetrics) ObserveRequest(string, int, error, time.Duration)   {}
+func (nopMetrics) ObserveRetry(string)                                {}
+func (nopMetrics) ObserveKeyCache(string, bool)                       {}
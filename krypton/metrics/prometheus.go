@@ -0,0 +1,129 @@
+// Package metrics provides a Prometheus implementation of krypton.Metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+const namespace = "krypton"
+
+// Prometheus records krypton.Metrics as Prometheus counters and histograms.
+type Prometheus struct {
+	operations             *prometheus.CounterVec
+	operationDuration      *prometheus.HistogramVec
+	authentications        *prometheus.CounterVec
+	authenticationDuration *prometheus.HistogramVec
+	requests               *prometheus.CounterVec
+	requestDuration        *prometheus.HistogramVec
+	retries                *prometheus.CounterVec
+	keyCacheLookups        *prometheus.CounterVec
+}
+
+var _ krypton.Metrics = (*Prometheus)(nil)
+
+// NewPrometheus creates collectors and registers them to reg.
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	p := &Prometheus{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Number of provisioning operations by operation name and result.",
+		}, []string{"operation", "result"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of provisioning operations including SIM authentication.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"operation"}),
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authentications_total",
+			Help:      "Number of SIM authentications by operation name and result.",
+		}, []string{"operation", "result"}),
+		authenticationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "authentication_duration_seconds",
+			Help:      "Duration of SIM authentications.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"operation"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of requests to the provisioning API by operation name and HTTP status code. code is \"error\" when no response is received.",
+		}, []string{"operation", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of requests to the provisioning API.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Number of requests retried with SIM authentication because the cached key was rejected.",
+		}, []string{"operation"}),
+		keyCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "key_cache_lookups_total",
+			Help:      "Number of key cache lookups by operation name and result. result is \"hit\" or \"miss\".",
+		}, []string{"operation", "result"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		p.operations,
+		p.operationDuration,
+		p.authentications,
+		p.authenticationDuration,
+		p.requests,
+		p.requestDuration,
+		p.retries,
+		p.keyCacheLookups,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Prometheus) ObserveOperation(operation string, err error, duration time.Duration) {
+	p.operations.WithLabelValues(operation, result(err)).Inc()
+	p.operationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) ObserveAuthentication(operation string, err error, duration time.Duration) {
+	p.authentications.WithLabelValues(operation, result(err)).Inc()
+	p.authenticationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) ObserveRequest(operation string, statusCode int, err error, duration time.Duration) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(statusCode)
+	}
+	p.requests.WithLabelValues(operation, code).Inc()
+	p.requestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) ObserveRetry(operation string) {
+	p.retries.WithLabelValues(operation).Inc()
+}
+
+func (p *Prometheus) ObserveKeyCache(operation string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	p.keyCacheLookups.WithLabelValues(operation, result).Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterValues returns the values of the counter named name by its label values joined with ",".
+func counterValues(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			key := ""
+			for i, l := range m.GetLabel() {
+				if i > 0 {
+					key += ","
+				}
+				key += l.GetValue()
+			}
+			values[key] = m.GetCounter().GetValue()
+		}
+	}
+	return values
+}
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p, err := NewPrometheus(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.ObserveOperation("getUserData", nil, time.Second)
+	p.ObserveOperation("getUserData", errors.New("failed"), time.Second)
+	p.ObserveAuthentication("getUserData", nil, time.Second)
+	p.ObserveRequest("getUserData", 200, nil, time.Second)
+	p.ObserveRequest("getUserData", 0, errors.New("failed"), time.Second)
+	p.ObserveKeyCache("getUserData", false)
+	p.ObserveKeyCache("getUserData", true)
+	p.ObserveKeyCache("getUserData", true)
+	p.ObserveRetry("getUserData")
+
+	tests := []struct {
+		name string
+		want map[string]float64
+	}{
+		{"krypton_operations_total", map[string]float64{"getUserData,success": 1, "getUserData,error": 1}},
+		{"krypton_authentications_total", map[string]float64{"getUserData,success": 1}},
+		{"krypton_http_requests_total", map[string]float64{"200,getUserData": 1, "error,getUserData": 1}},
+		{"krypton_key_cache_lookups_total", map[string]float64{"getUserData,hit": 2, "getUserData,miss": 1}},
+		{"krypton_retries_total", map[string]float64{"getUserData": 1}},
+	}
+	for _, tt := range tests {
+		got := counterValues(t, reg, tt.name)
+		if len(got) != len(tt.want) {
+			t.Errorf("unexpected %s: %v", tt.name, got)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("unexpected %s{%s}: %v", tt.name, k, got[k])
+			}
+		}
+	}
+
+	if _, err = NewPrometheus(reg); err == nil {
+		t.Error("the collectors are registered twice")
+	}
+}
@@ -1,13 +1,8 @@
 package krypton
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"sort"
 	"strings"
@@ -15,10 +10,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	inventoryApplicationKeyLength = 16
-)
-
 var operations map[string]Operation
 
 func init() {
@@ -43,9 +34,17 @@ func init() {
 type Operation interface {
 	GetName() string
 	GetHelpText() string
+	// Perform performs the operation and prints the result to stdout.
 	Perform(*Client) error
 }
 
+// ResultOperation is an Operation which returns the result instead of printing it. All the built-in operations
+// implement it.
+type ResultOperation interface {
+	Operation
+	PerformWithResult(*Client) ([]byte, error)
+}
+
 type OperationBootstrapArc struct {
 }
 
@@ -58,6 +57,10 @@ func (o *OperationBootstrapArc) GetHelpText() string {
 }
 
 func (o *OperationBootstrapArc) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationBootstrapArc) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/soracom/arc/bootstrap")
 }
 
@@ -73,53 +76,36 @@ func (o *OperationBootstrapAWSIoTThing) GetHelpText() string {
 }
 
 func (o *OperationBootstrapAWSIoTThing) Perform(kc *Client) error {
-	log("performing bootstrapAwsIotThing")
-
-	ec := kc.cfg.EndorseClient
-
-	log("performing authentication")
-	ar, err := ec.DoAuthentication()
-	if err != nil {
-		return err
-	}
+	return printResult(o.PerformWithResult(kc))
+}
 
+func (o *OperationBootstrapAWSIoTThing) PerformWithResult(kc *Client) ([]byte, error) {
 	u, err := url.Parse(fmt.Sprintf("%s%s", strings.TrimSuffix(kc.cfg.ProvisioningAPIEndpointURL.String(), "/"), "/v1/provisioning/aws/iot/bootstrap"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var rp map[string]interface{}
-	if kc.cfg.RequestParameters != "" {
-		err = json.Unmarshal([]byte(kc.cfg.RequestParameters), &rp)
-		if err != nil {
-			return err
-		}
-	}
-
-	reqBody := struct {
-		KeyID             string                 `json:"keyId"`
-		RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
-	}{
-		KeyID:             ar.KeyID,
-		RequestParameters: rp,
-	}
-
-	resp, err := ec.PostWithSignature(u, ar.CK, reqBody)
+	rp, err := kc.requestParameters()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBodyBytes, err := ioutil.ReadAll(resp.Body)
+	_, respBodyBytes, err := kc.signedRequest(u, func(keyID string) interface{} {
+		return struct {
+			KeyID             string                 `json:"keyId"`
+			RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
+		}{
+			KeyID:             keyID,
+			RequestParameters: rp,
+		}
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log("received response: %s", string(respBodyBytes))
-
-	fmt.Println(string(respBodyBytes))
+	kc.debug("received response body", "body", string(respBodyBytes))
 
-	return nil
+	return respBodyBytes, nil
 }
 
 type OperationRegisterAzureIoTDevice struct {
@@ -134,6 +120,10 @@ func (o *OperationRegisterAzureIoTDevice) GetHelpText() string {
 }
 
 func (o *OperationRegisterAzureIoTDevice) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationRegisterAzureIoTDevice) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/azure/iot/register")
 
 }
@@ -154,61 +144,41 @@ type GetAzureIoTDeviceRegistrationStatusRequestParameters struct {
 }
 
 func (o *OperationGetAzureIoTDeviceRegistrationStatus) Perform(kc *Client) error {
-	log("performing getAzureIoTDeviceRegistrationStatus")
-	var rp map[string]interface{}
-	if kc.cfg.RequestParameters != "" {
-		err := json.Unmarshal([]byte(kc.cfg.RequestParameters), &rp)
-		if err != nil {
-			return err
-		}
-	}
-
-	operationID := rp["operationId"]
-	if operationID == "" {
-		return errors.New("mandatory request parameter 'operationId' is not specified")
-	}
-
-	ec := kc.cfg.EndorseClient
-
-	log("performing authentication")
-	ar, err := ec.DoAuthentication()
-	if err != nil {
-		return err
-	}
+	return printResult(o.PerformWithResult(kc))
+}
 
-	u, err := url.Parse(fmt.Sprintf("%s%s%s", strings.TrimSuffix(kc.cfg.ProvisioningAPIEndpointURL.String(), "/"), "/v1/provisioning/azure/iot/registrations/", operationID))
+func (o *OperationGetAzureIoTDeviceRegistrationStatus) PerformWithResult(kc *Client) ([]byte, error) {
+	rp, err := kc.requestParameters()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	reqBody := struct {
-		KeyID             string                 `json:"keyId"`
-		RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
-	}{
-		KeyID:             ar.KeyID,
-		RequestParameters: rp,
+	operationID, ok := rp["operationId"].(string)
+	if !ok || operationID == "" {
+		return nil, newError(ErrorCategoryInvalidParameters, errors.New("mandatory request parameter 'operationId' is not specified"))
 	}
 
-	resp, err := ec.PostWithSignature(u, ar.CK, reqBody)
+	u, err := url.Parse(fmt.Sprintf("%s%s%s", strings.TrimSuffix(kc.cfg.ProvisioningAPIEndpointURL.String(), "/"), "/v1/provisioning/azure/iot/registrations/", operationID))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBodyBytes, err := ioutil.ReadAll(resp.Body)
+	_, respBodyBytes, err := kc.signedRequest(u, func(keyID string) interface{} {
+		return struct {
+			KeyID             string                 `json:"keyId"`
+			RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
+		}{
+			KeyID:             keyID,
+			RequestParameters: rp,
+		}
+	})
 	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode > http.StatusBadRequest {
-		return errors.Errorf("unsuccessful response: %s\n%s", resp.Status, string(respBodyBytes))
+		return nil, err
 	}
 
-	log("received response: %s", string(respBodyBytes))
+	kc.debug("received response body", "body", string(respBodyBytes))
 
-	fmt.Println(string(respBodyBytes))
-
-	return nil
+	return respBodyBytes, nil
 }
 
 type OperationBootstrapInventoryDevice struct {
@@ -223,119 +193,66 @@ func (o *OperationBootstrapInventoryDevice) GetHelpText() string {
 }
 
 func (o *OperationBootstrapInventoryDevice) Perform(kc *Client) error {
-	ec := kc.cfg.EndorseClient
-	ar, err := ec.DoAuthentication()
-	if err != nil {
-		return err
-	}
+	return printResult(o.PerformWithResult(kc))
+}
 
+func (o *OperationBootstrapInventoryDevice) PerformWithResult(kc *Client) ([]byte, error) {
 	u, err := url.Parse(fmt.Sprintf("%s%s", strings.TrimSuffix(kc.cfg.ProvisioningAPIEndpointURL.String(), "/"), "/v1/provisioning/soracom/inventory/bootstrap"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	ep, err := kc.getValueFromRequestParameterOption("endpoint")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	endpoint, ok := ep.(string)
 	if !ok {
-		return errors.New("endpoint must be a string")
-	}
-
-	var rp map[string]interface{}
-	if kc.cfg.RequestParameters != "" {
-		err = json.Unmarshal([]byte(kc.cfg.RequestParameters), &rp)
-		if err != nil {
-			return err
-		}
+		return nil, newError(ErrorCategoryInvalidParameters, errors.New("endpoint must be a string"))
 	}
 
-	reqBody := struct {
-		KeyID             string                 `json:"keyId"`
-		Endpoint          string                 `json:"endpoint"`
-		RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
-	}{
-		KeyID:             ar.KeyID,
-		Endpoint:          endpoint,
-		RequestParameters: rp,
-	}
-
-	resp, err := ec.PostWithSignature(u, ar.CK, reqBody)
+	rp, err := kc.requestParameters()
 	if err != nil {
-		return err
-	}
-	defer func() {
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-	}()
-
-	respBodyBytes, err := ioutil.ReadAll(resp.Body)
+		return nil, err
+	}
+
+	ar, respBodyBytes, err := kc.signedRequest(u, func(keyID string) interface{} {
+		return struct {
+			KeyID             string                 `json:"keyId"`
+			Endpoint          string                 `json:"endpoint"`
+			RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
+		}{
+			KeyID:             keyID,
+			Endpoint:          endpoint,
+			RequestParameters: rp,
+		}
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	respMap := make(map[string]interface{})
 	err = json.Unmarshal(respBodyBytes, &respMap)
 	if err != nil {
-		return err
+		return nil, newError(ErrorCategoryServer, errors.Wrap(err, "unable to parse the response from the server"))
 	}
 
-	appKey, err := generateApplicationKeyForInventory(respMap, ar.CK)
+	appKey, err := InventoryApplicationKeyFromResponse(respMap, ar.CK)
 	if err != nil {
-		return err
+		return nil, newError(ErrorCategoryServer, err)
 	}
+	kc.debug("application key obtained", "source", appKey.Source)
 
-	respMap["applicationKey"] = appKey
-	respMap = filterMap(respMap, []string{"applicationKey", "serverUri", "pskId"})
+	respMap["applicationKey"] = appKey.String()
+	respMap["applicationKeySource"] = appKey.Source
+	respMap = filterMap(respMap, []string{"applicationKey", "applicationKeySource", "serverUri", "pskId"})
 
 	mergedRespBytes, err := json.Marshal(respMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println(string(mergedRespBytes))
-
-	return nil
-}
-
-func generateApplicationKeyForInventory(m map[string]interface{}, ck []byte) (string, error) {
-	appKeyRaw, found := m["applicationKey"]
-	if found {
-		appKeyStr, ok := appKeyRaw.(string)
-		if ok {
-			return appKeyStr, nil
-		}
-	}
-
-	nonceRaw, found := m["nonce"]
-	if !found {
-		return "", errors.New("nonce is not found in the response from the server")
-	}
-	nonceStr, ok := nonceRaw.(string)
-	if !ok {
-		return "", errors.New("nonce must be a string")
-	}
-	nonceBytes, err := base64.StdEncoding.DecodeString(nonceStr)
-	if err != nil {
-		return "", err
-	}
-
-	timestampRaw, found := m["timestamp"]
-	if !found {
-		return "", errors.New("timestamp is not found in the response from the server")
-	}
-	timestampStr, ok := timestampRaw.(string)
-	if !ok {
-		return "", errors.New("timestamp must be a string")
-	}
-
-	appKey := calculateInventoryApplicationKey(nonceBytes, timestampStr, ck)
-	if err != nil {
-		return "", err
-	}
-
-	return base64.StdEncoding.EncodeToString(appKey), nil
+	return mergedRespBytes, nil
 }
 
 func filterMap(m map[string]interface{}, list []string) map[string]interface{} {
@@ -350,16 +267,6 @@ func filterMap(m map[string]interface{}, list []string) map[string]interface{} {
 	return result
 }
 
-func calculateInventoryApplicationKey(nonce []byte, timestampMillis string, ck []byte) []byte {
-	h := sha256.New()
-	h.Write(nonce)
-	h.Write([]byte(timestampMillis))
-	h.Write(ck)
-	b := h.Sum(nil)
-
-	return b[0:inventoryApplicationKeyLength]
-}
-
 type OperationGenerateAmazonCognitoOpenIDToken struct {
 }
 
@@ -372,6 +279,10 @@ func (o *OperationGenerateAmazonCognitoOpenIDToken) GetHelpText() string {
 }
 
 func (o *OperationGenerateAmazonCognitoOpenIDToken) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationGenerateAmazonCognitoOpenIDToken) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/aws/cognito/open_id_tokens")
 }
 
@@ -387,6 +298,10 @@ func (o *OperationGenerateAmazonCognitoSessionCredentials) GetHelpText() string
 }
 
 func (o *OperationGenerateAmazonCognitoSessionCredentials) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationGenerateAmazonCognitoSessionCredentials) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/aws/cognito/credentials")
 }
 
@@ -402,6 +317,10 @@ func (o *OperationGetSubscriberMetadata) GetHelpText() string {
 }
 
 func (o *OperationGetSubscriberMetadata) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationGetSubscriberMetadata) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/soracom/air/subscriber_metadata")
 }
 
@@ -417,55 +336,66 @@ func (o *OperationGetUserdata) GetHelpText() string {
 }
 
 func (o *OperationGetUserdata) Perform(kc *Client) error {
+	return printResult(o.PerformWithResult(kc))
+}
+
+func (o *OperationGetUserdata) PerformWithResult(kc *Client) ([]byte, error) {
 	return simpleOperation(kc, "/v1/provisioning/soracom/air/userdata")
 }
 
-func simpleOperation(kc *Client, path string) error {
-	ec := kc.cfg.EndorseClient
-	ar, err := ec.DoAuthentication()
+// printResult prints the result of an operation as Perform of the operations does.
+func printResult(result []byte, err error) error {
 	if err != nil {
 		return err
 	}
+	fmt.Println(string(result))
+	return nil
+}
 
+func simpleOperation(kc *Client, path string) ([]byte, error) {
 	u, err := url.Parse(fmt.Sprintf("%s%s", strings.TrimSuffix(kc.cfg.ProvisioningAPIEndpointURL.String(), "/"), path))
 	if err != nil {
-		return err
-	}
-
-	var rp map[string]interface{}
-	if kc.cfg.RequestParameters != "" {
-		err = json.Unmarshal([]byte(kc.cfg.RequestParameters), &rp)
-		if err != nil {
-			return err
-		}
-	}
-
-	reqBody := struct {
-		KeyID             string                 `json:"keyId"`
-		RequestParameters map[string]interface{} `json:"requestParameters"`
-	}{
-		KeyID:             ar.KeyID,
-		RequestParameters: rp,
+		return nil, err
 	}
 
-	resp, err := ec.PostWithSignature(u, ar.CK, reqBody)
+	rp, err := kc.requestParameters()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBodyBytes, err := ioutil.ReadAll(resp.Body)
+	_, respBodyBytes, err := kc.signedRequest(u, func(keyID string) interface{} {
+		return struct {
+			KeyID             string                 `json:"keyId"`
+			RequestParameters map[string]interface{} `json:"requestParameters"`
+		}{
+			KeyID:             keyID,
+			RequestParameters: rp,
+		}
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.StatusCode > http.StatusBadRequest {
-		return errors.Errorf("unsuccessful response: %s\n%s", resp.Status, string(respBodyBytes))
-	}
+	return respBodyBytes, nil
+}
 
-	fmt.Println(string(respBodyBytes))
+// resultOperation returns the operation which returns the result.
+func resultOperation(name string) (ResultOperation, error) {
+	op, ok := operations[name]
+	if !ok {
+		return nil, newError(ErrorCategoryInvalidParameters, errors.Errorf("unknown operation name: %s", name))
+	}
+	ro, ok := op.(ResultOperation)
+	if !ok {
+		return nil, errors.Errorf("operation %s does not return the result", name)
+	}
+	return ro, nil
+}
 
-	return nil
+// IsOperation reports whether name is the name of a supported operation.
+func IsOperation(name string) bool {
+	_, ok := operations[name]
+	return ok
 }
 
 func GenerateOperationsHelpText() string {
@@ -0,0 +1,280 @@
+package krypton
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var testCK = []byte{0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f}
+
+// fakeAuthenticator authenticates without a SIM and sends requests as plain JSON.
+type fakeAuthenticator struct {
+	err      error
+	ck       []byte
+	requests int
+}
+
+func (a *fakeAuthenticator) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &endorse.AuthenticationResult{KeyID: "test-key-id", CK: testCK}, nil
+}
+
+func (a *fakeAuthenticator) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	a.requests++
+	a.ck = ck
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(u.String(), "application/json", bytes.NewReader(b))
+}
+
+// standInAPI is a local stand-in of the provisioning API which returns a fixed response.
+type standInAPI struct {
+	*httptest.Server
+	path string
+	body []byte
+}
+
+func newStandInAPI(t *testing.T, status int, response string) *standInAPI {
+	s := &standInAPI{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		s.path = r.URL.Path
+		s.body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestClient(t *testing.T, endpoint string, params string, auth Authenticator) *Client {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(&Config{
+		ProvisioningAPIEndpointURL: u,
+		RequestParameters:          params,
+		Authenticator:              auth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func assertGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, actual, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s does not match\nexpected: %s\nactual:   %s", path, expected, actual)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	inventoryResponse := `{"serverUri":"coaps://jp.inventory.soracom.io:5684","pskId":"test-psk-id","nonce":"AAECAwQFBgcICQoLDA0ODw==","timestamp":"1600000000000","unused":"x"}`
+
+	tests := []struct {
+		name      string
+		operation string
+		params    string
+		response  string
+		path      string
+	}{
+		{"bootstrapArc", "bootstrapArc", `{"foo":"bar"}`, `{"arcClientPeerPrivateKey":"private-key"}`, "/v1/provisioning/soracom/arc/bootstrap"},
+		{"bootstrapAwsIotThing", "bootstrapAwsIotThing", "", `{"certificate":"cert","privateKey":"key"}`, "/v1/provisioning/aws/iot/bootstrap"},
+		{"bootstrapAwsIotThing_params", "bootstrapAwsIotThing", `{"skipCertificates":true}`, `{"host":"example.iot.amazonaws.com"}`, "/v1/provisioning/aws/iot/bootstrap"},
+		{"registerAzureIotDevice", "registerAzureIotDevice", "", `{"operationId":"op-1"}`, "/v1/provisioning/azure/iot/register"},
+		{"getAzureIotDeviceRegistrationStatus", "getAzureIotDeviceRegistrationStatus", `{"operationId":"op-1"}`, `{"status":"assigned"}`, "/v1/provisioning/azure/iot/registrations/op-1"},
+		{"bootstrapInventoryDevice_derived", "bootstrapInventoryDevice", `{"endpoint":"test-endpoint"}`, inventoryResponse, "/v1/provisioning/soracom/inventory/bootstrap"},
+		{"bootstrapInventoryDevice_server", "bootstrapInventoryDevice", `{"endpoint":"test-endpoint"}`, `{"serverUri":"coaps://jp.inventory.soracom.io:5684","pskId":"test-psk-id","applicationKey":"c2VydmVyLWtleQ=="}`, "/v1/provisioning/soracom/inventory/bootstrap"},
+		{"generateAmazonCognitoOpenIdToken", "generateAmazonCognitoOpenIdToken", "", `{"identityId":"id","token":"token"}`, "/v1/provisioning/aws/cognito/open_id_tokens"},
+		{"generateAmazonCognitoSessionCredentials", "generateAmazonCognitoSessionCredentials", "", `{"credentials":{"accessKeyId":"AKIA","secretAccessKey":"secret","sessionToken":"session"}}`, "/v1/provisioning/aws/cognito/credentials"},
+		{"getSubscriberMetadata", "getSubscriberMetadata", "", `{"imsi":"440100000000000"}`, "/v1/provisioning/soracom/air/subscriber_metadata"},
+		{"getUserData", "getUserData", "", `{"userdata":"hello"}`, "/v1/provisioning/soracom/air/userdata"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStandInAPI(t, http.StatusOK, tt.response)
+			auth := &fakeAuthenticator{}
+			c := newTestClient(t, api.URL, tt.params, auth)
+
+			result, err := c.PerformOperationWithResult(tt.operation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if api.path != tt.path {
+				t.Errorf("unexpected path: %s", api.path)
+			}
+			if !bytes.Equal(auth.ck, testCK) {
+				t.Errorf("request is not signed with CK: %x", auth.ck)
+			}
+			assertGolden(t, filepath.Join("operations", tt.name+".request.golden"), api.body)
+			assertGolden(t, filepath.Join("operations", tt.name+".output.golden"), result)
+		})
+	}
+}
+
+func TestOperationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		operation  string
+		params     string
+		authErr    error
+		status     int
+		response   string
+		category   ErrorCategory
+		statusCode int
+		noRequest  bool
+	}{
+		{name: "malformed params", operation: "bootstrapArc", params: `{"foo":`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "params not an object", operation: "getUserData", params: `["foo"]`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "no endpoint", operation: "bootstrapInventoryDevice", params: `{"foo":"bar"}`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "no params for endpoint", operation: "bootstrapInventoryDevice", category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "endpoint not a string", operation: "bootstrapInventoryDevice", params: `{"endpoint":1}`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "no operationId", operation: "getAzureIotDeviceRegistrationStatus", params: `{}`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "empty operationId", operation: "getAzureIotDeviceRegistrationStatus", params: `{"operationId":""}`, category: ErrorCategoryInvalidParameters, noRequest: true},
+		{name: "authentication rejected", operation: "getUserData", authErr: errors.New("authentication failed: 403 Forbidden"), category: ErrorCategoryAuthentication, noRequest: true},
+		{name: "no UICC", operation: "getUserData", authErr: errors.Wrap(&fs.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.ENOENT}, "unable to open serial port"), category: ErrorCategoryDevice, noRequest: true},
+		{name: "400", operation: "bootstrapArc", status: http.StatusBadRequest, response: `{"message":"bad request"}`, category: ErrorCategoryInvalidParameters, statusCode: http.StatusBadRequest},
+		{name: "403", operation: "getSubscriberMetadata", status: http.StatusForbidden, response: `{"message":"forbidden"}`, category: ErrorCategoryAuthentication, statusCode: http.StatusForbidden},
+		{name: "500", operation: "bootstrapAwsIotThing", status: http.StatusInternalServerError, response: `{"message":"error"}`, category: ErrorCategoryServer, statusCode: http.StatusInternalServerError},
+		{name: "503 status", operation: "getAzureIotDeviceRegistrationStatus", params: `{"operationId":"op-1"}`, status: http.StatusServiceUnavailable, category: ErrorCategoryServer, statusCode: http.StatusServiceUnavailable},
+		{name: "inventory 404", operation: "bootstrapInventoryDevice", params: `{"endpoint":"ep"}`, status: http.StatusNotFound, category: ErrorCategoryInvalidParameters, statusCode: http.StatusNotFound},
+		{name: "inventory malformed JSON", operation: "bootstrapInventoryDevice", params: `{"endpoint":"ep"}`, status: http.StatusOK, response: `{"serverUri":`, category: ErrorCategoryServer},
+		{name: "inventory no nonce", operation: "bootstrapInventoryDevice", params: `{"endpoint":"ep"}`, status: http.StatusOK, response: `{"serverUri":"coaps://example.com","timestamp":"1600000000000"}`, category: ErrorCategoryServer},
+		{name: "inventory malformed timestamp", operation: "bootstrapInventoryDevice", params: `{"endpoint":"ep"}`, status: http.StatusOK, response: `{"nonce":"AAEC","timestamp":"2020-09-13"}`, category: ErrorCategoryServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStandInAPI(t, tt.status, tt.response)
+			auth := &fakeAuthenticator{err: tt.authErr}
+			c := newTestClient(t, api.URL, tt.params, auth)
+
+			_, err := c.PerformOperationWithResult(tt.operation)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if Category(err) != tt.category {
+				t.Errorf("expected category %s but got %s: %v", tt.category, Category(err), err)
+			}
+			var e *Error
+			if errors.As(err, &e) && e.StatusCode != tt.statusCode {
+				t.Errorf("expected status code %d but got %d", tt.statusCode, e.StatusCode)
+			}
+			if tt.noRequest && auth.requests > 0 {
+				t.Error("request must not be sent")
+			}
+		})
+	}
+}
+
+func TestOperationNetworkError(t *testing.T) {
+	api := newStandInAPI(t, http.StatusOK, "{}")
+	api.Close()
+
+	c := newTestClient(t, api.URL, "", &fakeAuthenticator{})
+	_, err := c.PerformOperationWithResult("getUserData")
+	if Category(err) != ErrorCategoryNetwork {
+		t.Errorf("expected network error but got %s: %v", Category(err), err)
+	}
+}
+
+func TestOperationsImplementResultOperation(t *testing.T) {
+	for name, op := range operations {
+		if _, ok := op.(ResultOperation); !ok {
+			t.Errorf("%s does not implement ResultOperation", name)
+		}
+	}
+}
+
+func TestUnknownOperation(t *testing.T) {
+	c := newTestClient(t, "http://127.0.0.1:0/", "", &fakeAuthenticator{})
+	_, err := c.PerformOperationWithResult("noSuchOperation")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestNoAuthenticator(t *testing.T) {
+	api := newStandInAPI(t, http.StatusOK, `{}`)
+	c := newTestClient(t, api.URL, "", nil)
+	if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err == nil {
+		t.Error("an operation is performed without EndorseClient and Authenticator")
+	}
+}
+
+func FuzzRequestParameters(f *testing.F) {
+	f.Add(`{"endpoint":"my-device"}`)
+	f.Add(`{"endpoint":1}`)
+	f.Add(`{"operationId":"op-1","nested":{"a":[1,2]}}`)
+	f.Add(`[]`)
+	f.Add(`null`)
+	f.Add(`{`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, params string) {
+		c := &Client{cfg: &Config{RequestParameters: params}}
+		m, err := c.requestParameters()
+		if err != nil {
+			if Category(err) != ErrorCategoryInvalidParameters {
+				t.Errorf("unexpected category: %s", Category(err))
+			}
+			return
+		}
+		if params == "" && m != nil {
+			t.Errorf("expected no parameters but got %v", m)
+		}
+
+		v, err := c.getValueFromRequestParameterOption("endpoint")
+		if err != nil {
+			if Category(err) != ErrorCategoryInvalidParameters {
+				t.Errorf("unexpected category: %s", Category(err))
+			}
+			return
+		}
+		if v != m["endpoint"] {
+			t.Errorf("unexpected value: %v", v)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package krypton
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+)
+
+const (
+	// RecordedExchangeTypeAuthentication is the type of RecordedExchange for a SIM authentication.
+	RecordedExchangeTypeAuthentication = "authentication"
+	// RecordedExchangeTypeRequest is the type of RecordedExchange for a request to the provisioning API.
+	RecordedExchangeTypeRequest = "request"
+)
+
+// RecordedExchange is a SIM authentication or a request to the provisioning API recorded by Recorder.
+// CK is never recorded, and values of SensitiveFields in the bodies are replaced with RedactedValue unless
+// Recorder.ShowSecrets is set.
+type RecordedExchange struct {
+	Type      string    `json:"type"`
+	Operation string    `json:"operation,omitempty"`
+	Time      time.Time `json:"time"`
+	KeyID     string    `json:"keyId,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	// RequestBody is the JSON body of the request.
+	RequestBody json.RawMessage `json:"requestBody,omitempty"`
+	StatusCode  int             `json:"statusCode,omitempty"`
+	// ResponseBody is set when the response body is JSON, otherwise ResponseText is set.
+	ResponseBody json.RawMessage `json:"responseBody,omitempty"`
+	ResponseText string          `json:"responseText,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// Recorder is an Authenticator which records every authentication and request of the underlying Authenticator
+// as a numbered JSON file in Dir, so that the exchanges can be played back later by Replayer.
+type Recorder struct {
+	Authenticator Authenticator
+	Dir           string
+	// Operation is recorded with each exchange so that Replayer can pick the exchanges of an operation.
+	Operation string
+	// ShowSecrets disables redaction of sensitive fields in the recorded bodies.
+	ShowSecrets bool
+	// TracingTransport propagates the context to the requests of Authenticator when it does not implement
+	// ContextAuthenticator. See Config.TracingTransport.
+	TracingTransport *TracingTransport
+
+	mu sync.Mutex
+}
+
+// NewRecorder creates a Recorder which records exchanges of a to dir. dir is created if it does not exist.
+func NewRecorder(a Authenticator, dir string) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the directory for recording")
+	}
+	return &Recorder{Authenticator: a, Dir: dir}, nil
+}
+
+func (r *Recorder) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	ar, err := r.Authenticator.DoAuthentication()
+	e := &RecordedExchange{Type: RecordedExchangeTypeAuthentication}
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.KeyID = ar.KeyID
+	}
+	recErr := r.record(e)
+	if recErr != nil {
+		return nil, recErr
+	}
+	return ar, err
+}
+
+func (r *Recorder) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	return r.PostWithSignatureContext(context.Background(), u, ck, body)
+}
+
+// PostWithSignatureContext implements ContextAuthenticator. ctx is passed to the underlying Authenticator if it supports it.
+func (r *Recorder) PostWithSignatureContext(ctx context.Context, u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	e := &RecordedExchange{Type: RecordedExchangeTypeRequest, Path: u.Path}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	e.RequestBody = r.sanitize(b)
+
+	resp, err := postWithSignature(ctx, r.Authenticator, r.TracingTransport, u, ck, body)
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		respBody, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		e.StatusCode = resp.StatusCode
+		if json.Valid(respBody) {
+			e.ResponseBody = r.sanitize(respBody)
+		} else {
+			e.ResponseText = r.redact(string(respBody))
+		}
+	}
+
+	recErr := r.record(e)
+	if recErr != nil {
+		return nil, recErr
+	}
+	return resp, err
+}
+
+func (r *Recorder) redact(s string) string {
+	if r.ShowSecrets {
+		return s
+	}
+	return RedactSecrets(s)
+}
+
+func (r *Recorder) sanitize(b []byte) json.RawMessage {
+	return json.RawMessage(r.redact(string(b)))
+}
+
+func (r *Recorder) record(e *RecordedExchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Operation = r.Operation
+	e.Time = time.Now().UTC()
+	if e.Error != "" {
+		e.Error = r.redact(e.Error)
+	}
+
+	files, err := recordedFiles(r.Dir)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(r.Dir, fmt.Sprintf("%04d-%s.json", len(files)+1, e.Type))
+	err = os.WriteFile(name, append(b, '\n'), 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to record the exchange")
+	}
+	return nil
+}
+
+func recordedFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "[0-9][0-9][0-9][0-9]-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReplayCK is the CK returned by authentications played back by Replayer. CK is never recorded, so keys derived
+// from CK (e.g. the application key for SORACOM Inventory) differ from the recorded ones.
+var ReplayCK = make([]byte, 16)
+
+// Replayer is an Authenticator which plays back exchanges recorded by Recorder in the recorded order, without a SIM
+// or access to the network.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []*RecordedExchange
+	next      int
+}
+
+// NewReplayer loads the exchanges recorded in dir. If operation is not empty, only the exchanges of the operation
+// are played back.
+func NewReplayer(dir, operation string) (*Replayer, error) {
+	files, err := recordedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := []*RecordedExchange{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var e RecordedExchange
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse recorded exchange %s", f)
+		}
+		if operation != "" && e.Operation != operation {
+			continue
+		}
+		exchanges = append(exchanges, &e)
+	}
+	if len(exchanges) == 0 {
+		return nil, errors.Errorf("no recorded exchanges found in %s", dir)
+	}
+	return &Replayer{exchanges: exchanges}, nil
+}
+
+// Remaining returns the number of exchanges not played back yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.exchanges) - r.next
+}
+
+// replayError is categorized explicitly so that it is not taken for an authentication or a network error.
+func replayError(format string, args ...interface{}) error {
+	return &Error{Category: ErrorCategoryUnknown, Err: errors.Errorf(format, args...)}
+}
+
+func (r *Replayer) pop(typ string) (*RecordedExchange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.exchanges) {
+		return nil, replayError("no more recorded exchanges to replay %s", typ)
+	}
+	e := r.exchanges[r.next]
+	if e.Type != typ {
+		return nil, replayError("replay mismatch: %s is performed but %s is recorded", typ, e.Type)
+	}
+	r.next++
+	return e, nil
+}
+
+func (r *Replayer) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	e, err := r.pop(RecordedExchangeTypeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	return &endorse.AuthenticationResult{KeyID: e.KeyID, CK: ReplayCK}, nil
+}
+
+func (r *Replayer) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	e, err := r.pop(RecordedExchangeTypeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if e.Path != u.Path {
+		return nil, replayError("replay mismatch: request to %s is sent but %s is recorded", u.Path, e.Path)
+	}
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+
+	// recorded JSON bodies are indented in the files
+	respBody := e.ResponseText
+	if e.ResponseBody != nil {
+		var buf bytes.Buffer
+		err = json.Compact(&buf, e.ResponseBody)
+		if err != nil {
+			return nil, err
+		}
+		respBody = buf.String()
+	}
+	req, _ := http.NewRequest(http.MethodPost, u.String(), nil)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
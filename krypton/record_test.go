@@ -0,0 +1,105 @@
+package krypton
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	response := `{"credentials":{"accessKeyId":"AKIA","secretAccessKey":"secret-value"},"region":"ap-northeast-1"}`
+	api := newStandInAPI(t, http.StatusOK, response)
+
+	rec, err := NewRecorder(&fakeAuthenticator{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Operation = "generateAmazonCognitoSessionCredentials"
+	c := newTestClient(t, api.URL, `{"token":"user-secret"}`, rec)
+	_, err = c.PerformOperationWithResult("generateAmazonCognitoSessionCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestClient(t, api.URL, "", &Recorder{Authenticator: &fakeAuthenticator{err: errors.New("authentication failed")}, Dir: dir, Operation: "getUserData"}).PerformOperationWithResult("getUserData")
+	if Category(err) != ErrorCategoryAuthentication {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	expected := []string{"0001-authentication.json", "0002-request.json", "0003-authentication.json"}
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	for i, f := range files {
+		if filepath.Base(f) != expected[i] {
+			t.Errorf("expected %s but got %s", expected[i], filepath.Base(f))
+		}
+		b, _ := os.ReadFile(f)
+		for _, secret := range []string{"secret-value", "user-secret", "1011121314"} {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s contains a secret: %s", f, b)
+			}
+		}
+	}
+
+	r, err := NewReplayer(dir, "generateAmazonCognitoSessionCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = newTestClient(t, "http://127.0.0.1:0/", "", r)
+	result, err := c.PerformOperationWithResult("generateAmazonCognitoSessionCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"credentials":{"accessKeyId":"AKIA","secretAccessKey":"********"},"region":"ap-northeast-1"}` {
+		t.Errorf("unexpected replayed result: %s", result)
+	}
+	if r.Remaining() != 0 {
+		t.Errorf("%d exchanges remain", r.Remaining())
+	}
+
+	r, err = NewReplayer(dir, "getUserData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestClient(t, "http://127.0.0.1:0/", "", r).PerformOperationWithResult("getUserData")
+	if Category(err) != ErrorCategoryAuthentication || err.Error() != "authentication failed" {
+		t.Errorf("recorded error is not replayed: %v", err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	dir := t.TempDir()
+	api := newStandInAPI(t, http.StatusOK, `{}`)
+	rec, err := NewRecorder(&fakeAuthenticator{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestClient(t, api.URL, "", rec).PerformOperationWithResult("getUserData")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReplayer(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestClient(t, "http://127.0.0.1:0/", "", r).PerformOperationWithResult("getSubscriberMetadata")
+	if err == nil || !strings.Contains(err.Error(), "replay mismatch") {
+		t.Errorf("expected a mismatch error but got %v", err)
+	}
+	if Category(err) != ErrorCategoryUnknown {
+		t.Errorf("unexpected category: %s", Category(err))
+	}
+
+	_, err = NewReplayer(t.TempDir(), "")
+	if err == nil {
+		t.Error("expected an error for an empty directory")
+	}
+}
@@ -0,0 +1,121 @@
+package krypton
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RedactedValue replaces values of sensitive fields in log messages.
+const RedactedValue = "********"
+
+// SensitiveFields is the list of field names whose values are redacted from log messages.
+// A field matches when its name ends with one of them, ignoring case and the difference of
+// camelCase and snake_case (e.g. "privateKey" matches "privateKey", "PRIVATE_KEY" and "awsPrivateKey").
+var SensitiveFields = []string{
+	"privateKey",
+	"secretAccessKey",
+	"sessionToken",
+	"applicationKey",
+	"psk",
+	"token",
+	"ck",
+}
+
+var (
+	// "privateKey": "...", "token": null
+	jsonFieldPattern = regexp.MustCompile(`"([A-Za-z0-9_\-]+)"(\s*:\s*)("(?:[^"\\]|\\.)*"|[^\s,{}\[\]"]+)`)
+	// privateKey=..., SESSION_TOKEN='...'
+	keyValuePattern = regexp.MustCompile(`\b([A-Za-z][A-Za-z0-9_]*)(\s*=\s*)('[^']*'|"(?:[^"\\]|\\.)*"|[^\s,&]+)`)
+)
+
+// RedactSecrets replaces values of SensitiveFields in s with RedactedValue.
+// Both JSON (`"privateKey":"..."`) and key=value (`privateKey=...`) forms are recognized.
+func RedactSecrets(s string) string {
+	s = redactMatches(jsonFieldPattern, s, `"`+RedactedValue+`"`)
+	s = redactMatches(keyValuePattern, s, RedactedValue)
+	return s
+}
+
+func redactMatches(re *regexp.Regexp, s, replacement string) string {
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		sm := re.FindStringSubmatch(m)
+		if !IsSensitiveField(sm[1]) {
+			return m
+		}
+		return strings.TrimSuffix(m, sm[3]) + replacement
+	})
+}
+
+// IsSensitiveField reports whether the value of the field named name is redacted, see SensitiveFields.
+func IsSensitiveField(name string) bool {
+	words := splitWords(name)
+	for _, f := range SensitiveFields {
+		fw := splitWords(f)
+		if len(fw) > len(words) {
+			continue
+		}
+		if strings.Join(words[len(words)-len(fw):], "_") == strings.Join(fw, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords splits camelCase, snake_case and kebab-case names into lower-cased words.
+func splitWords(name string) []string {
+	words := []string{}
+	var cur []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			if len(cur) > 0 {
+				words = append(words, string(cur))
+				cur = nil
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]) && len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = nil
+		}
+		cur = append(cur, unicode.ToLower(r))
+	}
+	if len(cur) > 0 {
+		words = append(words, string(cur))
+	}
+	return words
+}
+
+// RedactArgs returns a copy of log arguments in which strings, byte slices, errors and fmt.Stringers are redacted by RedactSecrets.
+func RedactArgs(args []interface{}) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			result[i] = RedactSecrets(v)
+		case []byte:
+			result[i] = RedactSecrets(string(v))
+		case error:
+			result[i] = RedactSecrets(v.Error())
+		case fmt.Stringer:
+			result[i] = RedactSecrets(v.String())
+		default:
+			result[i] = arg
+		}
+	}
+	return result
+}
+
+// RedactKeysAndValues returns a copy of alternating keys and values in which the values of SensitiveFields are replaced
+// with RedactedValue, and the other values are redacted by RedactArgs.
+func RedactKeysAndValues(keysAndValues []interface{}) []interface{} {
+	result := RedactArgs(keysAndValues)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if k, ok := keysAndValues[i].(string); ok && IsSensitiveField(k) {
+			result[i+1] = RedactedValue
+		}
+	}
+	return result
+}
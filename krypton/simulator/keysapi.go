@@ -0,0 +1,245 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/endorse-client-go/endorse"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// Paths of the simulator-only Keys API handshake relative to the endpoint URL. They are not the paths of the
+// SORACOM Keys API.
+const (
+	// KeysPath starts the authentication of a SIM with InitiateRequest, and returns InitiateResponse.
+	KeysPath = "/v1/keys"
+	// VerifyPathFormat verifies RES for the key ID with VerifyRequest, and returns VerifyResponse.
+	VerifyPathFormat = "/v1/keys/%s/verify"
+)
+
+// Headers of the requests signed with CK by the simulator. The key ID is in the JSON body as keyId.
+// They are understood only by keysapitest, not by the SORACOM provisioning API.
+const (
+	SignatureAlgorithmHeader = "X-Soracom-Endorse-Signature-Algorithm"
+	SignatureHeader          = "X-Soracom-Endorse-Signature"
+)
+
+// StandInHeader is set in every response of a stand-in which speaks the simulator-only protocol, such as keysapitest.
+// Authenticator refuses responses without it, so that a simulated SIM never answers challenges from the SORACOM Keys API
+// or any other server which does not speak the protocol.
+const StandInHeader = "X-Krypton-Keys-API-Stand-In"
+
+// DefaultSignatureAlgorithm is used when Config.SignatureAlgorithm is not specified.
+const DefaultSignatureAlgorithm = "SHA-256"
+
+// InitiateRequest starts the authentication. Resync is set to resynchronise SQN after a synchronisation failure.
+type InitiateRequest struct {
+	IMSI   string  `json:"imsi"`
+	Resync *Resync `json:"resync,omitempty"`
+}
+
+// Resync is the challenge which caused a synchronisation failure and AUTS, hex encoded.
+type Resync struct {
+	Rand string `json:"rand"`
+	AUTS string `json:"auts"`
+}
+
+// InitiateResponse is the challenge for the SIM, hex encoded.
+type InitiateResponse struct {
+	KeyID string `json:"keyId"`
+	Rand  string `json:"rand"`
+	AUTN  string `json:"autn"`
+}
+
+// VerifyRequest is the response of the SIM to the challenge, hex encoded.
+type VerifyRequest struct {
+	RES string `json:"res"`
+}
+
+// VerifyResponse is returned when RES is correct. CK of the SIM is valid for KeyID until ExpiresAt.
+type VerifyResponse struct {
+	KeyID     string    `json:"keyId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func signatureHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "SHA-256":
+		return sha256.New, nil
+	case "SHA-384":
+		return sha512.New384, nil
+	case "SHA-512":
+		return sha512.New, nil
+	}
+	return nil, errors.Errorf("unsupported signature algorithm: %s", algorithm)
+}
+
+// Sign returns the hex encoded HMAC of body with CK, using the hash function of algorithm (SHA-256, SHA-384 or SHA-512).
+// It is the simulator-only signature sent in SignatureHeader.
+func Sign(algorithm string, ck, body []byte) (string, error) {
+	h, err := signatureHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(h, ck)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Config is the configuration of Authenticator.
+type Config struct {
+	// KeysAPIEndpointURL is the endpoint which knows the keys of the simulated SIM, e.g. a local stand-in.
+	KeysAPIEndpointURL *url.URL
+	// SignatureAlgorithm is the algorithm to sign requests. (default: DefaultSignatureAlgorithm)
+	SignatureAlgorithm string
+	// HTTPClient is used for the requests. (default: a client with 30 seconds timeout)
+	HTTPClient *http.Client
+}
+
+// Authenticator authenticates the simulated SIM with the Keys API and signs requests with CK.
+// It implements krypton.ContextAuthenticator and krypton.IMSIReader.
+type Authenticator struct {
+	sim *SIM
+	cfg Config
+}
+
+var (
+	_ krypton.ContextAuthenticator = (*Authenticator)(nil)
+	_ krypton.IMSIReader           = (*Authenticator)(nil)
+)
+
+// NewAuthenticator returns an Authenticator for sim.
+func NewAuthenticator(sim *SIM, cfg *Config) (*Authenticator, error) {
+	if cfg.KeysAPIEndpointURL == nil {
+		return nil, errors.New("Keys API endpoint URL must be specified for the simulated SIM")
+	}
+	a := &Authenticator{sim: sim, cfg: *cfg}
+	if a.cfg.SignatureAlgorithm == "" {
+		a.cfg.SignatureAlgorithm = DefaultSignatureAlgorithm
+	}
+	if _, err := signatureHash(a.cfg.SignatureAlgorithm); err != nil {
+		return nil, err
+	}
+	if a.cfg.HTTPClient == nil {
+		a.cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return a, nil
+}
+
+// ReadIMSI implements krypton.IMSIReader.
+func (a *Authenticator) ReadIMSI() (string, error) {
+	return a.sim.IMSI(), nil
+}
+
+// DoAuthentication implements krypton.Authenticator.
+func (a *Authenticator) DoAuthentication() (*endorse.AuthenticationResult, error) {
+	req := &InitiateRequest{IMSI: a.sim.IMSI()}
+	var ar *AuthenticationResponse
+	var challenge InitiateResponse
+	// retry once after resynchronising SQN
+	for i := 0; i < 2; i++ {
+		err := a.post(KeysPath, req, &challenge)
+		if err != nil {
+			return nil, err
+		}
+		rand, err := hex.DecodeString(challenge.Rand)
+		if err != nil {
+			return nil, &krypton.Error{Category: krypton.ErrorCategoryServer, Err: errors.Wrap(err, "invalid rand in the challenge")}
+		}
+		autn, err := hex.DecodeString(challenge.AUTN)
+		if err != nil {
+			return nil, &krypton.Error{Category: krypton.ErrorCategoryServer, Err: errors.Wrap(err, "invalid autn in the challenge")}
+		}
+
+		ar, err = a.sim.Authenticate(rand, autn)
+		var sf *SyncFailureError
+		if errors.As(err, &sf) && req.Resync == nil {
+			req.Resync = &Resync{Rand: challenge.Rand, AUTS: hex.EncodeToString(sf.AUTS)}
+			continue
+		}
+		if err != nil {
+			return nil, &krypton.Error{Category: krypton.ErrorCategoryAuthentication, Err: err}
+		}
+		break
+	}
+	if ar == nil {
+		return nil, &krypton.Error{Category: krypton.ErrorCategoryAuthentication, Err: errors.New("synchronisation failure persisted after resynchronisation")}
+	}
+
+	var verified VerifyResponse
+	err := a.post(fmt.Sprintf(VerifyPathFormat, url.PathEscape(challenge.KeyID)), &VerifyRequest{RES: hex.EncodeToString(ar.RES)}, &verified)
+	if err != nil {
+		return nil, err
+	}
+	return &endorse.AuthenticationResult{KeyID: challenge.KeyID, CK: ar.CK}, nil
+}
+
+func (a *Authenticator) post(path string, body, result interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := a.cfg.HTTPClient.Post(a.cfg.KeysAPIEndpointURL.JoinPath(path).String(), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return &krypton.Error{Category: krypton.ErrorCategoryNetwork, Err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &krypton.Error{Category: krypton.ErrorCategoryNetwork, Err: err}
+	}
+	if resp.Header.Get(StandInHeader) == "" {
+		return &krypton.Error{Category: krypton.ErrorCategoryServer, StatusCode: resp.StatusCode, Err: errors.Errorf("%s is not a stand-in of the Keys API for simulated SIMs such as keysapitest", a.cfg.KeysAPIEndpointURL)}
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		category := krypton.ErrorCategoryAuthentication
+		if resp.StatusCode >= http.StatusInternalServerError {
+			category = krypton.ErrorCategoryServer
+		}
+		return &krypton.Error{Category: category, StatusCode: resp.StatusCode, Err: errors.Errorf("Keys API returned %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))}
+	}
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return &krypton.Error{Category: krypton.ErrorCategoryServer, StatusCode: resp.StatusCode, Err: errors.Wrap(err, "unable to parse the response from the Keys API")}
+	}
+	return nil
+}
+
+// PostWithSignature implements krypton.Authenticator. The JSON body is signed with ck by Sign, which only
+// keysapitest verifies.
+func (a *Authenticator) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	return a.PostWithSignatureContext(context.Background(), u, ck, body)
+}
+
+// PostWithSignatureContext implements krypton.ContextAuthenticator.
+func (a *Authenticator) PostWithSignatureContext(ctx context.Context, u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := Sign(a.cfg.SignatureAlgorithm, ck, b)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureAlgorithmHeader, a.cfg.SignatureAlgorithm)
+	req.Header.Set(SignatureHeader, sig)
+	return a.cfg.HTTPClient.Do(req)
+}
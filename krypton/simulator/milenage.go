@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/pkg/errors"
+)
+
+// Lengths of the parameters of Milenage (3GPP TS 35.206) in bytes
+const (
+	KeyLength  = 16
+	RandLength = 16
+	SQNLength  = 6
+	AMFLength  = 2
+	AUTNLength = SQNLength + AMFLength + 8
+)
+
+// Milenage computes the 3GPP authentication functions f1-f5 and f1*, f5* for a subscriber key K and OPc.
+type Milenage struct {
+	block cipher.Block
+	opc   []byte
+}
+
+// NewMilenage returns Milenage for the subscriber key k and opc.
+func NewMilenage(k, opc []byte) (*Milenage, error) {
+	if len(k) != KeyLength {
+		return nil, errors.Errorf("K must be %d bytes", KeyLength)
+	}
+	if len(opc) != KeyLength {
+		return nil, errors.Errorf("OPc must be %d bytes", KeyLength)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return &Milenage{block: block, opc: append([]byte{}, opc...)}, nil
+}
+
+// ComputeOPc derives OPc from the subscriber key k and the operator variant algorithm configuration field op.
+func ComputeOPc(k, op []byte) ([]byte, error) {
+	if len(k) != KeyLength {
+		return nil, errors.Errorf("K must be %d bytes", KeyLength)
+	}
+	if len(op) != KeyLength {
+		return nil, errors.Errorf("OP must be %d bytes", KeyLength)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	opc := make([]byte, KeyLength)
+	block.Encrypt(opc, op)
+	xor(opc, opc, op)
+	return opc, nil
+}
+
+func xor(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// rotate returns x cyclically rotated left by n bytes.
+func rotate(x []byte, n int) []byte {
+	out := make([]byte, len(x))
+	for i := range x {
+		out[i] = x[(i+n)%len(x)]
+	}
+	return out
+}
+
+// temp returns E_K(RAND xor OPc).
+func (m *Milenage) temp(rand []byte) []byte {
+	t := make([]byte, KeyLength)
+	xor(t, rand, m.opc)
+	m.block.Encrypt(t, t)
+	return t
+}
+
+// out returns OUTn = E_K(rot(TEMP xor OPc, r) xor c) xor OPc for n = 2..5, where c has the bit of n-2 set at the end.
+func (m *Milenage) out(temp []byte, r int, c byte) []byte {
+	x := make([]byte, KeyLength)
+	xor(x, temp, m.opc)
+	x = rotate(x, r)
+	x[KeyLength-1] ^= c
+	m.block.Encrypt(x, x)
+	xor(x, x, m.opc)
+	return x
+}
+
+// F1 returns the network authentication code MAC-A (f1) and the resynchronisation authentication code MAC-S (f1*).
+func (m *Milenage) F1(rand, sqn, amf []byte) (macA, macS []byte, err error) {
+	if len(rand) != RandLength || len(sqn) != SQNLength || len(amf) != AMFLength {
+		return nil, nil, errors.New("invalid length of RAND, SQN or AMF")
+	}
+	temp := m.temp(rand)
+
+	in1 := make([]byte, 0, KeyLength)
+	in1 = append(in1, sqn...)
+	in1 = append(in1, amf...)
+	in1 = append(in1, sqn...)
+	in1 = append(in1, amf...)
+
+	// OUT1 = E_K(TEMP xor rot(IN1 xor OPc, r1) xor c1) xor OPc, where r1 = 64 bits and c1 = 0
+	x := make([]byte, KeyLength)
+	xor(x, in1, m.opc)
+	x = rotate(x, 8)
+	xor(x, x, temp)
+	m.block.Encrypt(x, x)
+	xor(x, x, m.opc)
+	return x[:8], x[8:], nil
+}
+
+// F2345 returns the response RES (f2), the cipher key CK (f3), the integrity key IK (f4) and the anonymity key AK (f5).
+func (m *Milenage) F2345(rand []byte) (res, ck, ik, ak []byte, err error) {
+	if len(rand) != RandLength {
+		return nil, nil, nil, nil, errors.Errorf("RAND must be %d bytes", RandLength)
+	}
+	temp := m.temp(rand)
+	out2 := m.out(temp, 0, 1)
+	out3 := m.out(temp, 4, 2)
+	out4 := m.out(temp, 8, 4)
+	return out2[8:], out3, out4, out2[:SQNLength], nil
+}
+
+// F5Star returns the anonymity key AK used for resynchronisation (f5*).
+func (m *Milenage) F5Star(rand []byte) ([]byte, error) {
+	if len(rand) != RandLength {
+		return nil, errors.Errorf("RAND must be %d bytes", RandLength)
+	}
+	return m.out(m.temp(rand), 12, 8)[:SQNLength], nil
+}
+
+// GenerateAUTN returns the authentication token AUTN = SQN xor AK || AMF || MAC-A for the challenge rand.
+func (m *Milenage) GenerateAUTN(rand, sqn, amf []byte) ([]byte, error) {
+	macA, _, err := m.F1(rand, sqn, amf)
+	if err != nil {
+		return nil, err
+	}
+	_, _, _, ak, err := m.F2345(rand)
+	if err != nil {
+		return nil, err
+	}
+	autn := make([]byte, SQNLength, AUTNLength)
+	xor(autn, sqn, ak)
+	autn = append(autn, amf...)
+	return append(autn, macA...), nil
+}
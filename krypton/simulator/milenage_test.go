@@ -0,0 +1,120 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// test sets from 3GPP TS 35.208
+func TestMilenage(t *testing.T) {
+	tests := []struct {
+		name                                string
+		k, rand, sqn, amf, op, opc          string
+		macA, macS, res, ck, ik, ak, akStar string
+	}{
+		{
+			name: "test set 1",
+			k:    "465b5ce8b199b49faa5f0a2ee238a6bc", rand: "23553cbe9637a89d218ae64dae47bf35",
+			sqn: "ff9bb4d0b607", amf: "b9b9",
+			op: "cdc202d5123e20f62b6d676ac72cb318", opc: "cd63cb71954a9f4e48a5994e37a02baf",
+			macA: "4a9ffac354dfafb3", macS: "01cfaf9ec4e871e9", res: "a54211d5e3ba50bf",
+			ck: "b40ba9a3c58b2a05bbf0d987b21bf8cb", ik: "f769bcd751044604127672711c6d3441",
+			ak: "aa689c648370", akStar: "451e8beca43b",
+		},
+		{
+			name: "test set 2",
+			k:    "fec86ba6eb707ed08905757b1bb44b8f", rand: "9f7c8d021accf4db213ccff0c7f71a6a",
+			sqn: "9d0277595ffc", amf: "725c",
+			op: "dbc59adcb6f9a0ef735477b7fadf8374", opc: "1006020f0a478bf6b699f15c062e42b3",
+			macA: "9cabc3e99baf7281", macS: "95814ba2b3044324", res: "8011c48c0c214ed2",
+			ck: "5dbdbb2954e8f3cde665b046179a5098", ik: "59a92d3b476a0443487055cf88b2307b",
+			ak: "33484dc2136b", akStar: "deacdd848cc6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := unhex(t, tt.k)
+			opc, err := ComputeOPc(k, unhex(t, tt.op))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(opc) != tt.opc {
+				t.Errorf("OPc: expected %s, got %x", tt.opc, opc)
+			}
+
+			m, err := NewMilenage(k, opc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rand := unhex(t, tt.rand)
+			macA, macS, err := m.F1(rand, unhex(t, tt.sqn), unhex(t, tt.amf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, ck, ik, ak, err := m.F2345(rand)
+			if err != nil {
+				t.Fatal(err)
+			}
+			akStar, err := m.F5Star(rand)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, v := range []struct {
+				name     string
+				expected string
+				actual   []byte
+			}{
+				{"MAC-A", tt.macA, macA},
+				{"MAC-S", tt.macS, macS},
+				{"RES", tt.res, res},
+				{"CK", tt.ck, ck},
+				{"IK", tt.ik, ik},
+				{"AK", tt.ak, ak},
+				{"AK*", tt.akStar, akStar},
+			} {
+				if hex.EncodeToString(v.actual) != v.expected {
+					t.Errorf("%s: expected %s, got %x", v.name, v.expected, v.actual)
+				}
+			}
+
+			autn, err := m.GenerateAUTN(rand, unhex(t, tt.sqn), unhex(t, tt.amf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(autn[SQNLength+AMFLength:], macA) || !bytes.Equal(autn[SQNLength:SQNLength+AMFLength], unhex(t, tt.amf)) {
+				t.Errorf("unexpected AUTN: %x", autn)
+			}
+		})
+	}
+}
+
+func TestMilenageInvalidLength(t *testing.T) {
+	if _, err := NewMilenage(make([]byte, 15), make([]byte, 16)); err == nil {
+		t.Error("short K was accepted")
+	}
+	if _, err := NewMilenage(make([]byte, 16), make([]byte, 17)); err == nil {
+		t.Error("long OPc was accepted")
+	}
+	m, err := NewMilenage(make([]byte, 16), make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = m.F1(make([]byte, 16), make([]byte, 5), make([]byte, 2)); err == nil {
+		t.Error("short SQN was accepted")
+	}
+	if _, _, _, _, err = m.F2345(make([]byte, 8)); err == nil {
+		t.Error("short RAND was accepted")
+	}
+}
@@ -0,0 +1,188 @@
+// Package simulator provides a software UICC which answers authentication challenges with Milenage, and an
+// Authenticator which performs the Keys API handshake with it, so that krypton can be used without a physical SIM.
+// The SORACOM Keys API does not know the keys of simulated SIMs, so the Authenticator is meant to be used with a
+// local stand-in which implements the handshake in this package.
+//
+// The handshake with the Keys API (KeysPath, VerifyPathFormat and their bodies) and the signature of the requests
+// (SignatureAlgorithmHeader, SignatureHeader and Sign) are defined by this package for the simulator and the stand-in
+// in keysapitest only. They are not the wire format of the SORACOM Keys API used by endorse-client-go, and a
+// provisioning API which accepts requests signed by endorse-client-go does not accept them.
+package simulator
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMACFailure is returned when the challenge was not generated with the key of the SIM.
+var ErrMACFailure = errors.New("MAC failure: AUTN is not generated with the key of the SIM")
+
+// SIMConfig is the subscription of a simulated SIM. Keys are hex encoded in JSON.
+type SIMConfig struct {
+	IMSI string `json:"imsi"`
+	K    string `json:"k"`
+	// Either OPc or OP must be specified
+	OPc string `json:"opc,omitempty"`
+	OP  string `json:"op,omitempty"`
+}
+
+// LoadSIMConfig reads SIMConfig from the JSON file at path.
+func LoadSIMConfig(path string) (*SIMConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the SIM config")
+	}
+	var cfg SIMConfig
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse the SIM config %s", path)
+	}
+	return &cfg, nil
+}
+
+// Milenage returns Milenage with K and OPc (or OP) of the subscription.
+func (cfg *SIMConfig) Milenage() (*Milenage, error) {
+	k, err := hex.DecodeString(cfg.K)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid k in the SIM config")
+	}
+
+	var opc []byte
+	switch {
+	case cfg.OPc != "" && cfg.OP != "":
+		return nil, errors.New("only one of opc or op can be specified in the SIM config")
+	case cfg.OPc != "":
+		opc, err = hex.DecodeString(cfg.OPc)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid opc in the SIM config")
+		}
+	case cfg.OP != "":
+		op, err := hex.DecodeString(cfg.OP)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid op in the SIM config")
+		}
+		opc, err = ComputeOPc(k, op)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("opc or op must be specified in the SIM config")
+	}
+	return NewMilenage(k, opc)
+}
+
+// SIM is a simulated UICC.
+type SIM struct {
+	imsi     string
+	milenage *Milenage
+
+	mu sync.Mutex
+	// lastSQN is the highest SQN accepted so far
+	lastSQN []byte
+}
+
+// NewSIM returns a SIM with the subscription in cfg.
+func NewSIM(cfg *SIMConfig) (*SIM, error) {
+	if cfg.IMSI == "" {
+		return nil, errors.New("imsi must be specified in the SIM config")
+	}
+	for _, c := range cfg.IMSI {
+		if c < '0' || c > '9' {
+			return nil, errors.Errorf("invalid IMSI: %s", cfg.IMSI)
+		}
+	}
+	m, err := cfg.Milenage()
+	if err != nil {
+		return nil, err
+	}
+	return &SIM{imsi: cfg.IMSI, milenage: m}, nil
+}
+
+// IMSI returns the IMSI of the SIM.
+func (s *SIM) IMSI() string {
+	return s.imsi
+}
+
+// AuthenticationResponse is the result of the AUTHENTICATE command.
+type AuthenticationResponse struct {
+	RES []byte
+	CK  []byte
+	IK  []byte
+}
+
+// SyncFailureError is returned when SQN in AUTN is not fresh. AUTS is for the network to resynchronise SQN.
+type SyncFailureError struct {
+	AUTS []byte
+}
+
+func (e *SyncFailureError) Error() string {
+	return "synchronisation failure: SQN is not fresh"
+}
+
+// Authenticate verifies the challenge rand and autn as the AUTHENTICATE command of USIM does.
+// It returns ErrMACFailure if AUTN is not genuine, or *SyncFailureError if SQN is not greater than the one accepted
+// last time. SQN is kept only in memory, so a new SIM accepts any SQN.
+func (s *SIM) Authenticate(rand, autn []byte) (*AuthenticationResponse, error) {
+	if len(autn) != AUTNLength {
+		return nil, errors.Errorf("AUTN must be %d bytes", AUTNLength)
+	}
+	res, ck, ik, ak, err := s.milenage.F2345(rand)
+	if err != nil {
+		return nil, err
+	}
+	sqn := make([]byte, SQNLength)
+	xor(sqn, autn[:SQNLength], ak)
+	amf := autn[SQNLength : SQNLength+AMFLength]
+	macA, _, err := s.milenage.F1(rand, sqn, amf)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(macA, autn[SQNLength+AMFLength:]) != 1 {
+		return nil, ErrMACFailure
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastSQN != nil && compareSQN(sqn, s.lastSQN) <= 0 {
+		auts, err := s.auts(rand)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &SyncFailureError{AUTS: auts}
+	}
+	s.lastSQN = sqn
+	return &AuthenticationResponse{RES: res, CK: ck, IK: ik}, nil
+}
+
+// auts returns AUTS = SQN_MS xor AK* || MAC-S. It must be called with s.mu held.
+func (s *SIM) auts(rand []byte) ([]byte, error) {
+	akStar, err := s.milenage.F5Star(rand)
+	if err != nil {
+		return nil, err
+	}
+	// the resynchronisation message uses the dummy AMF of all zeros
+	_, macS, err := s.milenage.F1(rand, s.lastSQN, make([]byte, AMFLength))
+	if err != nil {
+		return nil, err
+	}
+	auts := make([]byte, SQNLength, SQNLength+len(macS))
+	xor(auts, s.lastSQN, akStar)
+	return append(auts, macS...), nil
+}
+
+func compareSQN(a, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
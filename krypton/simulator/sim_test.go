@@ -0,0 +1,300 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/soracom/krypton-client-go/krypton"
+)
+
+// subscription of the test set 1 of 3GPP TS 35.208
+var testSIMConfig = &SIMConfig{
+	IMSI: "001010000000001",
+	K:    "465b5ce8b199b49faa5f0a2ee238a6bc",
+	OPc:  "cd63cb71954a9f4e48a5994e37a02baf",
+}
+
+func newTestSIM(t *testing.T) *SIM {
+	t.Helper()
+	s, err := NewSIM(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestMilenage(t *testing.T) *Milenage {
+	t.Helper()
+	m, err := NewMilenage(unhex(t, testSIMConfig.K), unhex(t, testSIMConfig.OPc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSIMAuthenticate(t *testing.T) {
+	s := newTestSIM(t)
+	m := newTestMilenage(t)
+	rand := unhex(t, "23553cbe9637a89d218ae64dae47bf35")
+	sqn := unhex(t, "ff9bb4d0b607")
+	autn, err := m.GenerateAUTN(rand, sqn, unhex(t, "b9b9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Authenticate(rand, autn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(r.RES) != "a54211d5e3ba50bf" || hex.EncodeToString(r.CK) != "b40ba9a3c58b2a05bbf0d987b21bf8cb" {
+		t.Errorf("unexpected response: %x %x", r.RES, r.CK)
+	}
+
+	// the same SQN is not fresh any more
+	_, err = s.Authenticate(rand, autn)
+	var sf *SyncFailureError
+	if !errors.As(err, &sf) {
+		t.Fatalf("expected SyncFailureError, got %v", err)
+	}
+	akStar, err := m.F5Star(rand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqnMS := make([]byte, SQNLength)
+	xor(sqnMS, sf.AUTS[:SQNLength], akStar)
+	if !bytes.Equal(sqnMS, sqn) {
+		t.Errorf("unexpected SQN in AUTS: %x", sqnMS)
+	}
+	_, macS, err := m.F1(rand, sqnMS, make([]byte, AMFLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sf.AUTS[SQNLength:], macS) {
+		t.Errorf("unexpected MAC-S in AUTS: %x", sf.AUTS[SQNLength:])
+	}
+
+	// AUTN with a wrong MAC
+	autn[len(autn)-1] ^= 1
+	if _, err = s.Authenticate(rand, autn); err != ErrMACFailure {
+		t.Errorf("expected ErrMACFailure, got %v", err)
+	}
+}
+
+func TestNewSIM(t *testing.T) {
+	op := &SIMConfig{IMSI: testSIMConfig.IMSI, K: testSIMConfig.K, OP: "cdc202d5123e20f62b6d676ac72cb318"}
+	s, err := NewSIM(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.milenage.opc, unhex(t, testSIMConfig.OPc)) {
+		t.Errorf("unexpected OPc: %x", s.milenage.opc)
+	}
+
+	for _, cfg := range []*SIMConfig{
+		{K: testSIMConfig.K, OPc: testSIMConfig.OPc},
+		{IMSI: "44010abc", K: testSIMConfig.K, OPc: testSIMConfig.OPc},
+		{IMSI: testSIMConfig.IMSI, K: "zz", OPc: testSIMConfig.OPc},
+		{IMSI: testSIMConfig.IMSI, K: testSIMConfig.K},
+		{IMSI: testSIMConfig.IMSI, K: testSIMConfig.K, OPc: testSIMConfig.OPc, OP: op.OP},
+		{IMSI: testSIMConfig.IMSI, K: "465b5ce8", OPc: testSIMConfig.OPc},
+	} {
+		if _, err := NewSIM(cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestLoadSIMConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.json")
+	b, err := json.Marshal(testSIMConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, b, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadSIMConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cfg != *testSIMConfig {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if _, err = LoadSIMConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file was accepted")
+	}
+}
+
+// handshakeServer answers the Keys API handshake for the test SIM with the given SQNs in order.
+func handshakeServer(t *testing.T, sqns ...string) *httptest.Server {
+	m := newTestMilenage(t)
+	rand := unhex(t, "23553cbe9637a89d218ae64dae47bf35")
+	res, _, _, _, err := m.F2345(rand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(StandInHeader, "test")
+		switch r.URL.Path {
+		case KeysPath:
+			var req InitiateRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.IMSI != testSIMConfig.IMSI || len(sqns) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			autn, err := m.GenerateAUTN(rand, unhex(t, sqns[0]), []byte{0x80, 0})
+			if err != nil {
+				t.Error(err)
+			}
+			sqns = sqns[1:]
+			json.NewEncoder(w).Encode(&InitiateResponse{KeyID: "key/1", Rand: hex.EncodeToString(rand), AUTN: hex.EncodeToString(autn)})
+		case "/v1/keys/key%2F1/verify", "/v1/keys/key/1/verify":
+			var req VerifyRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.RES != hex.EncodeToString(res) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(&VerifyResponse{KeyID: "key/1"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestAuthenticator(t *testing.T, sim *SIM, endpoint string) *Authenticator {
+	t.Helper()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(sim, &Config{KeysAPIEndpointURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticator(t *testing.T) {
+	sim := newTestSIM(t)
+	s := handshakeServer(t, "000000000002", "000000000001", "000000000003")
+	a := newTestAuthenticator(t, sim, s.URL)
+
+	imsi, err := a.ReadIMSI()
+	if err != nil || imsi != testSIMConfig.IMSI {
+		t.Errorf("unexpected IMSI: %s, %v", imsi, err)
+	}
+
+	ar, err := a.DoAuthentication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.KeyID != "key/1" || hex.EncodeToString(ar.CK) != "b40ba9a3c58b2a05bbf0d987b21bf8cb" {
+		t.Errorf("unexpected result: %s %x", ar.KeyID, ar.CK)
+	}
+
+	// SQN 1 is older than 2, so the SIM reports a synchronisation failure and the authentication is retried with SQN 3
+	ar, err = a.DoAuthentication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.KeyID != "key/1" {
+		t.Errorf("unexpected key ID: %s", ar.KeyID)
+	}
+
+	// no more challenges
+	_, err = a.DoAuthentication()
+	if krypton.Category(err) != krypton.ErrorCategoryAuthentication {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestAuthenticatorWrongKey(t *testing.T) {
+	sim, err := NewSIM(&SIMConfig{IMSI: testSIMConfig.IMSI, K: strings.Repeat("00", KeyLength), OPc: testSIMConfig.OPc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuthenticator(t, sim, handshakeServer(t, "000000000001").URL)
+	_, err = a.DoAuthentication()
+	if !errors.Is(err, ErrMACFailure) || krypton.Category(err) != krypton.ErrorCategoryAuthentication {
+		t.Errorf("expected ErrMACFailure, got %v", err)
+	}
+}
+
+func TestAuthenticatorRequiresStandIn(t *testing.T) {
+	// the challenge is valid, but the server is not a stand-in
+	m := newTestMilenage(t)
+	rand := unhex(t, "23553cbe9637a89d218ae64dae47bf35")
+	autn, err := m.GenerateAUTN(rand, unhex(t, "000000000001"), []byte{0x80, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != KeysPath {
+			verified = true
+		}
+		json.NewEncoder(w).Encode(&InitiateResponse{KeyID: "key/1", Rand: hex.EncodeToString(rand), AUTN: hex.EncodeToString(autn)})
+	}))
+	t.Cleanup(s.Close)
+	a := newTestAuthenticator(t, newTestSIM(t), s.URL)
+
+	_, err = a.DoAuthentication()
+	if err == nil || !strings.Contains(err.Error(), "not a stand-in") || krypton.Category(err) != krypton.ErrorCategoryServer {
+		t.Errorf("expected an error for a server which is not a stand-in, got %v", err)
+	}
+	if verified {
+		t.Error("RES was sent to a server which is not a stand-in")
+	}
+}
+
+func TestAuthenticatorPostWithSignature(t *testing.T) {
+	ck := unhex(t, "b40ba9a3c58b2a05bbf0d987b21bf8cb")
+	var header http.Header
+	var body []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer s.Close()
+
+	a := newTestAuthenticator(t, newTestSIM(t), s.URL)
+	u, _ := url.Parse(s.URL + "/v1/provisioning/soracom/air/subscriber_metadata")
+	resp, err := a.PostWithSignature(u, ck, map[string]string{"keyId": "key/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if string(body) != `{"keyId":"key/1"}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+	expected, err := Sign("SHA-256", ck, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get(SignatureHeader) != expected || header.Get(SignatureAlgorithmHeader) != "SHA-256" {
+		t.Errorf("unexpected signature headers: %v", header)
+	}
+
+	if _, err = NewAuthenticator(newTestSIM(t), &Config{KeysAPIEndpointURL: u, SignatureAlgorithm: "MD5"}); err == nil {
+		t.Error("unsupported signature algorithm was accepted")
+	}
+	if _, err = NewAuthenticator(newTestSIM(t), &Config{}); err == nil {
+		t.Error("missing endpoint was accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package krypton
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/soracom/krypton-client-go/krypton"
+
+// ContextAuthenticator is an Authenticator which sends signed requests with a context. The context of the span of
+// a provisioning API request is passed so that the transport, e.g. TracingTransport, can propagate it.
+type ContextAuthenticator interface {
+	Authenticator
+	PostWithSignatureContext(ctx context.Context, u *url.URL, ck []byte, body interface{}) (*http.Response, error)
+}
+
+// postWithSignature sends the request with ctx when a supports it. Otherwise, e.g. with *endorse.Client, which does
+// not take a context, the request is sent while t propagates ctx to the requests to u.
+func postWithSignature(ctx context.Context, a Authenticator, t *TracingTransport, u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	if ca, ok := a.(ContextAuthenticator); ok {
+		return ca.PostWithSignatureContext(ctx, u, ck, body)
+	}
+	if t == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return a.PostWithSignature(u, ck, body)
+	}
+	var resp *http.Response
+	var err error
+	t.withContext(ctx, u, func() {
+		resp, err = a.PostWithSignature(u, ck, body)
+	})
+	return resp, err
+}
+
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+func (c *Client) startSpan(name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (*Client, trace.Span) {
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := c.tracer.Start(parent, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	cc := *c
+	cc.ctx = ctx
+	return &cc, span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracingTransport is an http.RoundTripper which injects the W3C trace context in the context of a request into its
+// headers. Set it to the transport of the http.Client used by a ContextAuthenticator, e.g. simulator.Config.HTTPClient.
+//
+// Requests without a context, such as the ones of endorse-client-go which uses the default transport of net/http,
+// carry the trace context of the provisioning API request to the same URL which Client sends through the transport.
+// Set the transport to Config.TracingTransport for this, e.g.
+//
+//	t := krypton.NewTracingTransport(http.DefaultTransport)
+//	http.DefaultTransport = t
+//	cfg.TracingTransport = t
+type TracingTransport struct {
+	Base       http.RoundTripper
+	Propagator propagation.TextMapPropagator
+
+	// send makes the requests without a context one at a time so that each carries its own trace context
+	send    sync.Mutex
+	mu      sync.Mutex
+	pending *pendingRequest
+}
+
+// pendingRequest is a request without a context which is being sent, and the context to propagate.
+type pendingRequest struct {
+	ctx context.Context
+	u   *url.URL
+}
+
+// NewTracingTransport returns a TracingTransport which propagates the trace context with base.
+func NewTracingTransport(base http.RoundTripper) *TracingTransport {
+	return &TracingTransport{
+		Base:       base,
+		Propagator: propagation.TraceContext{},
+	}
+}
+
+// withContext calls send while the requests to u without a context carry the trace context of ctx.
+func (t *TracingTransport) withContext(ctx context.Context, u *url.URL, send func()) {
+	t.send.Lock()
+	defer t.send.Unlock()
+	t.mu.Lock()
+	t.pending = &pendingRequest{ctx: ctx, u: u}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.pending = nil
+		t.mu.Unlock()
+	}()
+	send()
+}
+
+// requestContext returns the context whose trace context is propagated with req.
+func (t *TracingTransport) requestContext(req *http.Request) context.Context {
+	ctx := req.Context()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending != nil && t.pending.u.Host == req.URL.Host && t.pending.u.Path == req.URL.Path {
+		return t.pending.ctx
+	}
+	return ctx
+}
+
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ctx := t.requestContext(req); trace.SpanContextFromContext(ctx).IsValid() {
+		req = req.Clone(req.Context())
+		t.Propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,153 @@
+package krypton
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// contextAuthenticator sends requests with the context through a TracingTransport.
+type contextAuthenticator struct {
+	fakeAuthenticator
+	client *http.Client
+}
+
+func (a *contextAuthenticator) PostWithSignatureContext(ctx context.Context, u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return a.client.Do(req)
+}
+
+func TestTracingPropagatesContextPerClient(t *testing.T) {
+	var mu sync.Mutex
+	traceparents := map[string]bool{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.Header.Get("traceparent")] = true
+		mu.Unlock()
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: NewTracingTransport(http.DefaultTransport)}
+	exporters := []*tracetest.InMemoryExporter{tracetest.NewInMemoryExporter(), tracetest.NewInMemoryExporter()}
+	var wg sync.WaitGroup
+	for _, e := range exporters {
+		c := newTestClient(t, s.URL, "", &contextAuthenticator{client: client})
+		c.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(e)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, e := range exporters {
+		var found bool
+		for _, span := range e.GetSpans() {
+			if strings.HasPrefix(span.Name, "POST ") {
+				sc := span.SpanContext
+				found = traceparents["00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01"]
+			}
+		}
+		if !found {
+			t.Errorf("the context of the request span is not propagated: %v", traceparents)
+		}
+	}
+
+	// the requests of an Authenticator without a context carry no trace context unless it is given the transport
+	traceparents = map[string]bool{}
+	c := newTestClient(t, s.URL, "", &fakeAuthenticator{})
+	c.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewInMemoryExporter())))
+	if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err != nil {
+		t.Fatal(err)
+	}
+	if !traceparents[""] || len(traceparents) != 1 {
+		t.Errorf("unexpected trace context: %v", traceparents)
+	}
+}
+
+// clientAuthenticator sends requests without a context with its HTTP client, as endorse-client-go does.
+type clientAuthenticator struct {
+	fakeAuthenticator
+	client *http.Client
+}
+
+func (a *clientAuthenticator) PostWithSignature(u *url.URL, ck []byte, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return a.client.Post(u.String(), "application/json", bytes.NewReader(b))
+}
+
+func TestTracingPropagatesContextWithoutContextAuthenticator(t *testing.T) {
+	var traceparent string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		io.WriteString(w, `{"imsi":"440100000000000"}`)
+	}))
+	defer s.Close()
+
+	transport := NewTracingTransport(http.DefaultTransport)
+	a := &clientAuthenticator{client: &http.Client{Transport: transport}}
+	e := tracetest.NewInMemoryExporter()
+	c := newTestClient(t, s.URL, "", a)
+	c.cfg.TracingTransport = transport
+	c.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(e)))
+	if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err != nil {
+		t.Fatal(err)
+	}
+
+	var want string
+	for _, span := range e.GetSpans() {
+		if strings.HasPrefix(span.Name, "POST ") {
+			want = "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+		}
+	}
+	if want == "" || traceparent != want {
+		t.Errorf("unexpected trace context: %q, want %q", traceparent, want)
+	}
+
+	// other requests through the transport do not carry it
+	traceparent = ""
+	resp, err := a.client.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if traceparent != "" {
+		t.Errorf("the trace context is sent with another request: %s", traceparent)
+	}
+}
+
+func TestTracingEndsSpansWithoutAuthenticator(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	c := newTestClient(t, "http://127.0.0.1:0/", "", nil)
+	c.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	if _, err := c.PerformOperationWithResult("getSubscriberMetadata"); err == nil {
+		t.Fatal("no error without an Authenticator")
+	}
+	if len(sr.Started()) != len(sr.Ended()) {
+		t.Errorf("%d spans are started but %d are ended", len(sr.Started()), len(sr.Ended()))
+	}
+}